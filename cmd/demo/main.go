@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/orchard9/tui-diagrams/pkg/diagrams"
@@ -12,6 +13,10 @@ func main() {
 	fmt.Println("Terminal-friendly diagram rendering for Go")
 	fmt.Println()
 
+	// Bar colours are converted to what the terminal supports, and dropped
+	// when the output is redirected
+	colors := diagrams.RenderOptions{ColorProfile: diagrams.DetectColorProfile(os.Stdout)}
+
 	// Flowchart: User Authentication Flow
 	printSection("FLOWCHART: User Authentication Flow")
 	authFlow := diagrams.NewFlowchart(diagrams.TopToBottom)
@@ -109,7 +114,7 @@ func main() {
 									SetWidth(9).
 									SetHeight(15)
 
-	fmt.Println(velocityChart.Render())
+	fmt.Println(velocityChart.RenderWithOptions(colors))
 	fmt.Println()
 
 	// Horizontal Bar Chart: System Resource Usage
@@ -122,7 +127,7 @@ func main() {
 									AddBarWithColor("GPU Usage", 91, "\x1b[35m").    // Magenta (very high)
 									SetWidth(40)
 
-	fmt.Println(resourceChart.Render())
+	fmt.Println(resourceChart.RenderWithOptions(colors))
 	fmt.Println()
 
 	// Comparison Chart: Before/After Optimization
@@ -136,7 +141,7 @@ func main() {
 										AddBarWithColor("Error Rate (After)", 1, "\x1b[32m").      // Green
 										SetWidth(35)
 
-	fmt.Println(perfChart.Render())
+	fmt.Println(perfChart.RenderWithOptions(colors))
 	fmt.Println()
 
 	// Project Status Dashboard
@@ -149,7 +154,7 @@ func main() {
 										AddBarWithColor("Ready to Start", 34, "\x1b[34m"). // Blue
 										SetWidth(35)
 
-	fmt.Println(projectChart.Render())
+	fmt.Println(projectChart.RenderWithOptions(colors))
 	fmt.Println()
 
 	// Team Distribution Chart
@@ -164,7 +169,34 @@ func main() {
 									SetWidth(11).
 									SetHeight(14)
 
-	fmt.Println(teamChart.Render())
+	fmt.Println(teamChart.RenderWithOptions(colors))
+	fmt.Println()
+
+	// Dashboard of several diagrams
+	printSection("COMPOSITION: Dashboard Grid")
+	latency := diagrams.NewBarChart("p99 Latency (ms)", diagrams.Horizontal).
+		AddBar("api", 120).
+		AddBar("auth", 45).
+		AddBar("search", 310).
+		SetWidth(20)
+	errorRate := diagrams.NewBarChart("Errors/min", diagrams.Vertical).
+		AddBar("api", 4).
+		AddBar("auth", 1).
+		AddBar("search", 9).
+		SetWidth(6).
+		SetHeight(4)
+	deploy := diagrams.NewFlowchart(diagrams.LeftToRight).
+		AddNode("build", "Build", diagrams.ShapeRounded).
+		AddNode("test", "Test", diagrams.ShapeBox).
+		AddNode("ship", "Ship", diagrams.ShapeRounded)
+	deploy.AddEdge("build", "test", "").AddEdge("test", "ship", "")
+
+	dashboard := diagrams.NewGrid()
+	dashboard.Add(0, 0, latency)
+	dashboard.Add(0, 1, errorRate).SetAlign(diagrams.AlignCenter, diagrams.AlignStart)
+	dashboard.Add(1, 0, deploy).Span(1, 2).SetAlign(diagrams.AlignCenter, diagrams.AlignStart)
+
+	fmt.Println(dashboard.RenderWithOptions(diagrams.RenderOptions{MaxWidth: 80}))
 	fmt.Println()
 
 	// Mermaid Syntax Examples
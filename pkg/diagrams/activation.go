@@ -0,0 +1,92 @@
+package diagrams
+
+import "sort"
+
+// Activation starts or ends a period in which an actor is active, such as
+// while it handles a request. Activations of an actor that is already active
+// nest, and each level is drawn as a bar beside the one it is nested in.
+type Activation struct {
+	Actor  string
+	Active bool // True to activate the actor, false to end its latest activation
+	At     int  // Number of messages and notes drawn before it
+}
+
+// Activate makes an actor active from the last message or note added so far,
+// like Mermaid's activate or the + of A->>+B, or from the top of its lifeline
+// if there is none
+func (s *SequenceDiagram) Activate(actor string) *SequenceDiagram {
+	s.Activations = append(s.Activations, Activation{Actor: actor, Active: true, At: s.position()})
+	return s
+}
+
+// Deactivate ends an actor's latest activation at the last message or note
+// added so far, like Mermaid's deactivate or the - of B-->>-A
+func (s *SequenceDiagram) Deactivate(actor string) *SequenceDiagram {
+	s.Activations = append(s.Activations, Activation{Actor: actor, At: s.position()})
+	return s
+}
+
+// activations returns the activations in order of their At
+func (s *SequenceDiagram) activations() []Activation {
+	activations := append([]Activation(nil), s.Activations...)
+	sort.SliceStable(activations, func(i, j int) bool { return activations[i].At < activations[j].At })
+	return activations
+}
+
+// seqBar is an activation bar: the actor's column, its nesting depth from 1
+// and its first and last rows
+type seqBar struct {
+	actor, depth int
+	from, to     int
+}
+
+// activationBars returns the bars of the diagram's activations. anchors holds
+// the row each message or note ends on: an activation starts or ends on the
+// row of the message or note before it, or on row top if there is none. An
+// activation that is never ended runs to row bottom.
+func (s *SequenceDiagram) activationBars(anchors []int, top, bottom int, index map[string]int) []seqBar {
+	anchor := func(at int) int {
+		if at <= 0 || len(anchors) == 0 {
+			return top
+		}
+		return anchors[min(at, len(anchors))-1]
+	}
+
+	var bars []seqBar
+	open := make(map[int][]int) // Start rows of each actor's open activations
+	for _, a := range s.activations() {
+		i, ok := index[a.Actor]
+		if !ok {
+			continue
+		}
+		depth := len(open[i])
+		switch {
+		case a.Active:
+			open[i] = append(open[i], anchor(a.At))
+		case depth > 0:
+			bars = append(bars, seqBar{i, depth, open[i][depth-1], anchor(a.At)})
+			open[i] = open[i][:depth-1]
+		}
+	}
+	for i, starts := range open {
+		for depth, from := range starts {
+			bars = append(bars, seqBar{i, depth + 1, from, bottom})
+		}
+	}
+	return bars
+}
+
+// drawActivation draws a bar on the lifeline at column x, or beside it for a
+// nested activation. Drawn again over the messages, it only restores the bar
+// where arrows leave or cross it, keeping arrowheads, labels and notes.
+func drawActivation(c *Canvas, x int, bar seqBar, style Style, over bool) {
+	x += bar.depth - 1
+	for y := bar.from; y <= bar.to; y++ {
+		switch {
+		case !over:
+			c.SetLine(x, y, "┃", style)
+		case c.writable(x, y) && lineMasks[c.cells[y][x].Char]&(lineUp|lineDown) == lineUp|lineDown:
+			c.Set(x, y, "┃", style)
+		}
+	}
+}
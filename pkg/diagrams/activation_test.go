@@ -0,0 +1,75 @@
+package diagrams
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSequenceDiagram_Activate(t *testing.T) {
+	seq := NewSequenceDiagram().AddActor("A", "Alice").AddActor("B", "Bob").
+		AddMessage("A", "B", "call", MessageSync).Activate("B").
+		AddMessage("B", "B", "work", MessageSync).Activate("B").
+		AddMessage("B", "A", "reply", MessageReturn).Deactivate("B").Deactivate("B").
+		Deactivate("A").
+		Activate("A")
+
+	// A message takes rows 3-4, 5-6 and 7-8 and the lifelines end on row 9
+	bars := seq.activationBars([]int{4, 6, 8}, 2, 9, seq.actorIndex())
+	expected := []seqBar{{1, 2, 6, 8}, {1, 1, 4, 8}, {0, 1, 8, 9}}
+	if !reflect.DeepEqual(bars, expected) {
+		t.Errorf("Expected bars %+v, got %+v", expected, bars)
+	}
+}
+
+func TestSequenceDiagram_Render_Activations(t *testing.T) {
+	seq := NewSequenceDiagram().AddActor("U", "User").AddActor("API", "API").AddActor("DB", "DB").
+		AddMessage("U", "API", "GET /orders", MessageSync).Activate("API").
+		AddMessage("API", "DB", "SELECT", MessageSync).Activate("DB").Activate("API").
+		AddMessage("API", "API", "cache", MessageSync).Deactivate("API").
+		AddMessage("DB", "API", "rows", MessageReturn).Deactivate("DB").
+		AddMessage("API", "U", "200 OK", MessageReturn).Deactivate("API")
+
+	expected := strings.Join([]string{
+		"    User              API                DB",
+		"┌─────┬────┐      ┌─────┬────┐      ┌─────┬────┐",
+		"      │                 │                 │",
+		"      ├── GET /orders ─→┃                 │",
+		"      │                 ┃                 │",
+		"      │                 ┃┃─── SELECT ────→┃",
+		"      │                 ┃┃                ┃",
+		"      │                 ┃→[cache]         ┃",
+		"      │                 ┃                 ┃",
+		"      │                 ┃←┄┄┄┄ rows ┄┄┄┄┄┄┃",
+		"      │                 ┃                 │",
+		"      │←┄┄┄ 200 OK ┄┄┄┄┄┃                 │",
+		"      │                 │                 │",
+		"└─────┴────┘      └─────┴────┘      └─────┴────┘",
+	}, "\n")
+	if got := seq.Render(); got != expected {
+		t.Errorf("Expected:\n%s\n\nGot:\n%s", expected, got)
+	}
+}
+
+func TestParseMermaidSequence_Activations(t *testing.T) {
+	seq, err := ParseMermaidSequence(`sequenceDiagram
+    activate A
+    A->>+B: call
+    B-->>-A: reply
+    deactivate A`)
+	if err != nil {
+		t.Fatalf("ParseMermaidSequence failed: %v", err)
+	}
+
+	expected := []Activation{{"A", true, 0}, {"B", true, 1}, {"B", false, 2}, {"A", false, 2}}
+	if !reflect.DeepEqual(seq.Activations, expected) {
+		t.Errorf("Expected activations %+v, got %+v", expected, seq.Activations)
+	}
+	if seq.Messages[0].Type != MessageSync || seq.Messages[1].Type != MessageReturn {
+		t.Errorf("Expected the + and - to leave the arrows' types, got %+v", seq.Messages)
+	}
+
+	if _, err := ParseMermaidSequence("sequenceDiagram\n    activate"); err == nil {
+		t.Error("Expected an error for activate without a participant")
+	}
+}
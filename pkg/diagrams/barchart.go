@@ -2,6 +2,7 @@ package diagrams
 
 import (
 	"fmt"
+	"io"
 	"math"
 	"strings"
 )
@@ -20,7 +21,7 @@ const (
 type Bar struct {
 	Label string
 	Value float64
-	Color string // ANSI color code (optional)
+	Color string // ANSI color code, such as "\x1b[32m" or RGB(0, 200, 80).Fg() (optional)
 }
 
 // BarChart represents a bar chart
@@ -84,160 +85,211 @@ func (b *BarChart) SetShowValues(show bool) *BarChart {
 
 // Render converts the bar chart to ASCII art
 func (b *BarChart) Render() string {
-	if len(b.Bars) == 0 {
-		return ""
-	}
+	return b.RenderWithOptions(RenderOptions{})
+}
 
-	if b.Orientation == Horizontal {
-		return b.renderHorizontal()
-	}
-	return b.renderVertical()
+// RenderWithOptions renders the bar chart like Render, in the options' theme,
+// charset and colour profile. Bars without a Color take the theme's palette
+// colour for their position. A chart wider than MaxWidth gets shorter
+// horizontal bars, or narrower vertical bars closer together, and a vertical
+// chart taller than MaxHeight gets shorter bars.
+func (b *BarChart) RenderWithOptions(opts RenderOptions) string {
+	return b.canvas(opts).render(opts)
 }
 
-func (b *BarChart) renderHorizontal() string {
-	var output strings.Builder
+// RenderTo writes the bar chart to w as RenderWithOptions renders it, one row
+// at a time, and returns the number of bytes written
+func (b *BarChart) RenderTo(w io.Writer, opts RenderOptions) (int64, error) {
+	return b.canvas(opts).writeTo(w, opts)
+}
 
-	// Title
-	if b.Title != "" {
-		output.WriteString(b.Title)
-		output.WriteString("\n")
-		output.WriteString(strings.Repeat("=", len(b.Title)))
-		output.WriteString("\n\n")
-	}
+// WriteTo writes the bar chart to w as Render renders it, implementing io.WriterTo
+func (b *BarChart) WriteTo(w io.Writer) (int64, error) {
+	return b.RenderTo(w, RenderOptions{})
+}
 
-	// Find max value for scaling
-	maxValue := 0.0
-	for _, bar := range b.Bars {
-		if bar.Value > maxValue {
-			maxValue = bar.Value
-		}
+// Size returns the columns and rows the bar chart takes when rendered with opts
+func (b *BarChart) Size(opts RenderOptions) (int, int) {
+	if len(b.Bars) == 0 {
+		return 0, 0
 	}
+	l := b.layout(opts)
+	return opts.clamp(l.width, l.height)
+}
 
-	// Find max label width
-	maxLabelWidth := 0
-	for _, bar := range b.Bars {
-		if len(bar.Label) > maxLabelWidth {
-			maxLabelWidth = len(bar.Label)
-		}
-	}
+// barLayout holds the sizes a bar chart is drawn with, fitted to the
+// options' maximum size
+type barLayout struct {
+	labelWidth int // Width of the label column of a horizontal chart
+	length     int // Length of the longest bar
+	barWidth   int // Width of the bars of a vertical chart
+	gap        int // Columns between the bars of a vertical chart
+	width      int
+	height     int
+}
 
-	// Render each bar
-	for _, bar := range b.Bars {
-		// Label (right-padded)
-		output.WriteString(padRight(bar.Label, maxLabelWidth))
-		output.WriteString(" ")
-		output.WriteString(BoxVertical)
-		output.WriteString(" ")
+// layout sizes the chart, shrinking bars and labels to fit the maximum size
+func (b *BarChart) layout(opts RenderOptions) barLayout {
+	_, valueWidth := b.valueRange()
+	var l barLayout
 
-		// Bar
-		barLength := int((bar.Value / maxValue) * float64(b.Width))
-		if bar.Color != "" {
-			output.WriteString(bar.Color)
+	if b.Orientation == Horizontal {
+		l.length = b.Width
+		for _, bar := range b.Bars {
+			l.labelWidth = max(l.labelWidth, StringWidth(bar.Label))
 		}
-		output.WriteString(strings.Repeat("█", barLength))
-		if bar.Color != "" {
-			output.WriteString("\x1b[0m") // Reset color
+		// Label, " │ ", bar, space and value
+		fixed := 4 + valueWidth
+		if opts.MaxWidth > 0 && l.labelWidth+fixed+l.length > opts.MaxWidth {
+			l.length = max(1, opts.MaxWidth-l.labelWidth-fixed)
+			l.labelWidth = max(1, min(l.labelWidth, opts.MaxWidth-fixed-l.length))
 		}
-
-		// Value
+		l.width = l.labelWidth + fixed + l.length
+		l.height = b.titleHeight() + len(b.Bars)
+	} else {
+		n := len(b.Bars)
+		l.length, l.barWidth, l.gap = b.Height, max(b.Width, 3), 2
+		if opts.MaxWidth > 0 && n*l.barWidth+(n-1)*l.gap > opts.MaxWidth {
+			l.gap = 1
+			l.barWidth = max(1, (opts.MaxWidth-(n-1)*l.gap)/n)
+		}
+		// Bars, baseline, labels and values
+		fixed := b.titleHeight() + 3
 		if b.ShowValues {
-			output.WriteString(" ")
-			output.WriteString(formatValue(bar.Value))
+			fixed++
 		}
+		if opts.MaxHeight > 0 && fixed+l.length > opts.MaxHeight {
+			l.length = max(1, opts.MaxHeight-fixed)
+		}
+		l.width = n*l.barWidth + (n-1)*l.gap
+		l.height = fixed + l.length
+	}
 
-		output.WriteString("\n")
+	l.width = max(l.width, StringWidth(b.Title))
+	if opts.MaxWidth > 0 {
+		l.width = min(l.width, opts.MaxWidth)
 	}
+	return l
+}
 
-	return output.String()
+// canvas draws the chart in its orientation, on an empty canvas when it has
+// no bars
+func (b *BarChart) canvas(opts RenderOptions) *Canvas {
+	if len(b.Bars) == 0 {
+		return NewCanvas(0, 0)
+	}
+	l := b.layout(opts)
+	if b.Orientation == Horizontal {
+		return b.drawHorizontal(l, opts.theme())
+	}
+	return b.drawVertical(l, opts.theme())
 }
 
-func (b *BarChart) renderVertical() string {
-	var output strings.Builder
+func (b *BarChart) drawHorizontal(l barLayout, theme *Theme) *Canvas {
+	maxValue, _ := b.valueRange()
+	barX := l.labelWidth + 3
+	c := NewCanvas(l.width, l.height)
+	top := b.drawTitle(c, theme)
 
-	// Title
-	if b.Title != "" {
-		output.WriteString(b.Title)
-		output.WriteString("\n")
-		output.WriteString(strings.Repeat("=", len(b.Title)))
-		output.WriteString("\n\n")
-	}
+	// Render each bar
+	for i, bar := range b.Bars {
+		row := top + i
 
-	// Find max value for scaling
-	maxValue := 0.0
-	for _, bar := range b.Bars {
-		if bar.Value > maxValue {
-			maxValue = bar.Value
+		// Label (right-padded) and separator
+		c.Text(0, row, padRight(Truncate(bar.Label, l.labelWidth), l.labelWidth), theme.Text)
+		c.Set(l.labelWidth+1, row, BoxVertical, theme.Axis)
+
+		// Bar
+		barLength := scaleBar(bar.Value, maxValue, l.length)
+		c.Fill(barX, row, barLength, 1, "█", Style{Fg: theme.barColor(i, bar)})
+
+		// Value
+		if b.ShowValues {
+			c.Text(barX+barLength+1, row, formatValue(bar.Value), theme.Text)
 		}
 	}
 
-	barWidth := b.Width
-	if barWidth < 3 {
-		barWidth = 3
-	}
+	return c
+}
 
-	// Render from top to bottom
-	for row := b.Height; row >= 0; row-- {
-		threshold := (float64(row) / float64(b.Height)) * maxValue
+func (b *BarChart) drawVertical(l barLayout, theme *Theme) *Canvas {
+	maxValue, _ := b.valueRange()
+	totalWidth := len(b.Bars)*l.barWidth + (len(b.Bars)-1)*l.gap
+	c := NewCanvas(l.width, l.height)
+	top := b.drawTitle(c, theme)
+	baseline := top + l.length + 1
 
-		for i, bar := range b.Bars {
-			if i > 0 {
-				output.WriteString("  ")
-			}
+	for i, bar := range b.Bars {
+		x := i * (l.barWidth + l.gap)
 
+		// Render from top to bottom
+		for row := l.length; row >= 0; row-- {
+			threshold := (float64(row) / float64(l.length)) * maxValue
 			if bar.Value >= threshold {
-				// Draw bar segment
-				if bar.Color != "" {
-					output.WriteString(bar.Color)
-				}
-				output.WriteString(strings.Repeat("█", barWidth))
-				if bar.Color != "" {
-					output.WriteString("\x1b[0m")
-				}
-			} else {
-				// Empty space
-				output.WriteString(strings.Repeat(" ", barWidth))
+				c.Fill(x, baseline-1-row, l.barWidth, 1, "█", Style{Fg: theme.barColor(i, bar)})
 			}
 		}
-		output.WriteString("\n")
+
+		// Labels, shortened to the bar's width
+		c.Text(x, baseline+1, padCenter(bar.Label, l.barWidth), theme.Text)
+
+		// Values (if enabled)
+		if b.ShowValues {
+			c.Text(x, baseline+2, padCenter(formatValue(bar.Value), l.barWidth), theme.Text)
+		}
 	}
 
 	// Baseline
-	totalWidth := len(b.Bars)*barWidth + (len(b.Bars)-1)*2
-	output.WriteString(strings.Repeat(BoxHorizontal, totalWidth))
-	output.WriteString("\n")
+	c.HLine(0, totalWidth-1, baseline, theme.Axis)
 
-	// Labels
-	for i, bar := range b.Bars {
-		if i > 0 {
-			output.WriteString("  ")
-		}
-		label := bar.Label
-		if len(label) > barWidth {
-			label = label[:barWidth]
+	return c
+}
+
+// valueRange returns the largest bar value and the widest formatted value
+func (b *BarChart) valueRange() (float64, int) {
+	maxValue, maxWidth := 0.0, 0
+	for _, bar := range b.Bars {
+		if bar.Value > maxValue {
+			maxValue = bar.Value
 		}
-		output.WriteString(padCenter(label, barWidth))
+		maxWidth = max(maxWidth, StringWidth(formatValue(bar.Value)))
 	}
-	output.WriteString("\n")
+	return maxValue, maxWidth
+}
 
-	// Values (if enabled)
-	if b.ShowValues {
-		for i, bar := range b.Bars {
-			if i > 0 {
-				output.WriteString("  ")
-			}
-			value := formatValue(bar.Value)
-			output.WriteString(padCenter(value, barWidth))
-		}
+// titleHeight returns the number of rows used by the title block
+func (b *BarChart) titleHeight() int {
+	if b.Title == "" {
+		return 0
+	}
+	return 3
+}
+
+// drawTitle draws the underlined title and returns the first row below it
+func (b *BarChart) drawTitle(c *Canvas, theme *Theme) int {
+	if b.Title == "" {
+		return 0
 	}
+	title := Truncate(b.Title, c.Width())
+	c.Text(0, 0, title, theme.Title)
+	c.Text(0, 1, strings.Repeat("=", StringWidth(title)), theme.Title)
+	return b.titleHeight()
+}
 
-	return output.String()
+// scaleBar returns the length of a bar scaled so that maxValue fills size cells
+func scaleBar(value, maxValue float64, size int) int {
+	if maxValue <= 0 || value <= 0 {
+		return 0
+	}
+	return int((value / maxValue) * float64(size))
 }
 
+// padRight pads s with spaces to width columns
 func padRight(s string, width int) string {
-	if len(s) >= width {
-		return s
+	if w := StringWidth(s); w < width {
+		return s + strings.Repeat(" ", width-w)
 	}
-	return s + strings.Repeat(" ", width-len(s))
+	return s
 }
 
 func formatValue(value float64) string {
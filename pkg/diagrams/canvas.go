@@ -0,0 +1,559 @@
+package diagrams
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// Style describes how a canvas cell is displayed.
+// The zero value is the terminal's default style.
+type Style struct {
+	Fg        string // ANSI escape sequence for the foreground colour, e.g. "\x1b[31m"
+	Bg        string // ANSI escape sequence for the background colour, e.g. "\x1b[44m"
+	Bold      bool
+	Italic    bool
+	Underline bool
+}
+
+// IsZero reports whether the style is the terminal default
+func (s Style) IsZero() bool {
+	return s == Style{}
+}
+
+// over returns the style drawn over base: colours it doesn't set and
+// attributes it doesn't turn on are taken from base
+func (s Style) over(base Style) Style {
+	if s.Fg == "" {
+		s.Fg = base.Fg
+	}
+	if s.Bg == "" {
+		s.Bg = base.Bg
+	}
+	s.Bold = s.Bold || base.Bold
+	s.Italic = s.Italic || base.Italic
+	s.Underline = s.Underline || base.Underline
+	return s
+}
+
+// invisible reports whether a space drawn in this style shows nothing: it has
+// no background colour or underline, whatever its foreground
+func (s Style) invisible() bool {
+	return s.Bg == "" && !s.Underline
+}
+
+// sequence returns the escape sequence that switches the terminal to this style
+func (s Style) sequence() string {
+	var b strings.Builder
+	if s.Bold {
+		b.WriteString("\x1b[1m")
+	}
+	if s.Italic {
+		b.WriteString("\x1b[3m")
+	}
+	if s.Underline {
+		b.WriteString("\x1b[4m")
+	}
+	b.WriteString(s.Fg)
+	b.WriteString(s.Bg)
+	return b.String()
+}
+
+// Cell is a single character position on a Canvas
+type Cell struct {
+	Char  string // A grapheme cluster; empty in the second cell of a wide character
+	Style Style
+	text  bool // Written by Text, which a Charset leaves as it is
+}
+
+// Point is a position on a Canvas
+type Point struct {
+	X int
+	Y int
+}
+
+// Canvas is a fixed-size grid of styled character cells.
+//
+// Drawing outside the canvas, or outside the current clip rectangle, is
+// silently ignored. Lines drawn with HLine, VLine, Path, LinePath and Box
+// merge with the box-drawing characters already on the canvas, so a
+// horizontal line crossing a vertical one becomes ┼ and a line meeting a box
+// edge becomes a tee.
+type Canvas struct {
+	width  int
+	height int
+	cells  [][]Cell
+	clip   rect
+}
+
+type rect struct {
+	x, y, width, height int
+}
+
+func (r rect) contains(x, y int) bool {
+	return x >= r.x && y >= r.y && x < r.x+r.width && y < r.y+r.height
+}
+
+// NewCanvas creates a blank canvas of the given size
+func NewCanvas(width, height int) *Canvas {
+	width, height = max(width, 0), max(height, 0)
+	cells := make([][]Cell, height)
+	for y := range cells {
+		cells[y] = make([]Cell, width)
+		for x := range cells[y] {
+			cells[y][x] = Cell{Char: " "}
+		}
+	}
+	return &Canvas{
+		width:  width,
+		height: height,
+		cells:  cells,
+		clip:   rect{0, 0, width, height},
+	}
+}
+
+// Width returns the number of columns
+func (c *Canvas) Width() int {
+	return c.width
+}
+
+// Height returns the number of rows
+func (c *Canvas) Height() int {
+	return c.height
+}
+
+// Cell returns the cell at (x, y), or a blank cell outside the canvas
+func (c *Canvas) Cell(x, y int) Cell {
+	if x < 0 || y < 0 || x >= c.width || y >= c.height {
+		return Cell{Char: " "}
+	}
+	return c.cells[y][x]
+}
+
+// SetClip restricts all further drawing to the given rectangle
+func (c *Canvas) SetClip(x, y, width, height int) *Canvas {
+	c.clip = rect{x, y, width, height}
+	return c
+}
+
+// ResetClip allows drawing on the whole canvas again
+func (c *Canvas) ResetClip() *Canvas {
+	c.clip = rect{0, 0, c.width, c.height}
+	return c
+}
+
+func (c *Canvas) writable(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.width && y < c.height && c.clip.contains(x, y)
+}
+
+// Set writes a single character at (x, y), replacing whatever was there
+func (c *Canvas) Set(x, y int, char string, style Style) *Canvas {
+	if c.writable(x, y) {
+		c.clearWide(x, y)
+		c.cells[y][x] = Cell{Char: char, Style: style}
+	}
+	return c
+}
+
+// clearWide blanks the other half of a wide character that is about to be
+// partly overwritten at (x, y)
+func (c *Canvas) clearWide(x, y int) {
+	row := c.cells[y]
+	if row[x].Char == "" && x > 0 {
+		row[x-1].Char = " "
+	}
+	if x+1 < len(row) && row[x+1].Char == "" {
+		row[x+1].Char = " "
+	}
+}
+
+// SetLine writes a box-drawing character at (x, y), merging it with any
+// box-drawing character already there (for example ─ over │ becomes ┼)
+func (c *Canvas) SetLine(x, y int, char string, style Style) *Canvas {
+	if !c.writable(x, y) {
+		return c
+	}
+	c.clearWide(x, y)
+	c.cells[y][x] = Cell{Char: mergeLine(c.cells[y][x].Char, char), Style: style}
+	return c
+}
+
+// Text writes text left to right starting at (x, y), one grapheme cluster
+// per cell. Wide characters take two cells, and characters without width,
+// such as control characters, are skipped. SGR escape sequences in the text
+// change the style of the cells after them, starting from style, and other
+// escape sequences are dropped. A Charset doesn't replace the characters of
+// text, apart from the ellipsis Truncate ends it with.
+func (c *Canvas) Text(x, y int, text string, style Style) *Canvas {
+	return c.write(x, y, text, style, true)
+}
+
+// glyphs writes symbols drawn as part of a diagram, such as arrows, like Text
+// but in the characters of the Charset they're rendered in
+func (c *Canvas) glyphs(x, y int, text string, style Style) *Canvas {
+	return c.write(x, y, text, style, false)
+}
+
+// write writes text as Text does, marking its cells as text or not
+func (c *Canvas) write(x, y int, text string, style Style, asText bool) *Canvas {
+	for _, run := range ParseStyledText(text, style) {
+		for _, g := range graphemes(run.Text) {
+			width := graphemeWidth(g)
+			switch {
+			case width == 0:
+				continue
+			case width == 2 && !c.writable(x+1, y):
+				// Half a wide character can't be drawn
+				g = " "
+			}
+			c.Set(x, y, g, run.Style)
+			if width == 2 {
+				c.Set(x+1, y, "", run.Style)
+			}
+			for i := 0; i < width; i++ {
+				if c.writable(x+i, y) {
+					c.cells[y][x+i].text = asText
+				}
+			}
+			x += width
+		}
+	}
+	return c
+}
+
+// Fill sets every cell of a rectangle to char
+func (c *Canvas) Fill(x, y, width, height int, char string, style Style) *Canvas {
+	for row := y; row < y+height; row++ {
+		for col := x; col < x+width; col++ {
+			c.Set(col, row, char, style)
+		}
+	}
+	return c
+}
+
+// DrawCanvas copies another canvas onto this one with its top-left corner at (x, y)
+func (c *Canvas) DrawCanvas(x, y int, src *Canvas) *Canvas {
+	for row := 0; row < src.height; row++ {
+		for col := 0; col < src.width; col++ {
+			if c.writable(x+col, y+row) {
+				c.cells[y+row][x+col] = src.cells[row][col]
+			}
+		}
+	}
+	return c
+}
+
+// HLine draws a horizontal line between x1 and x2 (inclusive) on row y
+func (c *Canvas) HLine(x1, x2, y int, style Style) *Canvas {
+	if x1 > x2 {
+		x1, x2 = x2, x1
+	}
+	for x := x1; x <= x2; x++ {
+		c.SetLine(x, y, BoxHorizontal, style)
+	}
+	return c
+}
+
+// VLine draws a vertical line between y1 and y2 (inclusive) in column x
+func (c *Canvas) VLine(x, y1, y2 int, style Style) *Canvas {
+	if y1 > y2 {
+		y1, y2 = y2, y1
+	}
+	for y := y1; y <= y2; y++ {
+		c.SetLine(x, y, BoxVertical, style)
+	}
+	return c
+}
+
+// Path draws an orthogonal polyline through the given points.
+// Consecutive points must share a row or a column. Bends become corners and
+// the two ends stop at the middle of their cells, so a path that starts on a
+// box edge joins it with a tee.
+func (c *Canvas) Path(style Style, points ...Point) *Canvas {
+	return c.LinePath(LineLight, style, points...)
+}
+
+// LinePath draws an orthogonal polyline like Path, using heavy or dashed
+// characters for its straight runs and bends. The ends and any cells where
+// the path crosses or joins other lines are drawn with light characters.
+func (c *Canvas) LinePath(line LineStyle, style Style, points ...Point) *Canvas {
+	// Collect the connections of every cell first, so that bends get a
+	// single styled corner rather than two merged light halves
+	masks := make(map[Point]lineMask)
+	var cells []Point
+	add := func(x, y int, mask lineMask) {
+		p := Point{x, y}
+		if _, ok := masks[p]; !ok {
+			cells = append(cells, p)
+		}
+		masks[p] |= mask
+	}
+	for i := 0; i+1 < len(points); i++ {
+		a, b := points[i], points[i+1]
+		switch {
+		case a.Y == b.Y && a.X != b.X:
+			step, toward, back := 1, lineRight, lineLeft
+			if b.X < a.X {
+				step, toward, back = -1, lineLeft, lineRight
+			}
+			add(a.X, a.Y, toward)
+			for x := a.X + step; x != b.X; x += step {
+				add(x, a.Y, lineLeft|lineRight)
+			}
+			add(b.X, b.Y, back)
+		case a.X == b.X && a.Y != b.Y:
+			step, toward, back := 1, lineDown, lineUp
+			if b.Y < a.Y {
+				step, toward, back = -1, lineUp, lineDown
+			}
+			add(a.X, a.Y, toward)
+			for y := a.Y + step; y != b.Y; y += step {
+				add(a.X, y, lineUp|lineDown)
+			}
+			add(b.X, b.Y, back)
+		}
+	}
+
+	for _, p := range cells {
+		char := lineChars[masks[p]]
+		if styled, ok := styledLines[line][masks[p]]; ok {
+			char = styled
+		}
+		c.SetLine(p.X, p.Y, char, style)
+	}
+	return c
+}
+
+// Box draws a single-line rectangle outline
+func (c *Canvas) Box(x, y, width, height int, style Style) *Canvas {
+	return c.frame(x, y, width, height, BoxTopLeft, BoxTopRight, BoxBottomLeft, BoxBottomRight, style)
+}
+
+// RoundedBox draws a rectangle outline with rounded corners
+func (c *Canvas) RoundedBox(x, y, width, height int, style Style) *Canvas {
+	return c.frame(x, y, width, height, "╭", "╮", "╰", "╯", style)
+}
+
+func (c *Canvas) frame(x, y, width, height int, topLeft, topRight, bottomLeft, bottomRight string, style Style) *Canvas {
+	if width < 2 || height < 2 {
+		return c
+	}
+	right, bottom := x+width-1, y+height-1
+
+	if width > 2 {
+		c.HLine(x+1, right-1, y, style)
+		c.HLine(x+1, right-1, bottom, style)
+	}
+	if height > 2 {
+		c.VLine(x, y+1, bottom-1, style)
+		c.VLine(right, y+1, bottom-1, style)
+	}
+	c.SetLine(x, y, topLeft, style)
+	c.SetLine(right, y, topRight, style)
+	c.SetLine(x, bottom, bottomLeft, style)
+	c.SetLine(right, bottom, bottomRight, style)
+	return c
+}
+
+// String renders the canvas row by row, trimming trailing blank cells
+func (c *Canvas) String() string {
+	return c.render(RenderOptions{})
+}
+
+// Render implements Diagram so that a hand-drawn canvas can be used like any other diagram
+func (c *Canvas) Render() string {
+	return c.String()
+}
+
+// RenderWithOptions renders the canvas like String, in the options' charset
+// and with its colours converted for their colour profile. Cells beyond the
+// maximum width and height are cut off.
+func (c *Canvas) RenderWithOptions(opts RenderOptions) string {
+	return c.render(opts)
+}
+
+// RenderTo writes the canvas to w as RenderWithOptions renders it, one row at
+// a time, and returns the number of bytes written
+func (c *Canvas) RenderTo(w io.Writer, opts RenderOptions) (int64, error) {
+	return c.writeTo(w, opts)
+}
+
+// WriteTo writes the canvas to w as String renders it, implementing io.WriterTo
+func (c *Canvas) WriteTo(w io.Writer) (int64, error) {
+	return c.writeTo(w, RenderOptions{})
+}
+
+// canvas returns the canvas itself, so that composites copy its cells
+func (c *Canvas) canvas(RenderOptions) *Canvas {
+	return c
+}
+
+// Size returns the size of the canvas, limited to the options' maximum size
+func (c *Canvas) Size(opts RenderOptions) (int, int) {
+	return opts.clamp(c.width, c.height)
+}
+
+// render returns the canvas in the options' charset and colour profile, cut
+// off at their maximum size
+func (c *Canvas) render(opts RenderOptions) string {
+	var b strings.Builder
+	c.writeTo(&b, opts) // Writing to a strings.Builder can't fail
+	return b.String()
+}
+
+// writeTo writes out the canvas as render returns it, one row at a time, so
+// that the whole output is never held in memory
+func (c *Canvas) writeTo(w io.Writer, opts RenderOptions) (int64, error) {
+	charset := opts.charset()
+	width, height := c.Size(opts)
+	var row bytes.Buffer
+	var n int64
+	for y := 0; y < height; y++ {
+		row.Reset()
+		if y > 0 {
+			row.WriteString("\n")
+		}
+		c.writeRow(&row, y, width, charset, opts.ColorProfile)
+		written, err := w.Write(row.Bytes())
+		n += int64(written)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// writeRow writes the first width cells of row y
+func (c *Canvas) writeRow(b *bytes.Buffer, y, width int, charset *Charset, profile ColorProfile) {
+	row := c.cells[y][:width]
+	if width > 0 && width < c.width && c.cells[y][width].Char == "" {
+		// The last cell holds the first half of a wide character that is cut off
+		row = append(row[:width-1:width-1], Cell{Char: " ", Style: row[width-1].Style})
+	}
+	styles := make([]Style, len(row))
+	for x, cell := range row {
+		styles[x] = profile.convert(cell.Style)
+	}
+	end := len(row)
+	for end > 0 && row[end-1].Char == " " && styles[end-1].invisible() {
+		end--
+	}
+
+	current := Style{}
+	for x, cell := range row[:end] {
+		if style := styles[x]; style != current {
+			if !current.IsZero() {
+				b.WriteString(sgrReset)
+			}
+			b.WriteString(style.sequence())
+			current = style
+		}
+		if cell.text {
+			b.WriteString(charset.text(cell.Char))
+		} else {
+			b.WriteString(charset.char(cell.Char))
+		}
+	}
+	if !current.IsZero() {
+		b.WriteString(sgrReset)
+	}
+}
+
+// lineMask records which sides of a cell a box-drawing character connects to
+type lineMask uint8
+
+const (
+	lineUp lineMask = 1 << iota
+	lineDown
+	lineLeft
+	lineRight
+)
+
+// lineChars maps every combination of connections to its light box-drawing character
+var lineChars = [16]string{
+	lineUp:                                   "╵",
+	lineDown:                                 "╷",
+	lineLeft:                                 "╴",
+	lineRight:                                "╶",
+	lineUp | lineDown:                        BoxVertical,
+	lineLeft | lineRight:                     BoxHorizontal,
+	lineDown | lineRight:                     BoxTopLeft,
+	lineDown | lineLeft:                      BoxTopRight,
+	lineUp | lineRight:                       BoxBottomLeft,
+	lineUp | lineLeft:                        BoxBottomRight,
+	lineUp | lineDown | lineRight:            BoxTeeRight,
+	lineUp | lineDown | lineLeft:             BoxTeeLeft,
+	lineDown | lineLeft | lineRight:          BoxTeeDown,
+	lineUp | lineLeft | lineRight:            BoxTeeUp,
+	lineUp | lineDown | lineLeft | lineRight: BoxCross,
+}
+
+// LineStyle selects the characters LinePath draws with
+type LineStyle int
+
+const (
+	// LineLight draws with single light lines: ─ │ ┌
+	LineLight LineStyle = iota
+	// LineHeavy draws with heavy lines: ━ ┃ ┏
+	LineHeavy
+	// LineDashed draws straight runs with dashes: ┄ ┆
+	LineDashed
+)
+
+// styledLines holds the characters that replace light ones in heavy and
+// dashed paths. Connections not listed here keep their light character.
+var styledLines = map[LineStyle]map[lineMask]string{
+	LineHeavy: {
+		lineLeft | lineRight: "━",
+		lineUp | lineDown:    "┃",
+		lineDown | lineRight: "┏",
+		lineDown | lineLeft:  "┓",
+		lineUp | lineRight:   "┗",
+		lineUp | lineLeft:    "┛",
+	},
+	LineDashed: {
+		lineLeft | lineRight: "┄",
+		lineUp | lineDown:    "┆",
+	},
+}
+
+// lineMasks is the reverse of lineChars, including the rounded corners and
+// the heavy and dashed lines
+var lineMasks = map[string]lineMask{
+	"╭": lineDown | lineRight,
+	"╮": lineDown | lineLeft,
+	"╰": lineUp | lineRight,
+	"╯": lineUp | lineLeft,
+}
+
+func init() {
+	for mask, char := range lineChars {
+		if char != "" {
+			lineMasks[char] = lineMask(mask)
+		}
+	}
+	for _, chars := range styledLines {
+		for mask, char := range chars {
+			lineMasks[char] = mask
+		}
+	}
+}
+
+// mergeLine combines two box-drawing characters drawn on the same cell.
+// Where the lines join or cross the result is a light character; anything
+// that is not a box-drawing character is simply replaced.
+func mergeLine(existing, char string) string {
+	oldMask, oldOK := lineMasks[existing]
+	newMask, newOK := lineMasks[char]
+	if !oldOK || !newOK {
+		return char
+	}
+	switch union := oldMask | newMask; union {
+	case newMask:
+		return char
+	case oldMask:
+		return existing
+	default:
+		return lineChars[union]
+	}
+}
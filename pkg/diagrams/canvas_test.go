@@ -0,0 +1,231 @@
+package diagrams
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCanvas_NewCanvas(t *testing.T) {
+	c := NewCanvas(4, 2)
+
+	if c.Width() != 4 {
+		t.Errorf("Expected width 4, got %d", c.Width())
+	}
+
+	if c.Height() != 2 {
+		t.Errorf("Expected height 2, got %d", c.Height())
+	}
+
+	if c.Cell(3, 1).Char != " " {
+		t.Errorf("Expected blank cell, got %q", c.Cell(3, 1).Char)
+	}
+
+	if c.String() != "\n" {
+		t.Errorf("Expected two empty rows, got %q", c.String())
+	}
+}
+
+func TestCanvas_Text(t *testing.T) {
+	c := NewCanvas(10, 1)
+	c.Text(2, 0, "Hello", Style{})
+
+	if c.String() != "  Hello" {
+		t.Errorf("Expected '  Hello', got %q", c.String())
+	}
+}
+
+func TestCanvas_Clipping(t *testing.T) {
+	c := NewCanvas(5, 3)
+
+	// Writes outside the canvas are ignored
+	c.Text(3, 0, "abcdef", Style{})
+	c.Set(-1, 1, "x", Style{})
+	c.Set(2, 10, "x", Style{})
+
+	// Writes outside the clip rectangle are ignored
+	c.SetClip(0, 1, 2, 2).Text(0, 2, "12345", Style{})
+	c.ResetClip().Set(4, 1, "y", Style{})
+
+	expected := "   ab\n    y\n12"
+	if c.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, c.String())
+	}
+}
+
+func TestCanvas_JunctionMerging(t *testing.T) {
+	tests := []struct {
+		name     string
+		draw     func(c *Canvas)
+		expected string
+	}{
+		{
+			"cross",
+			func(c *Canvas) {
+				c.HLine(0, 2, 1, Style{})
+				c.VLine(1, 0, 2, Style{})
+			},
+			" │\n─┼─\n │",
+		},
+		{
+			"tee from path",
+			func(c *Canvas) {
+				c.Box(0, 0, 3, 3, Style{})
+				c.Path(Style{}, Point{1, 2}, Point{1, 4})
+			},
+			"┌─┐\n│ │\n└┬┘\n │\n ╵",
+		},
+		{
+			"corner",
+			func(c *Canvas) {
+				c.Path(Style{}, Point{0, 0}, Point{0, 2}, Point{2, 2})
+			},
+			"╷\n│\n└─╴",
+		},
+		{
+			"fan out",
+			func(c *Canvas) {
+				c.Path(Style{}, Point{1, 0}, Point{1, 1}, Point{0, 1}, Point{0, 2})
+				c.Path(Style{}, Point{1, 0}, Point{1, 1}, Point{2, 1}, Point{2, 2})
+			},
+			" ╷\n┌┴┐\n╵ ╵",
+		},
+		{
+			"rounded box edge",
+			func(c *Canvas) {
+				c.Path(Style{}, Point{1, 0}, Point{1, 2})
+				c.RoundedBox(0, 2, 3, 2, Style{})
+			},
+			" ╷\n │\n╭┴╮\n╰─╯",
+		},
+		{
+			"heavy corner",
+			func(c *Canvas) {
+				c.LinePath(LineHeavy, Style{}, Point{0, 0}, Point{0, 2}, Point{2, 2})
+			},
+			"╷\n┃\n┗━╴",
+		},
+		{
+			"dashed crossing",
+			func(c *Canvas) {
+				c.LinePath(LineDashed, Style{}, Point{0, 1}, Point{2, 1})
+				c.VLine(1, 0, 2, Style{})
+			},
+			" │\n╶┼╴\n │",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCanvas(3, 5)
+			tt.draw(c)
+			if got := c.String(); strings.TrimRight(got, "\n") != tt.expected {
+				t.Errorf("got:\n%s\nexpected:\n%s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCanvas_DrawCanvas(t *testing.T) {
+	src := NewCanvas(3, 2).Text(0, 0, "abc", Style{}).Text(0, 1, "def", Style{})
+	c := NewCanvas(4, 3).Fill(0, 0, 4, 3, ".", Style{})
+
+	c.DrawCanvas(2, 1, src)
+
+	expected := "....\n..ab\n..de"
+	if c.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, c.String())
+	}
+}
+
+func TestCanvas_NonLineOverwrites(t *testing.T) {
+	c := NewCanvas(3, 1)
+	c.HLine(0, 2, 0, Style{})
+	c.Set(1, 0, ArrowRight, Style{})
+	c.SetLine(1, 0, BoxVertical, Style{})
+
+	if c.String() != "─│─" {
+		t.Errorf("Expected arrow replaced by line, got %q", c.String())
+	}
+}
+
+func TestCanvas_Styles(t *testing.T) {
+	c := NewCanvas(6, 1)
+	red := Style{Fg: "\x1b[31m"}
+	c.Text(0, 0, "ab", red).Text(2, 0, "cd", Style{Bold: true})
+
+	expected := "\x1b[31mab\x1b[0m\x1b[1mcd\x1b[0m"
+	if c.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, c.String())
+	}
+}
+
+func TestCanvas_Render(t *testing.T) {
+	var d Diagram = NewCanvas(3, 3).Box(0, 0, 3, 3, Style{})
+
+	if d.Render() != "┌─┐\n│ │\n└─┘" {
+		t.Errorf("Unexpected render:\n%s", d.Render())
+	}
+}
+
+func TestMergeLine(t *testing.T) {
+	tests := []struct {
+		existing string
+		char     string
+		expected string
+	}{
+		{" ", BoxVertical, BoxVertical},
+		{BoxVertical, BoxHorizontal, BoxCross},
+		{BoxHorizontal, BoxTopLeft, BoxTeeDown},
+		{BoxVertical, BoxBottomLeft, BoxTeeRight},
+		{BoxVertical, BoxTopRight, BoxTeeLeft},
+		{BoxHorizontal, BoxBottomRight, BoxTeeUp},
+		{"╭", BoxHorizontal, BoxTeeDown},
+		{BoxHorizontal, "╭", BoxTeeDown},
+		{" ", "╭", "╭"},
+		{"━", BoxVertical, BoxCross},
+		{BoxHorizontal, "━", "━"},
+		{"x", BoxHorizontal, BoxHorizontal},
+	}
+
+	for _, tt := range tests {
+		if got := mergeLine(tt.existing, tt.char); got != tt.expected {
+			t.Errorf("mergeLine(%q, %q) = %q, expected %q", tt.existing, tt.char, got, tt.expected)
+		}
+	}
+}
+
+func TestCanvas_TextWide(t *testing.T) {
+	c := NewCanvas(8, 1)
+	c.Text(0, 0, "日本x", Style{})
+	if output := c.String(); output != "日本x" {
+		t.Errorf("Expected %q, got %q", "日本x", output)
+	}
+
+	// Overwriting either half of a wide character blanks the other half
+	c.Set(1, 0, "|", Style{})
+	c.Set(2, 0, "|", Style{})
+	if output := c.String(); output != " || x" {
+		t.Errorf("Expected %q, got %q", " || x", output)
+	}
+
+	// A wide character that would be cut off at the edge is not drawn
+	c = NewCanvas(3, 1)
+	c.Text(0, 0, "ab日", Style{})
+	if output := c.String(); output != "ab" {
+		t.Errorf("Expected %q, got %q", "ab", output)
+	}
+}
+
+func TestCanvas_RenderWithOptions_Crop(t *testing.T) {
+	c := NewCanvas(6, 3)
+	c.Text(0, 0, "ab日本", Style{})
+	c.Text(0, 2, "bottom", Style{})
+
+	if width, height := c.Size(RenderOptions{MaxWidth: 3, MaxHeight: 2}); width != 3 || height != 2 {
+		t.Errorf("Expected a 3x2 size, got %dx%d", width, height)
+	}
+	// The wide character split by the edge is dropped
+	if got := c.RenderWithOptions(RenderOptions{MaxWidth: 3, MaxHeight: 2}); got != "ab\n" {
+		t.Errorf("Expected %q, got %q", "ab\n", got)
+	}
+}
@@ -0,0 +1,113 @@
+package diagrams
+
+import "strings"
+
+// Charset sets the characters diagrams are drawn with.
+//
+// Diagrams are drawn with light Unicode box-drawing lines (─ │ ┌ ┼), arrows
+// (→ ↓), shape outlines (◆ ╱ ⎛) and blocks (█). When a diagram is rendered,
+// after lines that meet have been joined, a Charset replaces any of these
+// characters found in Chars. Text such as labels is left as it is, apart from
+// the ellipsis that marks where it was shortened. The zero Charset replaces
+// nothing. Replacements must be one column wide, so that the layout is
+// unchanged.
+type Charset struct {
+	Name  string
+	Chars map[string]string // Replacements, by the character drawn
+}
+
+// char returns the character drawn in place of c
+func (cs *Charset) char(c string) string {
+	if r, ok := cs.Chars[c]; ok {
+		return r
+	}
+	return c
+}
+
+// text returns the character drawn in place of c in text
+func (cs *Charset) text(c string) string {
+	if c == Ellipsis {
+		return cs.char(c)
+	}
+	return c
+}
+
+// LightCharset returns the charset diagrams are drawn in: light Unicode lines
+func LightCharset() *Charset {
+	return &Charset{Name: "light", Chars: map[string]string{}}
+}
+
+// HeavyCharset returns a charset of heavy Unicode lines: ━ ┃ ┏ ┳ ╋
+func HeavyCharset() *Charset {
+	return &Charset{Name: "heavy", Chars: map[string]string{
+		"─": "━", "│": "┃", "┌": "┏", "┐": "┓", "└": "┗", "┘": "┛",
+		"╭": "┏", "╮": "┓", "╰": "┗", "╯": "┛",
+		"├": "┣", "┤": "┫", "┬": "┳", "┴": "┻", "┼": "╋",
+		"╵": "╹", "╷": "╻", "╴": "╸", "╶": "╺",
+		"┄": "┅", "┆": "┇",
+	}}
+}
+
+// DoubleCharset returns a charset of double Unicode lines: ═ ║ ╔ ╦ ╬. Heavy
+// and dashed lines are kept, so that edge styles stay distinct.
+func DoubleCharset() *Charset {
+	return &Charset{Name: "double", Chars: map[string]string{
+		"─": "═", "│": "║", "┌": "╔", "┐": "╗", "└": "╚", "┘": "╝",
+		"╭": "╔", "╮": "╗", "╰": "╚", "╯": "╝",
+		"├": "╠", "┤": "╣", "┬": "╦", "┴": "╩", "┼": "╬",
+		"╵": "║", "╷": "║", "╴": "═", "╶": "═",
+	}}
+}
+
+// RoundedCharset returns a charset of light Unicode lines with rounded
+// corners: ╭ ╮ ╰ ╯
+func RoundedCharset() *Charset {
+	return &Charset{Name: "rounded", Chars: map[string]string{
+		"┌": "╭", "┐": "╮", "└": "╰", "┘": "╯",
+	}}
+}
+
+// ASCIICharset returns a charset of plain ASCII characters, for consoles and
+// logs that can't show Unicode: + - | for lines, > v < ^ for arrows and # for
+// bars. Rounded corners become . and ', heavy lines = and dashed lines . and :.
+func ASCIICharset() *Charset {
+	return &Charset{Name: "ascii", Chars: map[string]string{
+		"─": "-", "│": "|", "┌": "+", "┐": "+", "└": "+", "┘": "+",
+		"├": "+", "┤": "+", "┬": "+", "┴": "+", "┼": "+",
+		"╵": "|", "╷": "|", "╴": "-", "╶": "-",
+		"╭": ".", "╮": ".", "╰": "'", "╯": "'",
+		"━": "=", "┃": "|", "┏": "+", "┓": "+", "┗": "+", "┛": "+",
+		"┣": "+", "┫": "+", "┳": "+", "┻": "+", "╋": "+",
+		"╹": "|", "╻": "|", "╸": "=", "╺": "=",
+		"┄": ".", "┆": ":", "┅": ".", "┇": ":",
+		"═": "=", "║": "|", "╔": "+", "╗": "+", "╚": "+", "╝": "+",
+		"╠": "+", "╣": "+", "╦": "+", "╩": "+", "╬": "+",
+		"→": ">", "←": "<", "↓": "v", "↑": "^",
+		"◆": "*", "╱": "/", "╲": "\\", "◯": "o", "✕": "x",
+		"⎛": "/", "⎜": "|", "⎝": "\\", "⎞": "\\", "⎟": "|", "⎠": "/",
+		"█": "#", Ellipsis: "~",
+	}}
+}
+
+// charsets lists the built-in charsets by name
+var charsets = []func() *Charset{LightCharset, HeavyCharset, DoubleCharset, RoundedCharset, ASCIICharset}
+
+// CharsetByName returns the built-in charset with the given name: "light",
+// "heavy", "double", "rounded" or "ascii"
+func CharsetByName(name string) (*Charset, bool) {
+	for _, charset := range charsets {
+		if cs := charset(); strings.EqualFold(cs.Name, name) {
+			return cs, true
+		}
+	}
+	return nil, false
+}
+
+// CharsetNames returns the names of the built-in charsets
+func CharsetNames() []string {
+	names := make([]string, len(charsets))
+	for i, charset := range charsets {
+		names[i] = charset().Name
+	}
+	return names
+}
@@ -0,0 +1,173 @@
+package diagrams
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCharsetByName(t *testing.T) {
+	for _, name := range CharsetNames() {
+		cs, ok := CharsetByName(name)
+		if !ok || cs.Name != name {
+			t.Errorf("CharsetByName(%q) = %v, %v", name, cs, ok)
+		}
+	}
+	if _, ok := CharsetByName("braille"); ok {
+		t.Error("Expected no charset named braille")
+	}
+}
+
+func TestCharset_ReplacementsAreNarrow(t *testing.T) {
+	for _, name := range CharsetNames() {
+		cs, _ := CharsetByName(name)
+		for from, to := range cs.Chars {
+			if StringWidth(to) != StringWidth(from) {
+				t.Errorf("%s charset replaces %q with %q of a different width", name, from, to)
+			}
+		}
+	}
+}
+
+func TestASCIICharset_CoversOtherCharsets(t *testing.T) {
+	ascii := ASCIICharset()
+	for _, name := range CharsetNames() {
+		cs, _ := CharsetByName(name)
+		for from, to := range cs.Chars {
+			for _, c := range []string{from, to} {
+				if r := ascii.char(c); r[0] >= 0x80 {
+					t.Errorf("Expected the ascii charset to replace %q from the %s charset", c, name)
+				}
+			}
+		}
+	}
+}
+
+func TestFlowchart_RenderASCII(t *testing.T) {
+	flow := NewFlowchart(TopToBottom).
+		AddNode("a", "Start", ShapeRounded).
+		AddNode("b", "Ok?", ShapeDiamond).
+		AddNode("c", "Done", ShapeBox)
+	flow.AddEdge("a", "b", "").AddEdge("b", "c", "yes")
+
+	expected := strings.Join([]string{
+		".-------.",
+		"| Start |",
+		"'---+---'",
+		"    |",
+		"    v",
+		"    *",
+		" < Ok? >",
+		"    *",
+		"    |",
+		"   yes",
+		"    v",
+		"+------+",
+		"| Done |",
+		"+------+",
+	}, "\n")
+	if got := flow.RenderWithOptions(RenderOptions{Charset: ASCIICharset()}); got != expected {
+		t.Errorf("Expected:\n%s\n\nGot:\n%s", expected, got)
+	}
+}
+
+func TestRenderWithOptions_ASCIIOnly(t *testing.T) {
+	flow := NewFlowchart(LeftToRight)
+	for shape := ShapeBox; shape <= ShapeDoubleCircle; shape++ {
+		flow.AddNode(string(rune('a'+shape)), "Two\nlines", shape)
+	}
+	flow.AddStyledEdge("a", "b", "", EdgeDotted, HeadCircle, HeadCross).
+		AddStyledEdge("b", "c", "", EdgeThick, HeadNone, HeadArrow).
+		AddEdge("c", "a", "back").
+		AddEdge("d", "d", "again")
+	flow.AddSubgraph("s", "Group").Add("e", "f")
+	// Thick edges crossing and joining draw heavy tees and crosses
+	flow.AddStyledEdge("a", "e", "", EdgeThick, HeadNone, HeadArrow).
+		AddStyledEdge("a", "f", "", EdgeThick, HeadNone, HeadArrow).
+		AddStyledEdge("b", "f", "", EdgeDotted, HeadNone, HeadArrow)
+
+	seq := NewSequenceDiagram().AddActor("A", "Alice").AddActor("B", "Bartholomew the Great").
+		AddActor("C", "Carol").
+		StartFragment(FragmentAlt, "ok").
+		AddMessage("A", "B", "call", MessageSync).
+		Activate("B").
+		AddMessage("B", "C", "forward a very long message", MessageAsync).
+		AddNote(NoteRightOf, "a note", "B").
+		AddSection("failed").
+		AddMessage("B", "A", "reply", MessageReturn).
+		Deactivate("B").
+		EndFragment().
+		AddMessage("A", "A", "self", MessageAsync)
+
+	grid := NewGrid().SetGap(1, 0)
+	grid.Add(0, 0, seq)
+	grid.Add(0, 1, NewBarChart("", Horizontal).AddBar("a", 2))
+
+	diagrams := map[string]interface {
+		Render() string
+		RenderWithOptions(RenderOptions) string
+	}{
+		"flowchart":           flow,
+		"sequence":            seq,
+		"horizontal barchart": NewBarChart("Usage", Horizontal).AddBar("Go", 5).AddBar("Rust", 3),
+		"vertical barchart":   NewBarChart("Usage", Vertical).AddBar("Go", 5).AddBar("Rust", 3),
+		"legend":              NewLegend(TopToBottom).Add("██", "Done", Style{}).Add("┄┄→", "Planned", Style{}),
+		"frame":               NewFrame(flow).SetTitle("Flow", AlignStart),
+		"grid":                grid,
+	}
+
+	for name, d := range diagrams {
+		output := d.RenderWithOptions(RenderOptions{Charset: ASCIICharset()})
+		for _, r := range output {
+			if r > 127 {
+				t.Errorf("%s: expected only ASCII, found %q in:\n%s", name, r, output)
+				break
+			}
+		}
+
+		// Every character is replaced in place, so the layout is unchanged
+		plain := strings.Split(d.Render(), "\n")
+		for i, line := range strings.Split(output, "\n") {
+			if StringWidth(line) != StringWidth(plain[i]) {
+				t.Errorf("%s: line %d is %q, was %q", name, i, line, plain[i])
+			}
+		}
+	}
+}
+
+func TestRenderWithOptions_CharsetKeepsText(t *testing.T) {
+	flow := NewFlowchart(TopToBottom).AddNode("a", "A ─→ B", ShapeBox).AddNode("b", "Authentication", ShapeBox)
+	flow.AddEdge("a", "b", "")
+
+	// Labels keep their characters, but the ellipsis of a shortened one is replaced
+	output := flow.RenderWithOptions(RenderOptions{Charset: ASCIICharset(), MaxWidth: 12})
+	if !strings.Contains(output, "| A ─→ B |") {
+		t.Errorf("Expected the label unchanged in:\n%s", output)
+	}
+	if strings.Contains(output, Ellipsis) {
+		t.Errorf("Expected the ellipsis replaced in:\n%s", output)
+	}
+}
+
+func TestRenderWithOptions_Charset(t *testing.T) {
+	flow := NewFlowchart(TopToBottom).AddNode("a", "A", ShapeBox).AddNode("b", "B", ShapeBox)
+	flow.AddEdge("a", "b", "")
+
+	tests := []struct {
+		charset *Charset
+		want    []string
+	}{
+		{LightCharset(), []string{"┌───┐", "└─┬─┘", "│ B │"}},
+		{HeavyCharset(), []string{"┏━━━┓", "┗━┳━┛", "┃ B ┃"}},
+		{DoubleCharset(), []string{"╔═══╗", "╚═╦═╝", "║ B ║"}},
+		{RoundedCharset(), []string{"╭───╮", "╰─┬─╯", "│ B │"}},
+	}
+
+	for _, tt := range tests {
+		output := flow.RenderWithOptions(RenderOptions{Charset: tt.charset})
+		for _, want := range tt.want {
+			if !strings.Contains(output, want) {
+				t.Errorf("%s charset: expected %q in:\n%s", tt.charset.Name, want, output)
+			}
+		}
+	}
+}
@@ -0,0 +1,550 @@
+package diagrams
+
+import (
+	"math"
+	"sort"
+)
+
+// layoutClusterPad is the space between a cluster frame and the nodes inside
+// it, across the flow direction: one blank cell and the border itself
+const layoutClusterPad = 2
+
+// layoutCluster is a subgraph drawn as a frame around its members.
+//
+// Across the flow direction the frame spans lo..hi, which no other node may
+// enter. Along the flow direction it runs from a border in the gap before its
+// first rank to a border in the gap after its last rank.
+type layoutCluster struct {
+	sub         *Subgraph
+	parent      *layoutCluster
+	depth       int
+	first, last int // First and last rank holding a member
+	lo, hi      int // Frame extent across the flow direction, borders included
+	reach       int // Least extent from lo that keeps the title clear of the edges entering the frame
+	openLevel   int // Nesting among the frames that open before the same rank, outermost first
+	closeLevel  int // Nesting among the frames that close after the same rank, innermost first
+}
+
+// contains reports whether c is the cluster itself or one of its ancestors
+func (c *layoutCluster) contains(other *layoutCluster) bool {
+	for ; other != nil; other = other.parent {
+		if other == c {
+			return true
+		}
+	}
+	return false
+}
+
+func clusterDepth(c *layoutCluster) int {
+	if c == nil {
+		return 0
+	}
+	return c.depth
+}
+
+// commonCluster returns the innermost cluster containing both a and b
+func commonCluster(a, b *layoutCluster) *layoutCluster {
+	for ; a != nil; a = a.parent {
+		if a.contains(b) {
+			return a
+		}
+	}
+	return nil
+}
+
+// subgraphMembers returns the IDs of every node and nested subgraph inside sub
+func subgraphMembers(sub *Subgraph) map[string]bool {
+	members := make(map[string]bool)
+	var walk func(s *Subgraph)
+	walk = func(s *Subgraph) {
+		for _, id := range s.Nodes {
+			members[id] = true
+		}
+		for _, child := range s.Subgraphs {
+			members[child.ID] = true
+			walk(child)
+		}
+	}
+	walk(sub)
+	return members
+}
+
+// separate reports whether a subgraph is laid out on its own and placed as a
+// single node: it must set a different direction or be the end of an edge,
+// and no edge may link its members to the rest of the flowchart
+func (l *flowLayout) separate(f *Flowchart, sub *Subgraph) bool {
+	members := subgraphMembers(sub)
+	referenced := false
+	for _, e := range f.Edges {
+		if members[e.From] != members[e.To] {
+			return false
+		}
+		referenced = referenced || e.From == sub.ID || e.To == sub.ID
+	}
+	return referenced || (sub.Direction != nil && *sub.Direction != l.direction)
+}
+
+// hiddenMembers returns the IDs of the nodes that belong to separately laid
+// out subgraphs and so are not placed in this layout themselves
+func (l *flowLayout) hiddenMembers(f *Flowchart, subs []*Subgraph, hidden map[string]bool) map[string]bool {
+	for _, sub := range subs {
+		if l.separate(f, sub) {
+			for id := range subgraphMembers(sub) {
+				hidden[id] = true
+			}
+		} else {
+			l.hiddenMembers(f, sub.Subgraphs, hidden)
+		}
+	}
+	return hidden
+}
+
+// addSubgraphs creates a cluster for every subgraph drawn around its members,
+// or a single framed node for a subgraph laid out on its own. Nested subgraphs
+// claim their nodes before their parents do.
+func (l *flowLayout) addSubgraphs(f *Flowchart, subs []*Subgraph, parent *layoutCluster, index map[string]int) {
+	for _, sub := range subs {
+		if l.separate(f, sub) {
+			v := l.addNested(f, sub)
+			l.nodes[v].cluster = parent
+			if _, ok := index[sub.ID]; !ok {
+				index[sub.ID] = v
+			}
+			continue
+		}
+
+		c := &layoutCluster{sub: sub, parent: parent, depth: clusterDepth(parent) + 1}
+		l.clusters = append(l.clusters, c)
+		l.addSubgraphs(f, sub.Subgraphs, c, index)
+		for _, id := range sub.Nodes {
+			v, ok := index[id]
+			if !ok {
+				v = l.addNode(Node{ID: id, Label: id, Shape: ShapeBox}, false)
+				index[id] = v
+			}
+			if l.nodes[v].cluster == nil {
+				l.nodes[v].cluster = c
+			}
+		}
+
+		// Edges to the subgraph itself connect to its first member
+		if _, ok := index[sub.ID]; !ok {
+			for v, n := range l.nodes {
+				if c.contains(n.cluster) {
+					index[sub.ID] = v
+					break
+				}
+			}
+		}
+	}
+}
+
+// addNested lays out a subgraph as a flowchart of its own and adds it as a
+// single node, framed and titled like a cluster
+func (l *flowLayout) addNested(f *Flowchart, sub *Subgraph) int {
+	inner := &Flowchart{Direction: l.direction, Subgraphs: sub.Subgraphs}
+	if sub.Direction != nil {
+		inner.Direction = *sub.Direction
+	}
+
+	members := subgraphMembers(sub)
+	declared := make(map[string]bool)
+	for _, node := range f.Nodes {
+		if members[node.ID] && !declared[node.ID] {
+			inner.Nodes = append(inner.Nodes, node)
+			declared[node.ID] = true
+		}
+	}
+	for _, id := range sub.Nodes {
+		if !declared[id] {
+			inner.Nodes = append(inner.Nodes, Node{ID: id, Label: id, Shape: ShapeBox})
+			declared[id] = true
+		}
+	}
+	for _, e := range f.Edges {
+		if members[e.From] && members[e.To] {
+			inner.Edges = append(inner.Edges, e)
+		}
+	}
+
+	v := l.addNode(Node{ID: sub.ID, Label: sub.Title, Shape: ShapeBox}, false)
+	n := l.nodes[v]
+	n.nested = newSpacedFlowLayout(inner, l.gap)
+	n.w = max(n.nested.width+4, StringWidth(sub.Title)+6)
+	n.h = n.nested.height + 4
+	return v
+}
+
+// clusterRanks finds the ranks spanned by each cluster and how the frames
+// opening or closing at the same rank nest. Clusters without members are
+// dropped, and a placeholder dummy node is added to every rank a cluster spans
+// without a member, so that each rank keeps room for the frame.
+func (l *flowLayout) clusterRanks() {
+	ranks := 0
+	for _, n := range l.nodes {
+		ranks = max(ranks, n.rank+1)
+	}
+	l.opening = make([]int, ranks)
+	l.closing = make([]int, ranks)
+	if len(l.clusters) == 0 {
+		return
+	}
+
+	for _, c := range l.clusters {
+		c.first, c.last = math.MaxInt, -1
+	}
+	for _, n := range l.nodes {
+		for c := n.cluster; c != nil; c = c.parent {
+			c.first, c.last = min(c.first, n.rank), max(c.last, n.rank)
+		}
+	}
+	clusters := l.clusters[:0]
+	for _, c := range l.clusters {
+		if c.last >= 0 {
+			clusters = append(clusters, c)
+		}
+	}
+	l.clusters = clusters
+
+	occupied := make(map[*layoutCluster]map[int]bool)
+	for _, n := range l.nodes {
+		for c := n.cluster; c != nil; c = c.parent {
+			if occupied[c] == nil {
+				occupied[c] = make(map[int]bool)
+			}
+			occupied[c][n.rank] = true
+		}
+	}
+	for _, c := range l.clusters {
+		for r := c.first; r <= c.last; r++ {
+			if !occupied[c][r] {
+				d := l.addNode(Node{}, true)
+				l.nodes[d].rank = r
+				l.nodes[d].cluster = c
+				for p := c; p != nil; p = p.parent {
+					occupied[p][r] = true
+				}
+			}
+		}
+	}
+
+	// Parents come before their children
+	sort.SliceStable(l.clusters, func(i, j int) bool { return l.clusters[i].depth < l.clusters[j].depth })
+	for _, c := range l.clusters {
+		c.openLevel, c.closeLevel = 1, 1
+		if c.parent != nil && c.parent.first == c.first {
+			c.openLevel = c.parent.openLevel + 1
+		}
+		l.opening[c.first] = max(l.opening[c.first], c.openLevel)
+	}
+	for i := len(l.clusters) - 1; i >= 0; i-- {
+		c := l.clusters[i]
+		if c.parent != nil && c.parent.last == c.last {
+			c.parent.closeLevel = max(c.parent.closeLevel, c.closeLevel+1)
+		}
+		l.closing[c.last] = max(l.closing[c.last], c.closeLevel)
+	}
+}
+
+// groupLayer makes the members of every cluster contiguous in rank r. Clusters
+// are ordered by the mean position of their members over all ranks, so that
+// sibling clusters keep the same order in every rank.
+func (l *flowLayout) groupLayer(r int) {
+	if len(l.clusters) == 0 {
+		return
+	}
+
+	sum := make(map[*layoutCluster]float64)
+	count := make(map[*layoutCluster]int)
+	for _, n := range l.nodes {
+		for c := n.cluster; c != nil; c = c.parent {
+			sum[c] += float64(n.order)
+			count[c]++
+		}
+	}
+
+	// Each node is ordered by its chain of clusters, outermost first, and then by itself
+	type entity struct {
+		key     float64
+		cluster *layoutCluster
+		id      int
+	}
+	chains := make(map[int][]entity)
+	for _, v := range l.layers[r] {
+		n := l.nodes[v]
+		chain := []entity{{key: float64(n.order), id: v}}
+		for c := n.cluster; c != nil; c = c.parent {
+			chain = append([]entity{{key: sum[c] / float64(count[c]), cluster: c}}, chain...)
+		}
+		chains[v] = chain
+	}
+	clusterID := make(map[*layoutCluster]int)
+	for i, c := range l.clusters {
+		clusterID[c] = i
+	}
+
+	layer := l.layers[r]
+	sort.SliceStable(layer, func(i, j int) bool {
+		a, b := chains[layer[i]], chains[layer[j]]
+		for k := 0; k < len(a) && k < len(b); k++ {
+			ea, eb := a[k], b[k]
+			if ea.cluster != nil && ea.cluster == eb.cluster {
+				continue
+			}
+			if ea.key != eb.key {
+				return ea.key < eb.key
+			}
+			if (ea.cluster == nil) != (eb.cluster == nil) {
+				return ea.cluster == nil
+			}
+			if ea.cluster != nil {
+				return clusterID[ea.cluster] < clusterID[eb.cluster]
+			}
+			return ea.id < eb.id
+		}
+		return false
+	})
+	for i, v := range layer {
+		l.nodes[v].order = i
+	}
+}
+
+// clusterPad returns the extra space two adjacent nodes of a rank need for
+// the frames that run between them
+func (l *flowLayout) clusterPad(a, b *layoutNode) int {
+	common := clusterDepth(commonCluster(a.cluster, b.cluster))
+	return layoutClusterPad * (clusterDepth(a.cluster) + clusterDepth(b.cluster) - 2*common)
+}
+
+// minorStart returns the first row or column a node occupies across the flow direction
+func (l *flowLayout) minorStart(n *layoutNode) int {
+	if l.direction == LeftToRight {
+		return n.y
+	}
+	return n.x
+}
+
+// minorEnd returns the last row or column a node occupies across the flow
+// direction, including its self-loops and back edges
+func (l *flowLayout) minorEnd(n *layoutNode) int {
+	return l.minorStart(n) + l.minorSize(n) + l.afterSize(n) - 1
+}
+
+func (l *flowLayout) moveMinor(n *layoutNode, delta int) {
+	if l.direction == LeftToRight {
+		n.y += delta
+	} else {
+		n.x += delta
+	}
+}
+
+// measureClusters computes the extent of every frame across the flow
+// direction from its members, nested frames and title
+func (l *flowLayout) measureClusters() {
+	for _, c := range l.clusters {
+		c.lo, c.hi = math.MaxInt, math.MinInt
+	}
+	for _, n := range l.nodes {
+		pad := layoutClusterPad
+		for c := n.cluster; c != nil; c = c.parent {
+			c.lo, c.hi = min(c.lo, l.minorStart(n)-pad), max(c.hi, l.minorEnd(n)+pad)
+			pad += layoutClusterPad
+		}
+	}
+
+	// Innermost first, so that a frame widened for its title widens its parents
+	for i := len(l.clusters) - 1; i >= 0; i-- {
+		c := l.clusters[i]
+		if l.direction == TopToBottom && c.sub.Title != "" {
+			c.hi = max(c.hi, c.lo+max(StringWidth(c.sub.Title)+5, c.reach))
+		}
+		if p := c.parent; p != nil {
+			p.lo, p.hi = min(p.lo, c.lo-layoutClusterPad), max(p.hi, c.hi+layoutClusterPad)
+		}
+	}
+}
+
+// fitClusters moves nodes out of the frames of clusters they do not belong
+// to. Frames are rectangles spanning several ranks, so a node can end up
+// inside one even though every rank keeps cluster members together. Nodes
+// only ever move right, which settles once every frame is clear.
+func (l *flowLayout) fitClusters() {
+	if len(l.clusters) == 0 {
+		return
+	}
+
+	for pass := 0; pass < 4*len(l.clusters); pass++ {
+		l.measureClusters()
+		moved := false
+		for _, c := range l.clusters {
+			for r := c.first; r <= c.last; r++ {
+				moved = l.clearCluster(c, r) || moved
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+
+	l.measureClusters()
+	lowest := math.MaxInt
+	for _, n := range l.nodes {
+		lowest = min(lowest, l.minorStart(n))
+	}
+	for _, c := range l.clusters {
+		lowest = min(lowest, c.lo)
+	}
+	for _, n := range l.nodes {
+		l.moveMinor(n, -lowest)
+	}
+	l.measureClusters()
+}
+
+// clearCluster clears the frame of cluster c in rank r: a node after the
+// cluster's members that overlaps the frame moves right, with the rest of the
+// rank, and a node before them that overlaps it makes the whole cluster move
+// right in every rank it spans. It reports whether anything moved.
+func (l *flowLayout) clearCluster(c *layoutCluster, r int) bool {
+	layer := l.layers[r]
+	left, right := l.clusterBlock(c, r)
+	if left < 0 {
+		return false
+	}
+
+	if right < len(layer) {
+		if over := c.hi + l.nodeGap() + 1 - l.minorStart(l.nodes[layer[right]]); over > 0 {
+			for _, v := range layer[right:] {
+				l.moveMinor(l.nodes[v], over)
+			}
+			return true
+		}
+	}
+	if left > 0 {
+		if over := l.minorEnd(l.nodes[layer[left-1]]) + l.nodeGap() + 1 - c.lo; over > 0 {
+			for rr := c.first; rr <= c.last; rr++ {
+				from, _ := l.clusterBlock(c, rr)
+				for _, v := range l.layers[rr][from:] {
+					l.moveMinor(l.nodes[v], over)
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// clusterBlock returns the range of positions held by the members of c in
+// rank r, or -1 if it has none there
+func (l *flowLayout) clusterBlock(c *layoutCluster, r int) (int, int) {
+	left, right := -1, -1
+	for i, v := range l.layers[r] {
+		if c.contains(l.nodes[v].cluster) {
+			if left < 0 {
+				left = i
+			}
+			right = i + 1
+		}
+	}
+	return left, right
+}
+
+// frame returns the rectangle of a cluster's frame on the canvas
+func (l *flowLayout) frame(c *layoutCluster) (x, y, w, h int) {
+	start := l.rankStart[c.first] - 2 - (l.opening[c.first] - c.openLevel)
+	end := l.rankStart[c.last] + l.rankSize[c.last] + c.closeLevel
+	if l.direction == LeftToRight {
+		return start, c.lo, end - start + 1, c.hi - c.lo + 1
+	}
+	return c.lo, start, c.hi - c.lo + 1, end - start + 1
+}
+
+// fitFrameTitles widens the frames of a TopToBottom layout until their titles
+// fit between the edges crossing their top border, moving everything right
+// of a widened frame further right. Innermost frames go first, so that their
+// parents widen with them.
+func (l *flowLayout) fitFrameTitles() {
+	for i := len(l.clusters) - 1; i >= 0; i-- {
+		c := l.clusters[i]
+		if c.sub.Title == "" {
+			continue
+		}
+		width := StringWidth(c.sub.Title) + 2
+		start := c.lo + 2
+		for _, x := range l.topCrossings(c) {
+			if x >= start && x < start+width {
+				start = x + 1
+			}
+		}
+		if over := start + width + 1 - c.hi; over > 0 {
+			for _, n := range l.nodes {
+				if l.minorStart(n) > c.hi {
+					l.moveMinor(n, over)
+				}
+			}
+			c.reach = start + width + 1 - c.lo
+			l.measureClusters()
+		}
+	}
+}
+
+// topCrossings returns the columns, in order, where edges cross the top
+// border of a cluster's frame: those running into its first rank from outside
+func (l *flowLayout) topCrossings(c *layoutCluster) []int {
+	var columns []int
+	for _, e := range l.edges {
+		if e.edge.Style == EdgeInvisible {
+			continue
+		}
+		for i := 0; i+1 < len(e.path); i++ {
+			from, to := l.nodes[e.path[i]], l.nodes[e.path[i+1]]
+			if to.rank != c.first || !c.contains(to.cluster) || c.contains(from.cluster) {
+				continue
+			}
+			x := l.minorCenter(to)
+			if i+2 == len(e.path) {
+				x += e.ports[1]
+			}
+			columns = append(columns, x)
+		}
+	}
+	sort.Ints(columns)
+	return columns
+}
+
+// fitTitles widens rank r in a LeftToRight layout until the frames closing
+// after it are wide enough for their titles
+func (l *flowLayout) fitTitles(r int) {
+	for level := 1; level <= l.closing[r]; level++ {
+		for _, c := range l.clusters {
+			if c.last != r || c.closeLevel != level {
+				continue
+			}
+			if _, _, w, _ := l.frame(c); w < StringWidth(c.sub.Title)+6 {
+				l.rankSize[r] += StringWidth(c.sub.Title) + 6 - w
+			}
+		}
+	}
+}
+
+// drawFrame draws a cluster frame with its title on the top border, as far
+// left as possible without hiding an edge that crosses the border
+func drawFrame(c *Canvas, x, y, w, h int, title string, theme *Theme) {
+	text := " " + title + " "
+	at := x + 2
+	width := StringWidth(text)
+	for start := x + 2; start+width <= x+w-2; start++ {
+		clear := true
+		for col := start; col < start+width && clear; col++ {
+			clear = c.Cell(col, y).Char == " "
+		}
+		if clear {
+			at = start
+			break
+		}
+	}
+
+	c.Box(x, y, w, h, theme.Frame)
+	if title != "" {
+		c.Text(at, y, text, theme.Title)
+	}
+}
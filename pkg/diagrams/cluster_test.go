@@ -0,0 +1,172 @@
+package diagrams
+
+import (
+	"strings"
+	"testing"
+)
+
+func clusteredFlowchart(direction Direction) *Flowchart {
+	flow := NewFlowchart(direction)
+	flow.AddNode("lb", "Load Balancer", ShapeBox).
+		AddNode("api1", "API 1", ShapeBox).
+		AddNode("api2", "API 2", ShapeBox).
+		AddNode("mon", "Monitoring", ShapeBox).
+		AddNode("db", "Postgres", ShapeRounded).
+		AddEdge("lb", "api1", "").
+		AddEdge("lb", "mon", "").
+		AddEdge("lb", "api2", "").
+		AddEdge("api1", "db", "").
+		AddEdge("api2", "db", "")
+	flow.AddSubgraph("svc", "Services").Add("api1", "api2")
+	return flow
+}
+
+func TestFlowLayout_ClusterMembersContiguous(t *testing.T) {
+	l := newFlowLayout(clusteredFlowchart(TopToBottom))
+	api1, api2, mon := layoutNodeByID(l, "api1"), layoutNodeByID(l, "api2"), layoutNodeByID(l, "mon")
+
+	if api1.rank != mon.rank || api2.rank != mon.rank {
+		t.Fatal("Expected the services and monitoring on one rank")
+	}
+	if gap := api1.order - api2.order; gap != 1 && gap != -1 {
+		t.Errorf("Expected cluster members next to each other, got orders %d and %d", api1.order, api2.order)
+	}
+}
+
+func TestFlowLayout_ClusterFrame(t *testing.T) {
+	for _, direction := range []Direction{TopToBottom, LeftToRight} {
+		l := newFlowLayout(clusteredFlowchart(direction))
+		if len(l.clusters) != 1 {
+			t.Fatalf("Expected 1 cluster, got %d", len(l.clusters))
+		}
+		x, y, w, h := l.frame(l.clusters[0])
+
+		inside := func(n *layoutNode) bool {
+			return n.x >= x && n.y >= y && n.x+n.w <= x+w && n.y+n.h <= y+h
+		}
+		overlaps := func(n *layoutNode) bool {
+			return n.x < x+w && x < n.x+n.w && n.y < y+h && y < n.y+n.h
+		}
+
+		for _, id := range []string{"api1", "api2"} {
+			if !inside(layoutNodeByID(l, id)) {
+				t.Errorf("Expected %s inside the frame", id)
+			}
+		}
+		for _, id := range []string{"lb", "mon", "db"} {
+			if overlaps(layoutNodeByID(l, id)) {
+				t.Errorf("Expected %s outside the frame", id)
+			}
+		}
+	}
+}
+
+func TestFlowchart_RenderSubgraph(t *testing.T) {
+	output := clusteredFlowchart(TopToBottom).Render()
+
+	if !strings.Contains(output, " Services ") {
+		t.Errorf("Expected the frame title, got:\n%s", output)
+	}
+
+	// The frame's sides run down both sides of the services
+	if !strings.Contains(output, "│ │ API 1 │   │ API 2 │ │") {
+		t.Errorf("Expected the services between the frame's sides, got:\n%s", output)
+	}
+}
+
+func TestFlowchart_SubgraphTitleClearOfEdges(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddNode("a", "Client", ShapeBox).
+		AddNode("b", "API", ShapeBox).
+		AddEdge("a", "b", "calls")
+	flow.AddSubgraph("s", "Backend").Add("b")
+
+	output := flow.Render()
+	lines := strings.Split(output, "\n")
+
+	// The label sits on its own row above the frame, and the edge crosses
+	// its top border beside the title
+	for i, line := range lines {
+		if strings.Contains(line, "Backend") {
+			if !strings.Contains(line, "┼ Backend ─┐") || strings.TrimSpace(lines[i-1]) != "calls" {
+				t.Errorf("Expected the label above the title and the edge beside it, got:\n%s", output)
+			}
+			return
+		}
+	}
+	t.Errorf("Expected the frame title, got:\n%s", output)
+}
+
+func TestFlowchart_NestedSubgraphs(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddNode("a", "Gateway", ShapeBox).
+		AddNode("b", "Postgres", ShapeBox).
+		AddEdge("a", "b", "")
+	flow.AddSubgraph("outer", "Region").Add("a").
+		AddSubgraph("inner", "Database").Add("b")
+
+	l := newFlowLayout(flow)
+	if len(l.clusters) != 2 {
+		t.Fatalf("Expected 2 clusters, got %d", len(l.clusters))
+	}
+
+	ox, oy, ow, oh := l.frame(l.clusters[0])
+	ix, iy, iw, ih := l.frame(l.clusters[1])
+	if ix <= ox || iy <= oy || ix+iw >= ox+ow || iy+ih >= oy+oh {
+		t.Errorf("Expected the inner frame inside the outer one, got (%d,%d %dx%d) in (%d,%d %dx%d)",
+			ix, iy, iw, ih, ox, oy, ow, oh)
+	}
+}
+
+func TestFlowchart_SubgraphDirection(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddEdge("start", "ci", "").
+		AddEdge("lint", "test", "").
+		AddEdge("test", "build", "").
+		AddEdge("ci", "done", "")
+	flow.AddSubgraph("ci", "Pipeline").SetDirection(LeftToRight).Add("lint", "test", "build")
+
+	output := flow.Render()
+
+	// The pipeline runs left to right inside a frame placed between start and done
+	if !strings.Contains(output, "│ lint ├──→│ test ├──→│ build │") {
+		t.Errorf("Expected the subgraph laid out left to right, got:\n%s", output)
+	}
+	lines := strings.Split(output, "\n")
+	if !strings.Contains(lines[1], "start") || !strings.Contains(lines[len(lines)-2], "done") {
+		t.Errorf("Expected start above and done below the subgraph, got:\n%s", output)
+	}
+}
+
+func TestFlowchart_SubgraphDirectionIgnoredWhenLinked(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddEdge("start", "lint", "").
+		AddEdge("lint", "test", "")
+	flow.AddSubgraph("ci", "Pipeline").SetDirection(LeftToRight).Add("lint", "test")
+
+	l := newFlowLayout(flow)
+
+	// An edge enters the subgraph, so it follows the flowchart's direction
+	if layoutNodeByID(l, "test").rank != layoutNodeByID(l, "lint").rank+1 {
+		t.Error("Expected the linked subgraph to keep the top-to-bottom direction")
+	}
+}
+
+func TestGroupLayer(t *testing.T) {
+	c := &layoutCluster{depth: 1}
+	l := &flowLayout{
+		nodes: []*layoutNode{
+			{cluster: c, order: 0},
+			{order: 1},
+			{cluster: c, order: 2},
+		},
+		layers:   [][]int{{0, 1, 2}},
+		clusters: []*layoutCluster{c},
+	}
+
+	l.groupLayer(0)
+
+	if gap := l.nodes[0].order - l.nodes[2].order; gap != 1 && gap != -1 {
+		t.Errorf("Expected cluster members together, got order %v", l.layers[0])
+	}
+}
@@ -0,0 +1,351 @@
+package diagrams
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ColorProfile is the range of colours a terminal can display. Diagrams are
+// drawn in any colours, and converted to the nearest colours of the profile
+// when they are rendered.
+type ColorProfile int
+
+const (
+	// ProfileTrueColor displays 24-bit RGB colours, so colours are drawn as given.
+	// It is the zero value.
+	ProfileTrueColor ColorProfile = iota
+	// ProfileANSI256 displays the 256-colour xterm palette
+	ProfileANSI256
+	// ProfileANSI displays the 16 basic ANSI colours
+	ProfileANSI
+	// ProfileNoColor displays no colours; bold, italic and underline remain
+	ProfileNoColor
+	// ProfilePlain writes no escape sequences at all, for output that isn't a
+	// terminal
+	ProfilePlain
+)
+
+// String returns the profile's name
+func (p ColorProfile) String() string {
+	switch p {
+	case ProfileTrueColor:
+		return "truecolor"
+	case ProfileANSI256:
+		return "256"
+	case ProfileANSI:
+		return "16"
+	case ProfileNoColor:
+		return "none"
+	case ProfilePlain:
+		return "plain"
+	}
+	return fmt.Sprintf("ColorProfile(%d)", int(p))
+}
+
+// DetectColorProfile returns the colour profile for output written to w.
+//
+// NO_COLOR selects plain output, without escape sequences. So does a w that
+// is not a terminal, unless FORCE_COLOR or CLICOLOR_FORCE is set. Otherwise
+// COLORTERM=truecolor or 24bit selects true colour, a TERM ending in 256color
+// the 256-colour palette, TERM=dumb plain output, and any other terminal the
+// 16 basic colours.
+// FORCE_COLOR=2 and FORCE_COLOR=3 force the 256-colour and true colour
+// profiles.
+func DetectColorProfile(w io.Writer) ColorProfile {
+	return detectColorProfile(os.Getenv, isTerminal(w))
+}
+
+// detectColorProfile picks a profile from environment variables, read with
+// getenv, and whether output goes to a terminal
+func detectColorProfile(getenv func(string) string, terminal bool) ColorProfile {
+	if getenv("NO_COLOR") != "" {
+		return ProfilePlain
+	}
+
+	force := getenv("FORCE_COLOR")
+	forced := (force != "" && force != "0") || (getenv("CLICOLOR_FORCE") != "" && getenv("CLICOLOR_FORCE") != "0")
+	switch {
+	case force == "3":
+		return ProfileTrueColor
+	case force == "2":
+		return ProfileANSI256
+	case !terminal && !forced:
+		return ProfilePlain
+	}
+
+	term := getenv("TERM")
+	switch colorTerm := strings.ToLower(getenv("COLORTERM")); {
+	case colorTerm == "truecolor" || colorTerm == "24bit", strings.HasSuffix(term, "-direct"):
+		return ProfileTrueColor
+	case strings.Contains(term, "256color"):
+		return ProfileANSI256
+	case term == "dumb" && !forced:
+		return ProfilePlain
+	}
+	return ProfileANSI
+}
+
+// isTerminal reports whether w is a character device, such as a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorKind is the representation of a Color
+type colorKind uint8
+
+const (
+	colorDefault colorKind = iota // The terminal's default colour
+	colorANSI                     // One of the 16 basic colours
+	colorANSI256                  // An entry of the 256-colour palette
+	colorRGB                      // A 24-bit colour
+)
+
+// Color is a terminal colour: one of the 16 basic ANSI colours, an entry of
+// the 256-colour palette or a 24-bit RGB colour. The zero Color is the
+// terminal's default colour. A Color is used in a Style through the escape
+// sequences returned by Fg and Bg.
+type Color struct {
+	kind    colorKind
+	index   uint8 // Basic or palette colours
+	r, g, b uint8 // RGB colours
+}
+
+// ANSIColor returns one of the 16 basic colours: 0-7 are black, red, green,
+// yellow, blue, magenta, cyan and white, and 8-15 their bright versions
+func ANSIColor(n uint8) Color {
+	return Color{kind: colorANSI, index: n % 16}
+}
+
+// ANSI256Color returns an entry of the 256-colour palette
+func ANSI256Color(n uint8) Color {
+	return Color{kind: colorANSI256, index: n}
+}
+
+// RGB returns a 24-bit colour
+func RGB(r, g, b uint8) Color {
+	return Color{kind: colorRGB, r: r, g: g, b: b}
+}
+
+// HexColor parses a colour written as #rrggbb or #rgb
+func HexColor(hex string) (Color, error) {
+	digits := strings.TrimPrefix(hex, "#")
+	if len(digits) == 3 {
+		digits = string([]byte{digits[0], digits[0], digits[1], digits[1], digits[2], digits[2]})
+	}
+	if len(digits) != 6 {
+		return Color{}, fmt.Errorf("invalid hex colour %q", hex)
+	}
+	v, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil {
+		return Color{}, fmt.Errorf("invalid hex colour %q", hex)
+	}
+	return RGB(uint8(v>>16), uint8(v>>8), uint8(v)), nil
+}
+
+// MustHexColor is like HexColor but panics if the colour is invalid
+func MustHexColor(hex string) Color {
+	c, err := HexColor(hex)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Fg returns the escape sequence that sets the foreground to this colour
+func (c Color) Fg() string {
+	return c.sequence(false)
+}
+
+// Bg returns the escape sequence that sets the background to this colour
+func (c Color) Bg() string {
+	return c.sequence(true)
+}
+
+func (c Color) sequence(bg bool) string {
+	offset := 0
+	if bg {
+		offset = 10
+	}
+	switch c.kind {
+	case colorANSI:
+		if c.index < 8 {
+			return sgr(30 + offset + int(c.index))
+		}
+		return sgr(90 + offset + int(c.index) - 8)
+	case colorANSI256:
+		return sgr(38+offset, 5, int(c.index))
+	case colorRGB:
+		return sgr(38+offset, 2, int(c.r), int(c.g), int(c.b))
+	}
+	return ""
+}
+
+// RGB returns the colour's red, green and blue components. Basic and palette
+// colours have the values of xterm's default palette.
+func (c Color) RGB() (uint8, uint8, uint8) {
+	switch c.kind {
+	case colorRGB:
+		return c.r, c.g, c.b
+	case colorANSI, colorANSI256:
+		return paletteRGB(c.index)
+	}
+	return 0, 0, 0
+}
+
+// Convert returns the nearest colour the profile can display; the default
+// colour when it displays none
+func (c Color) Convert(p ColorProfile) Color {
+	switch {
+	case c.kind == colorDefault, p == ProfileTrueColor:
+		return c
+	case p == ProfileNoColor, p == ProfilePlain:
+		return Color{}
+	case c.kind == colorANSI:
+		return c
+	case p == ProfileANSI256 && c.kind == colorANSI256:
+		return c
+	case p == ProfileANSI256:
+		return ANSI256Color(nearest256(c.r, c.g, c.b))
+	}
+	if c.kind == colorANSI256 && c.index < 16 {
+		return ANSIColor(c.index)
+	}
+	r, g, b := c.RGB()
+	return ANSIColor(nearestColor(r, g, b, 0, 16))
+}
+
+// parseColor decodes the SGR sequence of a foreground or background colour
+func parseColor(seq string) (Color, bool) {
+	params, ok := sgrParams(seq)
+	if !ok || len(params) == 0 {
+		return Color{}, false
+	}
+	p := params[0]
+	if p >= 40 && p <= 48 || p >= 100 && p <= 107 {
+		p -= 10
+	}
+	switch {
+	case len(params) == 1 && p >= 30 && p <= 37:
+		return ANSIColor(uint8(p - 30)), true
+	case len(params) == 1 && p >= 90 && p <= 97:
+		return ANSIColor(uint8(p - 90 + 8)), true
+	case len(params) == 3 && p == 38 && params[1] == 5:
+		return ANSI256Color(uint8(params[2])), true
+	case len(params) == 5 && p == 38 && params[1] == 2:
+		return RGB(uint8(params[2]), uint8(params[3]), uint8(params[4])), true
+	}
+	return Color{}, false
+}
+
+// convert returns the style with its colours converted for the profile, or
+// no style at all for plain output
+func (p ColorProfile) convert(s Style) Style {
+	switch p {
+	case ProfileTrueColor:
+		return s
+	case ProfilePlain:
+		return Style{}
+	}
+	s.Fg = p.convertSequence(s.Fg, false)
+	s.Bg = p.convertSequence(s.Bg, true)
+	return s
+}
+
+// convertSequence converts a colour's escape sequence for the profile.
+// Sequences that aren't colours are dropped when the profile has no colours.
+func (p ColorProfile) convertSequence(seq string, bg bool) string {
+	if seq == "" {
+		return ""
+	}
+	c, ok := parseColor(seq)
+	switch {
+	case p == ProfileNoColor:
+		return ""
+	case !ok:
+		return seq
+	}
+	return c.Convert(p).sequence(bg)
+}
+
+// ansiRGB lists the colours of xterm's default 16-colour palette
+var ansiRGB = [16][3]uint8{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// cubeLevels are the component values of the 6×6×6 colour cube, entries 16-231
+// of the 256-colour palette
+var cubeLevels = [6]uint8{0, 95, 135, 175, 215, 255}
+
+// paletteRGB returns the colour of an entry of the 256-colour palette
+func paletteRGB(n uint8) (uint8, uint8, uint8) {
+	switch {
+	case n < 16:
+		c := ansiRGB[n]
+		return c[0], c[1], c[2]
+	case n < 232:
+		n -= 16
+		return cubeLevels[n/36], cubeLevels[n/6%6], cubeLevels[n%6]
+	}
+	// Grey ramp from 8 to 238
+	v := 8 + 10*(n-232)
+	return v, v, v
+}
+
+// nearest256 returns the entry of the colour cube or grey ramp of the
+// 256-colour palette nearest to an RGB colour
+func nearest256(r, g, b uint8) uint8 {
+	level := func(v uint8) uint8 {
+		switch {
+		case v < 48:
+			return 0
+		case v < 115:
+			return 1
+		}
+		return (v - 35) / 40
+	}
+	cube := 16 + 36*level(r) + 6*level(g) + level(b)
+
+	grey := uint8(232)
+	if avg := (int(r) + int(g) + int(b)) / 3; avg > 238 {
+		grey = 255
+	} else if avg > 8 {
+		grey = 232 + uint8((avg-3)/10)
+	}
+
+	cr, cg, cb := paletteRGB(cube)
+	gr, gg, gb := paletteRGB(grey)
+	if distance(r, g, b, gr, gg, gb) < distance(r, g, b, cr, cg, cb) {
+		return grey
+	}
+	return cube
+}
+
+// nearestColor returns the palette entry from lo to hi-1 nearest to an RGB colour
+func nearestColor(r, g, b uint8, lo, hi int) uint8 {
+	best, bestDistance := lo, -1
+	for i := lo; i < hi; i++ {
+		pr, pg, pb := paletteRGB(uint8(i))
+		if d := distance(r, g, b, pr, pg, pb); bestDistance < 0 || d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+	return uint8(best)
+}
+
+// distance returns how different two colours look, as a squared distance
+// weighted for the eye's sensitivity to green and red
+func distance(r1, g1, b1, r2, g2, b2 uint8) int {
+	dr, dg, db := int(r1)-int(r2), int(g1)-int(g2), int(b1)-int(b2)
+	return 3*dr*dr + 4*dg*dg + 2*db*db
+}
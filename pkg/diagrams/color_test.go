@@ -0,0 +1,168 @@
+package diagrams
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHexColor(t *testing.T) {
+	tests := []struct {
+		hex     string
+		want    Color
+		wantErr bool
+	}{
+		{"#ff8800", RGB(255, 136, 0), false},
+		{"FF8800", RGB(255, 136, 0), false},
+		{"#f80", RGB(255, 136, 0), false},
+		{"#ff88", Color{}, true},
+		{"#gg8800", Color{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := HexColor(tt.hex)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("HexColor(%q) = %+v, %v, want %+v", tt.hex, got, err, tt.want)
+		}
+	}
+}
+
+func TestColor_Fg(t *testing.T) {
+	tests := []struct {
+		color  Color
+		fg, bg string
+	}{
+		{Color{}, "", ""},
+		{ANSIColor(1), "\x1b[31m", "\x1b[41m"},
+		{ANSIColor(12), "\x1b[94m", "\x1b[104m"},
+		{ANSI256Color(208), "\x1b[38;5;208m", "\x1b[48;5;208m"},
+		{RGB(1, 2, 3), "\x1b[38;2;1;2;3m", "\x1b[48;2;1;2;3m"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.color.Fg(); got != tt.fg {
+			t.Errorf("%+v.Fg() = %q, want %q", tt.color, got, tt.fg)
+		}
+		if got := tt.color.Bg(); got != tt.bg {
+			t.Errorf("%+v.Bg() = %q, want %q", tt.color, got, tt.bg)
+		}
+		if tt.fg != "" {
+			if got, ok := parseColor(tt.bg); !ok || got != tt.color {
+				t.Errorf("parseColor(%q) = %+v, want %+v", tt.bg, got, tt.color)
+			}
+		}
+	}
+}
+
+func TestColor_Convert(t *testing.T) {
+	tests := []struct {
+		name    string
+		color   Color
+		profile ColorProfile
+		want    Color
+	}{
+		{"true colour unchanged", RGB(255, 136, 0), ProfileTrueColor, RGB(255, 136, 0)},
+		{"red to palette", RGB(255, 0, 0), ProfileANSI256, ANSI256Color(196)},
+		{"orange to palette", RGB(255, 136, 0), ProfileANSI256, ANSI256Color(208)},
+		{"grey to grey ramp", RGB(128, 128, 128), ProfileANSI256, ANSI256Color(244)},
+		{"red to basic", RGB(255, 0, 0), ProfileANSI, ANSIColor(9)},
+		{"dark green to basic", RGB(0, 190, 10), ProfileANSI, ANSIColor(2)},
+		{"palette to basic", ANSI256Color(196), ProfileANSI, ANSIColor(9)},
+		{"basic palette entry", ANSI256Color(3), ProfileANSI, ANSIColor(3)},
+		{"basic unchanged", ANSIColor(4), ProfileANSI256, ANSIColor(4)},
+		{"no colour", RGB(255, 0, 0), ProfileNoColor, Color{}},
+		{"plain", RGB(255, 0, 0), ProfilePlain, Color{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.color.Convert(tt.profile); got != tt.want {
+				t.Errorf("Convert(%v) = %+v, want %+v", tt.profile, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectColorProfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		terminal bool
+		want     ColorProfile
+	}{
+		{"truecolor", map[string]string{"COLORTERM": "truecolor", "TERM": "xterm-256color"}, true, ProfileTrueColor},
+		{"24bit", map[string]string{"COLORTERM": "24bit"}, true, ProfileTrueColor},
+		{"direct", map[string]string{"TERM": "xterm-direct"}, true, ProfileTrueColor},
+		{"256 colours", map[string]string{"TERM": "screen-256color"}, true, ProfileANSI256},
+		{"basic", map[string]string{"TERM": "xterm"}, true, ProfileANSI},
+		{"dumb", map[string]string{"TERM": "dumb"}, true, ProfilePlain},
+		{"NO_COLOR", map[string]string{"NO_COLOR": "1", "COLORTERM": "truecolor"}, true, ProfilePlain},
+		{"not a terminal", map[string]string{"COLORTERM": "truecolor"}, false, ProfilePlain},
+		{"forced", map[string]string{"FORCE_COLOR": "1", "TERM": "xterm-256color"}, false, ProfileANSI256},
+		{"forced dumb", map[string]string{"CLICOLOR_FORCE": "1", "TERM": "dumb"}, false, ProfileANSI},
+		{"forced off", map[string]string{"FORCE_COLOR": "0"}, false, ProfilePlain},
+		{"forced true colour", map[string]string{"FORCE_COLOR": "3"}, false, ProfileTrueColor},
+		{"NO_COLOR beats forcing", map[string]string{"NO_COLOR": "1", "FORCE_COLOR": "3"}, true, ProfilePlain},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(key string) string { return tt.env[key] }
+			if got := detectColorProfile(getenv, tt.terminal); got != tt.want {
+				t.Errorf("detectColorProfile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if isTerminal(&bytes.Buffer{}) {
+		t.Error("Expected a buffer not to be a terminal")
+	}
+}
+
+func TestRenderWithOptions_ColorProfile(t *testing.T) {
+	chart := NewBarChart("", Horizontal).SetWidth(4).
+		AddBarWithColor("a", 1, RGB(255, 136, 0).Fg())
+
+	tests := []struct {
+		profile ColorProfile
+		want    string
+	}{
+		{ProfileTrueColor, "\x1b[38;2;255;136;0m████"},
+		{ProfileANSI256, "\x1b[38;5;208m████"},
+		{ProfileANSI, "\x1b[33m████"},
+		{ProfileNoColor, "a │ ████ 1"},
+		{ProfilePlain, "a │ ████ 1"},
+	}
+
+	for _, tt := range tests {
+		if got := chart.RenderWithOptions(RenderOptions{ColorProfile: tt.profile}); !strings.Contains(got, tt.want) {
+			t.Errorf("Profile %v: expected %q, got %q", tt.profile, tt.want, got)
+		}
+	}
+}
+
+func TestCanvas_RenderWithOptions_NoColor(t *testing.T) {
+	c := NewCanvas(6, 1)
+	c.Text(0, 0, "ab", Style{Fg: sgr(31), Bold: true})
+	c.Text(2, 0, "  ", Style{Bg: sgr(44)})
+
+	if got := c.RenderWithOptions(RenderOptions{ColorProfile: ProfileNoColor}); got != "\x1b[1mab\x1b[0m" {
+		t.Errorf("Expected colours dropped, bold kept and blank cells trimmed, got %q", got)
+	}
+}
+
+func TestRenderTo_NotTerminalIsPlain(t *testing.T) {
+	t.Setenv("FORCE_COLOR", "")
+	t.Setenv("CLICOLOR_FORCE", "")
+	// The monochrome theme draws the title bold
+	chart := NewBarChart("Sales", Horizontal).AddBarWithColor("a", 1, RGB(255, 136, 0).Fg())
+
+	var b bytes.Buffer
+	opts := RenderOptions{Theme: MonochromeTheme(), ColorProfile: DetectColorProfile(&b)}
+	if _, err := chart.RenderTo(&b, opts); err != nil {
+		t.Fatalf("RenderTo failed: %v", err)
+	}
+	if strings.Contains(b.String(), "\x1b") || !strings.Contains(b.String(), "Sales") {
+		t.Errorf("Expected the bold title and coloured bar without escape sequences, got %q", b.String())
+	}
+}
@@ -0,0 +1,466 @@
+package diagrams
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// Align positions a diagram in a space larger than it is
+type Align int
+
+const (
+	// AlignStart places the diagram at the left or top
+	AlignStart Align = iota
+	// AlignCenter centres the diagram
+	AlignCenter
+	// AlignEnd places the diagram at the right or bottom
+	AlignEnd
+)
+
+// offset returns where something of the given size starts in a larger space
+func (a Align) offset(space, size int) int {
+	switch a {
+	case AlignCenter:
+		return (space - size) / 2
+	case AlignEnd:
+		return space - size
+	}
+	return 0
+}
+
+// Grid arranges diagrams in rows and columns. Each column is as wide as its
+// widest diagram and each row as tall as its tallest, and a diagram can span
+// several rows and columns. Diagrams smaller than their area are placed in it
+// by their alignment.
+//
+// A grid wider than MaxWidth shares the width between its columns: columns
+// narrower than an equal share keep their width, and the rest are narrowed
+// equally, with their diagrams rendered to fit. MaxHeight is shared between
+// rows in the same way.
+type Grid struct {
+	Cells     []*GridCell
+	ColumnGap int // Columns between grid columns
+	RowGap    int // Rows between grid rows
+}
+
+// GridCell places a diagram in a Grid
+type GridCell struct {
+	Diagram    Diagram
+	Row        int   // Row of the top-left corner, from 0
+	Column     int   // Column of the top-left corner, from 0
+	RowSpan    int   // Rows covered; 0 counts as 1
+	ColumnSpan int   // Columns covered; 0 counts as 1
+	Align      Align // Horizontal position in the cell's area
+	VAlign     Align // Vertical position in the cell's area
+}
+
+// NewGrid creates an empty grid with gaps of 2 columns and 1 row
+func NewGrid() *Grid {
+	return &Grid{ColumnGap: 2, RowGap: 1}
+}
+
+// Add places a diagram at a row and column and returns its cell, so that its
+// span and alignment can be set
+func (g *Grid) Add(row, column int, d Diagram) *GridCell {
+	cell := &GridCell{Diagram: d, Row: row, Column: column}
+	g.Cells = append(g.Cells, cell)
+	return cell
+}
+
+// SetGap sets the columns between grid columns and the rows between grid rows
+func (g *Grid) SetGap(columns, rows int) *Grid {
+	g.ColumnGap, g.RowGap = columns, rows
+	return g
+}
+
+// Span sets the number of rows and columns the cell covers
+func (c *GridCell) Span(rows, columns int) *GridCell {
+	c.RowSpan, c.ColumnSpan = rows, columns
+	return c
+}
+
+// SetAlign sets where the diagram sits in the cell's area
+func (c *GridCell) SetAlign(horizontal, vertical Align) *GridCell {
+	c.Align, c.VAlign = horizontal, vertical
+	return c
+}
+
+// spans returns the rows and columns the cell covers
+func (c *GridCell) spans() (int, int) {
+	return max(c.RowSpan, 1), max(c.ColumnSpan, 1)
+}
+
+// Render renders the grid with the default options
+func (g *Grid) Render() string {
+	return g.RenderWithOptions(RenderOptions{})
+}
+
+// RenderWithOptions renders the grid's diagrams in the options' theme, charset
+// and colour profile, sharing MaxWidth and MaxHeight between its columns and
+// rows
+func (g *Grid) RenderWithOptions(opts RenderOptions) string {
+	return g.canvas(opts).render(opts)
+}
+
+// RenderTo writes the grid to w as RenderWithOptions renders it, one row at a
+// time, and returns the number of bytes written
+func (g *Grid) RenderTo(w io.Writer, opts RenderOptions) (int64, error) {
+	return g.canvas(opts).writeTo(w, opts)
+}
+
+// WriteTo writes the grid to w as Render renders it, implementing io.WriterTo
+func (g *Grid) WriteTo(w io.Writer) (int64, error) {
+	return g.RenderTo(w, RenderOptions{})
+}
+
+// Size returns the columns and rows the grid takes when rendered with opts
+func (g *Grid) Size(opts RenderOptions) (int, int) {
+	l := g.layout(opts)
+	return opts.clamp(l.width, l.height)
+}
+
+// gridLayout holds the sizes of a grid's columns and rows, and the options
+// each cell is rendered with
+type gridLayout struct {
+	columns []int
+	rows    []int
+	opts    []RenderOptions // By cell
+	width   int
+	height  int
+}
+
+// layout sizes the columns and rows to fit the cells, and then to fit the
+// maximum size
+func (g *Grid) layout(opts RenderOptions) gridLayout {
+	// Cells are measured without limits, and the charset and colour profile
+	// are applied once the whole grid is drawn
+	base := opts
+	base.MaxWidth, base.MaxHeight = 0, 0
+	base.Charset, base.ColorProfile = nil, ProfileTrueColor
+
+	l := gridLayout{opts: make([]RenderOptions, len(g.Cells))}
+	columns, rows := 0, 0
+	widths := make([]span, len(g.Cells))
+	for i, cell := range g.Cells {
+		rowSpan, columnSpan := cell.spans()
+		rows = max(rows, cell.Row+rowSpan)
+		columns = max(columns, cell.Column+columnSpan)
+		l.opts[i] = base
+		width, _ := diagramSize(cell.Diagram, base)
+		widths[i] = span{cell.Column, columnSpan, width}
+	}
+
+	l.columns = fitTracks(trackSizes(columns, g.ColumnGap, widths), g.ColumnGap, opts.MaxWidth)
+	heights := make([]span, len(g.Cells))
+	for i, cell := range g.Cells {
+		rowSpan, _ := cell.spans()
+		if area := widths[i].length(l.columns, g.ColumnGap); widths[i].size > area {
+			l.opts[i].MaxWidth = max(area, 1)
+		}
+		_, height := diagramSize(cell.Diagram, l.opts[i])
+		heights[i] = span{cell.Row, rowSpan, height}
+	}
+
+	l.rows = fitTracks(trackSizes(rows, g.RowGap, heights), g.RowGap, opts.MaxHeight)
+	for i := range g.Cells {
+		if area := heights[i].length(l.rows, g.RowGap); heights[i].size > area {
+			l.opts[i].MaxHeight = max(area, 1)
+		}
+	}
+
+	l.width = span{0, columns, 0}.length(l.columns, g.ColumnGap)
+	l.height = span{0, rows, 0}.length(l.rows, g.RowGap)
+	return l
+}
+
+// canvas draws each cell's diagram in its area
+func (g *Grid) canvas(opts RenderOptions) *Canvas {
+	l := g.layout(opts)
+	c := NewCanvas(l.width, l.height)
+	for i, cell := range g.Cells {
+		rowSpan, columnSpan := cell.spans()
+		area := rect{
+			x:      trackStart(l.columns, g.ColumnGap, cell.Column),
+			y:      trackStart(l.rows, g.RowGap, cell.Row),
+			width:  span{cell.Column, columnSpan, 0}.length(l.columns, g.ColumnGap),
+			height: span{cell.Row, rowSpan, 0}.length(l.rows, g.RowGap),
+		}
+		drawDiagram(c, area, cell.Diagram, l.opts[i], cell.Align, cell.VAlign)
+	}
+	return c
+}
+
+// span is a run of grid columns or rows, and the size of the diagram that
+// covers it
+type span struct {
+	start, count, size int
+}
+
+// length returns the size of the span's tracks and the gaps between them
+func (s span) length(tracks []int, gap int) int {
+	if s.count == 0 {
+		return 0
+	}
+	length := gap * (s.count - 1)
+	for _, size := range tracks[s.start : s.start+s.count] {
+		length += size
+	}
+	return length
+}
+
+// trackStart returns where track i starts, after the tracks and gaps before it
+func trackStart(tracks []int, gap, i int) int {
+	start := gap * i
+	for _, size := range tracks[:i] {
+		start += size
+	}
+	return start
+}
+
+// trackSizes sizes n columns or rows so that every span fits. Tracks take the
+// size of the largest diagram covering only them, and what diagrams spanning
+// several tracks still need is spread over their tracks.
+func trackSizes(n, gap int, spans []span) []int {
+	sorted := append([]span(nil), spans...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].count < sorted[j].count })
+
+	tracks := make([]int, n)
+	for _, s := range sorted {
+		need := s.size - s.length(tracks, gap)
+		for k := 0; need > 0 && k < s.count; k++ {
+			// The last tracks take the remainder
+			extra := need / s.count
+			if k >= s.count-need%s.count {
+				extra++
+			}
+			tracks[s.start+k] += extra
+		}
+	}
+	return tracks
+}
+
+// fitTracks narrows tracks to fit in maxSize, with gaps between them. Tracks
+// smaller than an equal share of the space keep their size, and the others
+// split what is left equally.
+func fitTracks(tracks []int, gap, maxSize int) []int {
+	n := len(tracks)
+	if maxSize <= 0 || n == 0 || (span{0, n, 0}).length(tracks, gap) <= maxSize {
+		return tracks
+	}
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return tracks[order[i]] < tracks[order[j]] })
+
+	space := max(maxSize-gap*(n-1), 0)
+	fitted := make([]int, n)
+	for k, i := range order {
+		fitted[i] = min(tracks[i], space/(n-k))
+		space -= fitted[i]
+	}
+	return fitted
+}
+
+// drawer is implemented by the diagrams of this package, which draw onto a
+// Canvas that composites copy cell by cell
+type drawer interface {
+	canvas(opts RenderOptions) *Canvas
+}
+
+// diagramCanvas draws a diagram onto a canvas. Diagrams from other packages
+// are rendered, and their text, with any escape codes, is drawn; those that
+// aren't a SizedDiagram are rendered by Render, without opts.
+func diagramCanvas(d Diagram, opts RenderOptions) *Canvas {
+	if d, ok := d.(drawer); ok {
+		return d.canvas(opts)
+	}
+	text, width := d.Render(), 0
+	if d, ok := d.(SizedDiagram); ok {
+		text = d.RenderWithOptions(opts)
+		width, _ = d.Size(opts)
+	}
+	lines := strings.Split(text, "\n")
+	for _, line := range lines {
+		width = max(width, StringWidth(line))
+	}
+	c := NewCanvas(width, len(lines))
+	for y, line := range lines {
+		c.Text(0, y, line, Style{})
+	}
+	return c
+}
+
+// diagramSize returns the columns and rows a diagram takes when rendered with
+// opts, measuring the text of those that aren't a SizedDiagram
+func diagramSize(d Diagram, opts RenderOptions) (int, int) {
+	if d, ok := d.(SizedDiagram); ok {
+		return d.Size(opts)
+	}
+	c := diagramCanvas(d, opts)
+	return c.Width(), c.Height()
+}
+
+// drawDiagram draws a diagram, rendered with opts, in an area of the canvas,
+// placed by the alignments and cut off at the area's edges
+func drawDiagram(c *Canvas, area rect, d Diagram, opts RenderOptions, align, valign Align) {
+	src := diagramCanvas(d, opts)
+	width, height := opts.clamp(src.Width(), src.Height())
+	width, height = min(width, area.width), min(height, area.height)
+	x := area.x + align.offset(area.width, width)
+	y := area.y + valign.offset(area.height, height)
+
+	c.SetClip(x, y, width, height).DrawCanvas(x, y, src).ResetClip()
+
+	// A wide character split by the right edge is dropped
+	for row := 0; row < height && width > 0; row++ {
+		if src.Cell(width, row).Char == "" {
+			c.cells[y+row][x+width-1] = Cell{Char: " ", Style: src.Cell(width-1, row).Style}
+		}
+	}
+}
+
+// HStack places diagrams side by side, from left to right. It is a grid of
+// one row, and shares MaxWidth between its diagrams in the same way.
+type HStack struct {
+	Items []Diagram
+	Gap   int   // Columns between diagrams
+	Align Align // Vertical position of diagrams shorter than the tallest
+}
+
+// NewHStack creates a horizontal stack with a gap of 2 columns
+func NewHStack(items ...Diagram) *HStack {
+	return &HStack{Items: items, Gap: 2}
+}
+
+// Add appends diagrams to the right of the stack
+func (s *HStack) Add(items ...Diagram) *HStack {
+	s.Items = append(s.Items, items...)
+	return s
+}
+
+// SetGap sets the columns between diagrams
+func (s *HStack) SetGap(gap int) *HStack {
+	s.Gap = gap
+	return s
+}
+
+// SetAlign sets the vertical position of diagrams shorter than the tallest
+func (s *HStack) SetAlign(align Align) *HStack {
+	s.Align = align
+	return s
+}
+
+// grid returns the stack as a grid of one row
+func (s *HStack) grid() *Grid {
+	g := &Grid{ColumnGap: s.Gap}
+	for i, item := range s.Items {
+		g.Add(0, i, item).SetAlign(AlignStart, s.Align)
+	}
+	return g
+}
+
+// Render renders the stack with the default options
+func (s *HStack) Render() string {
+	return s.grid().Render()
+}
+
+// RenderWithOptions renders the stack's diagrams in the options' theme,
+// charset and colour profile, sharing MaxWidth between them
+func (s *HStack) RenderWithOptions(opts RenderOptions) string {
+	return s.grid().RenderWithOptions(opts)
+}
+
+// RenderTo writes the stack to w as RenderWithOptions renders it, one row at a
+// time, and returns the number of bytes written
+func (s *HStack) RenderTo(w io.Writer, opts RenderOptions) (int64, error) {
+	return s.grid().RenderTo(w, opts)
+}
+
+// WriteTo writes the stack to w as Render renders it, implementing io.WriterTo
+func (s *HStack) WriteTo(w io.Writer) (int64, error) {
+	return s.grid().WriteTo(w)
+}
+
+// Size returns the columns and rows the stack takes when rendered with opts
+func (s *HStack) Size(opts RenderOptions) (int, int) {
+	return s.grid().Size(opts)
+}
+
+func (s *HStack) canvas(opts RenderOptions) *Canvas {
+	return s.grid().canvas(opts)
+}
+
+// VStack places diagrams one above the other, from top to bottom. It is a
+// grid of one column, and shares MaxHeight between its diagrams in the same
+// way.
+type VStack struct {
+	Items []Diagram
+	Gap   int   // Rows between diagrams
+	Align Align // Horizontal position of diagrams narrower than the widest
+}
+
+// NewVStack creates a vertical stack with a gap of 1 row
+func NewVStack(items ...Diagram) *VStack {
+	return &VStack{Items: items, Gap: 1}
+}
+
+// Add appends diagrams to the bottom of the stack
+func (s *VStack) Add(items ...Diagram) *VStack {
+	s.Items = append(s.Items, items...)
+	return s
+}
+
+// SetGap sets the rows between diagrams
+func (s *VStack) SetGap(gap int) *VStack {
+	s.Gap = gap
+	return s
+}
+
+// SetAlign sets the horizontal position of diagrams narrower than the widest
+func (s *VStack) SetAlign(align Align) *VStack {
+	s.Align = align
+	return s
+}
+
+// grid returns the stack as a grid of one column
+func (s *VStack) grid() *Grid {
+	g := &Grid{RowGap: s.Gap}
+	for i, item := range s.Items {
+		g.Add(i, 0, item).SetAlign(s.Align, AlignStart)
+	}
+	return g
+}
+
+// Render renders the stack with the default options
+func (s *VStack) Render() string {
+	return s.grid().Render()
+}
+
+// RenderWithOptions renders the stack's diagrams in the options' theme,
+// charset and colour profile, sharing MaxHeight between them
+func (s *VStack) RenderWithOptions(opts RenderOptions) string {
+	return s.grid().RenderWithOptions(opts)
+}
+
+// RenderTo writes the stack to w as RenderWithOptions renders it, one row at a
+// time, and returns the number of bytes written
+func (s *VStack) RenderTo(w io.Writer, opts RenderOptions) (int64, error) {
+	return s.grid().RenderTo(w, opts)
+}
+
+// WriteTo writes the stack to w as Render renders it, implementing io.WriterTo
+func (s *VStack) WriteTo(w io.Writer) (int64, error) {
+	return s.grid().WriteTo(w)
+}
+
+// Size returns the columns and rows the stack takes when rendered with opts
+func (s *VStack) Size(opts RenderOptions) (int, int) {
+	return s.grid().Size(opts)
+}
+
+func (s *VStack) canvas(opts RenderOptions) *Canvas {
+	return s.grid().canvas(opts)
+}
@@ -0,0 +1,148 @@
+package diagrams
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// block returns a canvas showing the given lines
+func block(lines ...string) *Canvas {
+	width := 0
+	for _, line := range lines {
+		width = max(width, StringWidth(line))
+	}
+	c := NewCanvas(width, len(lines))
+	for y, line := range lines {
+		c.Text(0, y, line, Style{})
+	}
+	return c
+}
+
+// textDiagram is a Diagram from outside the package that only implements
+// Render, rendered as fixed text
+type textDiagram string
+
+func (d textDiagram) Render() string { return string(d) }
+
+func TestHStack_Render(t *testing.T) {
+	tests := []struct {
+		align    Align
+		expected string
+	}{
+		{AlignStart, "aaa  b\naaa\naaa"},
+		{AlignCenter, "aaa\naaa  b\naaa"},
+		{AlignEnd, "aaa\naaa\naaa  b"},
+	}
+
+	for _, tt := range tests {
+		stack := NewHStack(block("aaa", "aaa", "aaa"), block("b")).SetAlign(tt.align)
+		if got := stack.Render(); got != tt.expected {
+			t.Errorf("Align %d: expected:\n%s\ngot:\n%s", tt.align, tt.expected, got)
+		}
+	}
+}
+
+func TestVStack_Render(t *testing.T) {
+	stack := NewVStack(block("aaaaa"), block("b"), block("cc")).SetAlign(AlignEnd).SetGap(0)
+	if got, want := stack.Render(), "aaaaa\n    b\n   cc"; got != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestGrid_Render(t *testing.T) {
+	grid := NewGrid().SetGap(1, 0)
+	grid.Add(0, 0, block("a"))
+	grid.Add(0, 1, block("bb"))
+	grid.Add(1, 0, block("cccccc")).Span(1, 2)
+	grid.Add(0, 2, block("d", "d")).Span(2, 1)
+	grid.Add(2, 0, block("e")).Span(1, 3).SetAlign(AlignCenter, AlignStart)
+
+	expected := strings.Join([]string{
+		"a  bb  d",
+		"cccccc d",
+		"   e",
+	}, "\n")
+	if got := grid.Render(); got != expected {
+		t.Errorf("Expected:\n%s\n\nGot:\n%s", expected, got)
+	}
+}
+
+func TestGrid_RenderWithOptions_MaxWidth(t *testing.T) {
+	narrow := block("ab")
+	wide := NewBarChart("", Horizontal).SetWidth(40).AddBar("a", 1)
+	grid := NewGrid()
+	grid.Add(0, 0, narrow)
+	grid.Add(0, 1, wide)
+
+	output := grid.RenderWithOptions(RenderOptions{MaxWidth: 30})
+	if output != "ab  a │ ████████████████████ 1" {
+		t.Errorf("Expected the narrow column kept and the chart shortened, got %q", output)
+	}
+}
+
+func TestTrackSizes(t *testing.T) {
+	tests := []struct {
+		name     string
+		n, gap   int
+		spans    []span
+		expected []int
+	}{
+		{"largest wins", 2, 1, []span{{0, 1, 3}, {0, 1, 5}, {1, 1, 2}}, []int{5, 2}},
+		{"span fits already", 2, 1, []span{{0, 1, 3}, {1, 1, 3}, {0, 2, 7}}, []int{3, 3}},
+		{"span spread evenly", 3, 0, []span{{0, 3, 8}}, []int{2, 3, 3}},
+		{"span adds to its tracks", 3, 1, []span{{0, 1, 4}, {1, 2, 9}}, []int{4, 4, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := trackSizes(tt.n, tt.gap, tt.spans)
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("trackSizes() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFitTracks(t *testing.T) {
+	tests := []struct {
+		tracks   []int
+		gap, max int
+		expected []int
+	}{
+		{[]int{5, 5}, 2, 0, []int{5, 5}},
+		{[]int{5, 5}, 2, 20, []int{5, 5}},
+		{[]int{2, 20, 30}, 1, 22, []int{2, 9, 9}},
+		{[]int{10, 10}, 0, 15, []int{7, 8}},
+		{[]int{10, 10}, 20, 15, []int{0, 0}},
+	}
+
+	for _, tt := range tests {
+		if got := fitTracks(tt.tracks, tt.gap, tt.max); fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+			t.Errorf("fitTracks(%v, %d, %d) = %v, want %v", tt.tracks, tt.gap, tt.max, got, tt.expected)
+		}
+	}
+}
+
+func TestGrid_ForeignDiagram(t *testing.T) {
+	red := Style{Fg: sgr(31)}
+	stack := NewHStack(textDiagram("x\n"+Styled("yy", red).String()), block("z"))
+
+	c := stack.canvas(RenderOptions{})
+	if got := stripANSI(stack.Render()); got != "x   z\nyy" {
+		t.Errorf("Expected the rendered text to be placed, got %q", got)
+	}
+	if got := c.Cell(1, 1).Style; got != red {
+		t.Errorf("Expected the text's escape codes to become cell styles, got %+v", got)
+	}
+}
+
+func TestHStack_RenderWithOptions_Charset(t *testing.T) {
+	flow := NewFlowchart(TopToBottom).AddNode("a", "A", ShapeRounded)
+	stack := NewHStack(flow, flow)
+
+	// The charset is applied once, to the whole stack
+	if got := stack.RenderWithOptions(RenderOptions{Charset: HeavyCharset()}); !strings.HasPrefix(got, "┏━━━┓  ┏━━━┓") {
+		t.Errorf("Expected heavy corners, got:\n%s", got)
+	}
+}
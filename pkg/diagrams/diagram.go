@@ -2,12 +2,79 @@
 // It supports flowcharts, sequence diagrams, and basic charts using Unicode box-drawing characters.
 package diagrams
 
+import "io"
+
 // Diagram represents any renderable diagram
 type Diagram interface {
 	// Render converts the diagram to a string suitable for terminal display
 	Render() string
 }
 
+// SizedDiagram is a diagram that adapts to the space and terminal it is
+// rendered for. Every diagram type of this package implements it; composites
+// render other diagrams as Render renders them.
+type SizedDiagram interface {
+	Diagram
+	// RenderWithOptions renders the diagram to fit the space and terminal
+	// described by opts
+	RenderWithOptions(opts RenderOptions) string
+	// RenderTo writes the diagram to w as RenderWithOptions renders it, one
+	// row at a time, and returns the number of bytes written and any write
+	// error
+	RenderTo(w io.Writer, opts RenderOptions) (int64, error)
+	// Size returns the columns and rows the diagram takes when rendered with
+	// opts. Rendered lines may be shorter, as trailing blanks are trimmed.
+	Size(opts RenderOptions) (width, height int)
+}
+
+// RenderOptions control how a diagram is rendered. The zero value renders as
+// Render does.
+type RenderOptions struct {
+	// MaxWidth is the number of columns available, or 0 for no limit.
+	// Diagrams adapt to it: flowcharts move nodes closer and wrap labels,
+	// sequence diagrams narrow their columns and bar charts shorten their
+	// bars. Whatever still doesn't fit is cut off.
+	MaxWidth int
+	// MaxHeight is the number of rows available, or 0 for no limit. Vertical
+	// bar charts shorten their bars to fit; other diagrams are cut off.
+	MaxHeight int
+	// Theme sets the styles the diagram is drawn in; nil draws without styles
+	Theme *Theme
+	// ColorProfile is the range of colours the output may use; colours are
+	// converted to the nearest the profile has. See DetectColorProfile.
+	ColorProfile ColorProfile
+	// Charset sets the characters lines, arrows and shapes are drawn with; nil
+	// draws with light Unicode box-drawing characters
+	Charset *Charset
+}
+
+// theme returns the theme to draw with, the zero Theme when none is set
+func (o RenderOptions) theme() *Theme {
+	if o.Theme == nil {
+		return &Theme{}
+	}
+	return o.Theme
+}
+
+// charset returns the charset to draw with, the light Unicode one when none is set
+func (o RenderOptions) charset() *Charset {
+	if o.Charset == nil {
+		return &Charset{}
+	}
+	return o.Charset
+}
+
+// clamp limits a width and height to the maximum size
+func (o RenderOptions) clamp(width, height int) (int, int) {
+	if o.MaxWidth > 0 {
+		width = min(width, o.MaxWidth)
+	}
+	if o.MaxHeight > 0 {
+		height = min(height, o.MaxHeight)
+	}
+	return width, height
+}
+
 // Direction represents the flow direction for diagrams
 type Direction int
 
@@ -0,0 +1,216 @@
+package diagrams
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+var (
+	_ SizedDiagram = (*Flowchart)(nil)
+	_ SizedDiagram = (*SequenceDiagram)(nil)
+	_ SizedDiagram = (*BarChart)(nil)
+	_ SizedDiagram = (*Canvas)(nil)
+	_ SizedDiagram = (*Grid)(nil)
+	_ SizedDiagram = (*HStack)(nil)
+	_ SizedDiagram = (*VStack)(nil)
+	_ SizedDiagram = (*Frame)(nil)
+	_ SizedDiagram = (*Legend)(nil)
+
+	_ io.WriterTo = (*Flowchart)(nil)
+	_ io.WriterTo = (*SequenceDiagram)(nil)
+	_ io.WriterTo = (*BarChart)(nil)
+	_ io.WriterTo = (*Canvas)(nil)
+	_ io.WriterTo = (*Grid)(nil)
+)
+
+// testDiagrams returns a diagram of each type, all wider than 40 columns
+func testDiagrams() map[string]SizedDiagram {
+	flow := NewFlowchart(LeftToRight).
+		AddNode("a", "Receive the order", ShapeBox).
+		AddNode("b", "Is it in stock?", ShapeDiamond).
+		AddNode("c", "Ship it to the customer", ShapeRounded)
+	flow.AddEdge("a", "b", "").AddEdge("b", "c", "yes")
+
+	seq := NewSequenceDiagram().
+		AddActor("C", "Client").AddActor("S", "Server").AddActor("D", "Database").AddActor("Q", "Queue").
+		AddMessage("C", "S", "POST /orders", MessageSync).
+		AddMessage("S", "D", "INSERT INTO orders", MessageSync).
+		AddMessage("S", "Q", "order.created", MessageAsync).
+		AddMessage("S", "C", "201 Created", MessageReturn).
+		AddMessage("S", "S", "log the request", MessageSync)
+
+	horizontal := NewBarChart("Requests per endpoint", Horizontal).SetWidth(50).AddBar("/orders", 120).AddBar("/health", 30)
+	vertical := NewBarChart("Requests", Vertical).SetWidth(8).AddBar("/orders", 120).AddBar("/health", 30).AddBar("/users", 60).AddBar("/carts", 45).AddBar("/login", 80)
+
+	grid := NewGrid()
+	grid.Add(0, 0, horizontal)
+	grid.Add(0, 1, vertical).Span(2, 1)
+	grid.Add(1, 0, flow).SetAlign(AlignCenter, AlignEnd)
+
+	return map[string]SizedDiagram{
+		"flowchart":           flow,
+		"sequence":            seq,
+		"horizontal barchart": horizontal,
+		"vertical barchart":   vertical,
+		"grid":                grid,
+		"stacks":              NewVStack(NewHStack(horizontal, flow), seq),
+		"frame":               NewFrame(seq).SetTitle("Ordering", AlignCenter).SetFooter("v2", AlignEnd),
+		"legend":              NewLegend(LeftToRight).Add("██", "Requests per endpoint", Style{}).Add("┄┄→", "Planned capacity", Style{}),
+	}
+}
+
+func TestDiagram_Size(t *testing.T) {
+	for name, d := range testDiagrams() {
+		for _, opts := range []RenderOptions{{}, {MaxWidth: 30}, {MaxHeight: 5}} {
+			width, height := d.Size(opts)
+			lines := strings.Split(d.RenderWithOptions(opts), "\n")
+			if len(lines) != height {
+				t.Errorf("%s %+v: Size height %d, rendered %d lines", name, opts, height, len(lines))
+			}
+			widest := 0
+			for _, line := range lines {
+				widest = max(widest, StringWidth(line))
+			}
+			if widest > width {
+				t.Errorf("%s %+v: Size width %d, rendered %d columns", name, opts, width, widest)
+			}
+		}
+	}
+}
+
+func TestRenderWithOptions_MaxWidth(t *testing.T) {
+	for name, d := range testDiagrams() {
+		if width, _ := d.Size(RenderOptions{}); width <= 40 {
+			t.Errorf("%s: expected a diagram wider than 40 columns, got %d", name, width)
+		}
+		output := d.RenderWithOptions(RenderOptions{MaxWidth: 40})
+		for _, line := range strings.Split(output, "\n") {
+			if StringWidth(line) > 40 {
+				t.Errorf("%s: line wider than 40 columns:\n%s", name, output)
+				break
+			}
+		}
+	}
+}
+
+func TestFlowchart_RenderWithOptions_Wrap(t *testing.T) {
+	flow := NewFlowchart(LeftToRight).
+		AddNode("a", "Receive the order", ShapeBox).
+		AddNode("b", "Ship it", ShapeBox)
+	flow.AddEdge("a", "b", "")
+
+	output := flow.RenderWithOptions(RenderOptions{MaxWidth: 30})
+	for _, want := range []string{"Receive the", "order", "Ship it"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected %q in wrapped output:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, Ellipsis) {
+		t.Errorf("Expected labels to be wrapped, not cut off:\n%s", output)
+	}
+}
+
+func TestFlowchart_RenderWithOptions_WrapFramesAndLabels(t *testing.T) {
+	flow := NewFlowchart(TopToBottom).
+		AddNode("a", "Start", ShapeBox).
+		AddNode("b", "Handle the request", ShapeBox).
+		AddNode("c", "Reject it", ShapeBox).
+		AddEdge("a", "b", "Yes path").
+		AddEdge("a", "c", "No")
+	flow.AddSubgraph("s", "A cluster of handlers").Add("b", "c")
+
+	opts := RenderOptions{MaxWidth: 30}
+	if l := flow.layout(opts); l.width > opts.MaxWidth {
+		t.Fatalf("Expected the frame and labels to fit in %d columns, got %d", opts.MaxWidth, l.width)
+	}
+
+	// Words are never split, and the label keeps off the title's row
+	output := flow.RenderWithOptions(opts)
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, "Yes") && strings.Contains(line, "A clus") {
+			t.Errorf("Expected the label and title on separate rows:\n%s", output)
+		}
+	}
+	if !strings.Contains(output, "│ request │") {
+		t.Errorf("Expected labels wrapped at spaces:\n%s", output)
+	}
+}
+
+func TestSequenceDiagram_RenderWithOptions_MaxWidth(t *testing.T) {
+	seq := NewSequenceDiagram().AddActor("A", "Alice").AddActor("B", "Bob").AddActor("C", "Carol").
+		AddMessage("A", "C", "Hello", MessageSync)
+
+	// Columns shrink before anything is cut off
+	output := seq.RenderWithOptions(RenderOptions{MaxWidth: 30})
+	for _, want := range []string{"Alice", "Bob", "Carol", "Hello"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected %q in narrowed output:\n%s", want, output)
+		}
+	}
+}
+
+func TestBarChart_RenderWithOptions_MaxSize(t *testing.T) {
+	horizontal := NewBarChart("", Horizontal).SetWidth(40).AddBar("a", 10).AddBar("b", 5)
+	lines := strings.Split(horizontal.RenderWithOptions(RenderOptions{MaxWidth: 20}), "\n")
+	if lines[0] != "a │ █████████████ 10" || lines[1] != "b │ ██████ 5" {
+		t.Errorf("Expected bars scaled to fit, got:\n%s", strings.Join(lines, "\n"))
+	}
+
+	vertical := NewBarChart("", Vertical).SetHeight(10).AddBar("a", 10).AddBar("b", 5)
+	if _, height := vertical.Size(RenderOptions{MaxHeight: 6}); height != 6 {
+		t.Errorf("Expected the chart to shrink to 6 rows, got %d", height)
+	}
+	lines = strings.Split(vertical.RenderWithOptions(RenderOptions{MaxHeight: 6}), "\n")
+	if !strings.Contains(lines[len(lines)-1], "5") {
+		t.Errorf("Expected values to stay visible, got:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestDiagram_RenderTo(t *testing.T) {
+	opts := RenderOptions{MaxWidth: 40, Theme: DarkTheme(), Charset: ASCIICharset()}
+	for name, d := range testDiagrams() {
+		var b strings.Builder
+		n, err := d.RenderTo(&b, opts)
+		want := d.RenderWithOptions(opts)
+		if err != nil || b.String() != want || n != int64(len(want)) {
+			t.Errorf("%s: RenderTo wrote %d bytes, %v:\n%s\nwant:\n%s", name, n, err, b.String(), want)
+		}
+
+		b.Reset()
+		if _, err := d.(io.WriterTo).WriteTo(&b); err != nil || b.String() != d.Render() {
+			t.Errorf("%s: WriteTo wrote %v:\n%s", name, err, b.String())
+		}
+	}
+}
+
+// failingWriter accepts a number of writes and then fails
+type failingWriter struct {
+	writes  int
+	written int
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.writes == 0 {
+		return 0, errWriteFailed
+	}
+	w.writes--
+	w.written += len(p)
+	return len(p), nil
+}
+
+func TestDiagram_RenderTo_WriteError(t *testing.T) {
+	chart := NewBarChart("", Horizontal).AddBar("a", 1).AddBar("b", 2).AddBar("c", 3)
+	w := &failingWriter{writes: 2}
+
+	n, err := chart.RenderTo(w, RenderOptions{})
+	if !errors.Is(err, errWriteFailed) {
+		t.Errorf("Expected the write error, got %v", err)
+	}
+	if n != int64(w.written) || n == 0 {
+		t.Errorf("Expected the %d bytes of the first two rows, got %d", w.written, n)
+	}
+}
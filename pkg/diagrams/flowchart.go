@@ -1,9 +1,6 @@
 package diagrams
 
-import (
-	"fmt"
-	"strings"
-)
+import "io"
 
 // NodeShape defines the visual style of a flowchart node
 type NodeShape int
@@ -17,20 +14,88 @@ const (
 	ShapeDiamond
 	// ShapeCircle is a circular shape
 	ShapeCircle
+	// ShapeStadium is a pill shape with round ends (for terminal points)
+	ShapeStadium
+	// ShapeSubroutine is a box with doubled sides (for subroutines)
+	ShapeSubroutine
+	// ShapeCylinder is a cylinder (for databases)
+	ShapeCylinder
+	// ShapeAsymmetric is a flag with a notch on the left
+	ShapeAsymmetric
+	// ShapeHexagon is a hexagon (for preparation steps)
+	ShapeHexagon
+	// ShapeParallelogram is a parallelogram leaning right (for input and output)
+	ShapeParallelogram
+	// ShapeParallelogramAlt is a parallelogram leaning left
+	ShapeParallelogramAlt
+	// ShapeTrapezoid is a trapezoid, wider at the bottom
+	ShapeTrapezoid
+	// ShapeTrapezoidAlt is a trapezoid, wider at the top
+	ShapeTrapezoidAlt
+	// ShapeDoubleCircle is a circle inside a circle (for stop states)
+	ShapeDoubleCircle
 )
 
 // Node represents a single node in a flowchart
 type Node struct {
 	ID    string
-	Label string
+	Label string // Text of the node; a newline starts a new line
 	Shape NodeShape
+	// Markdown draws **bold** and *italic* text in the label in bold and italic
+	Markdown bool
 }
 
+// EdgeStyle defines how the line of a flowchart edge is drawn
+type EdgeStyle int
+
+const (
+	// EdgeSolid is a plain line
+	EdgeSolid EdgeStyle = iota
+	// EdgeDotted is a dashed line (┄)
+	EdgeDotted
+	// EdgeThick is a heavy line (━)
+	EdgeThick
+	// EdgeInvisible is not drawn but still takes part in the layout
+	EdgeInvisible
+)
+
+// ArrowHead defines the marker drawn at one end of an edge
+type ArrowHead int
+
+const (
+	// HeadNone ends the line at the node's border
+	HeadNone ArrowHead = iota
+	// HeadArrow is an arrow pointing at the node
+	HeadArrow
+	// HeadCircle is a circle (◯)
+	HeadCircle
+	// HeadCross is a cross (✕)
+	HeadCross
+)
+
 // Edge represents a connection between two nodes
 type Edge struct {
-	From  string
-	To    string
-	Label string // Optional label for the edge
+	From     string
+	To       string
+	Label    string // Optional label for the edge
+	Style    EdgeStyle
+	FromHead ArrowHead // Marker at the From end
+	ToHead   ArrowHead // Marker at the To end
+}
+
+// Subgraph groups flowchart nodes into a cluster, drawn as a titled frame
+// around its members. Subgraphs can be nested.
+//
+// A subgraph with its own Direction is laid out in that direction as long as
+// no edge links its members to nodes outside it; otherwise, as in Mermaid,
+// the flowchart's direction is used. Edges may point at the subgraph ID
+// itself, in which case they connect to the frame.
+type Subgraph struct {
+	ID        string
+	Title     string
+	Direction *Direction // Optional direction override, nil to follow the flowchart
+	Nodes     []string   // IDs of the nodes directly inside this subgraph
+	Subgraphs []*Subgraph
 }
 
 // Flowchart represents a flowchart diagram
@@ -38,6 +103,7 @@ type Flowchart struct {
 	Direction Direction
 	Nodes     []Node
 	Edges     []Edge
+	Subgraphs []*Subgraph
 }
 
 // NewFlowchart creates a new flowchart with the given direction
@@ -59,373 +125,332 @@ func (f *Flowchart) AddNode(id, label string, shape NodeShape) *Flowchart {
 	return f
 }
 
-// AddEdge adds an edge between two nodes
+// AddEdge adds a solid edge with an arrow pointing at the target node
 func (f *Flowchart) AddEdge(from, to, label string) *Flowchart {
+	return f.AddStyledEdge(from, to, label, EdgeSolid, HeadNone, HeadArrow)
+}
+
+// AddStyledEdge adds an edge with the given line style and end markers
+func (f *Flowchart) AddStyledEdge(from, to, label string, style EdgeStyle, fromHead, toHead ArrowHead) *Flowchart {
 	f.Edges = append(f.Edges, Edge{
-		From:  from,
-		To:    to,
-		Label: label,
+		From:     from,
+		To:       to,
+		Label:    label,
+		Style:    style,
+		FromHead: fromHead,
+		ToHead:   toHead,
 	})
 	return f
 }
 
-// Render converts the flowchart to ASCII art
-func (f *Flowchart) Render() string {
-	if f.Direction == TopToBottom {
-		return f.renderVertical()
-	}
-	return f.renderHorizontal()
+// AddSubgraph adds a top-level subgraph and returns it so that nodes and
+// nested subgraphs can be added to it
+func (f *Flowchart) AddSubgraph(id, title string) *Subgraph {
+	sub := &Subgraph{ID: id, Title: title}
+	f.Subgraphs = append(f.Subgraphs, sub)
+	return sub
 }
 
-func (f *Flowchart) renderVertical() string {
-	if len(f.Nodes) == 0 {
-		return ""
-	}
-
-	// Build graph structure
-	nodeMap := make(map[string]Node)
-	outgoing := make(map[string][]Edge)
-	incomingCount := make(map[string]int)
+// Add places nodes, by ID, directly inside the subgraph
+func (s *Subgraph) Add(ids ...string) *Subgraph {
+	s.Nodes = append(s.Nodes, ids...)
+	return s
+}
 
-	for _, node := range f.Nodes {
-		nodeMap[node.ID] = node
-		incomingCount[node.ID] = 0
-	}
+// AddSubgraph adds a nested subgraph and returns it
+func (s *Subgraph) AddSubgraph(id, title string) *Subgraph {
+	sub := &Subgraph{ID: id, Title: title}
+	s.Subgraphs = append(s.Subgraphs, sub)
+	return sub
+}
 
-	for _, edge := range f.Edges {
-		outgoing[edge.From] = append(outgoing[edge.From], edge)
-		incomingCount[edge.To]++
-	}
+// SetDirection overrides the flow direction inside the subgraph
+func (s *Subgraph) SetDirection(direction Direction) *Subgraph {
+	s.Direction = &direction
+	return s
+}
 
-	// Find root nodes (nodes with no incoming edges)
-	var roots []string
-	for _, node := range f.Nodes {
-		if incomingCount[node.ID] == 0 {
-			roots = append(roots, node.ID)
-		}
-	}
+// Render converts the flowchart to ASCII art.
+//
+// Nodes are arranged in ranks along the flow direction so that branches sit
+// side by side and every edge is drawn as a connected line to its target.
+func (f *Flowchart) Render() string {
+	return f.RenderWithOptions(RenderOptions{})
+}
 
-	// If no roots found (cycle graph), use first node
-	if len(roots) == 0 {
-		roots = []string{f.Nodes[0].ID}
-	}
+// RenderWithOptions renders the flowchart like Render, in the options' theme,
+// charset and colour profile. When the flowchart is wider than MaxWidth,
+// siblings are moved closer together, node labels are wrapped at spaces, and
+// edge labels and subgraph titles are shortened to the same width.
+func (f *Flowchart) RenderWithOptions(opts RenderOptions) string {
+	return f.canvas(opts).render(opts)
+}
 
-	// BFS traversal to determine rendering order
-	var renderOrder []string
-	visited := make(map[string]bool)
-	queue := make([]string, len(roots))
-	copy(queue, roots)
+// RenderTo writes the flowchart to w as RenderWithOptions renders it, one row
+// at a time, and returns the number of bytes written
+func (f *Flowchart) RenderTo(w io.Writer, opts RenderOptions) (int64, error) {
+	return f.canvas(opts).writeTo(w, opts)
+}
 
-	for len(queue) > 0 {
-		nodeID := queue[0]
-		queue = queue[1:]
+// WriteTo writes the flowchart to w as Render renders it, implementing io.WriterTo
+func (f *Flowchart) WriteTo(w io.Writer) (int64, error) {
+	return f.RenderTo(w, RenderOptions{})
+}
 
-		if visited[nodeID] {
-			continue
-		}
-		visited[nodeID] = true
-		renderOrder = append(renderOrder, nodeID)
-
-		// Add children to queue
-		for _, edge := range outgoing[nodeID] {
-			if !visited[edge.To] {
-				queue = append(queue, edge.To)
-			}
-		}
+// Size returns the columns and rows the flowchart takes when rendered with opts
+func (f *Flowchart) Size(opts RenderOptions) (int, int) {
+	if f.empty() {
+		return 0, 0
 	}
+	l := f.layout(opts)
+	return opts.clamp(l.width, l.height)
+}
 
-	// Render nodes in order
-	var output strings.Builder
-	rendered := make(map[string]bool)
-
-	for _, nodeID := range renderOrder {
-		if rendered[nodeID] {
-			continue
-		}
-		rendered[nodeID] = true
-
-		node := nodeMap[nodeID]
-
-		// Render the node
-		if output.Len() > 0 {
-			output.WriteString("\n")
-		}
-		output.WriteString(renderNode(node))
-		output.WriteString("\n")
-
-		// Render ALL outgoing edges from this node
-		edges := outgoing[nodeID]
-		if len(edges) > 0 {
-			for i, edge := range edges {
-				targetNode := nodeMap[edge.To]
-				output.WriteString(renderVerticalEdgeWithTarget(edge, targetNode))
-				if i < len(edges)-1 {
-					output.WriteString("\n")
-				}
-			}
-			output.WriteString("\n")
-		}
+// canvas draws the flowchart, on an empty canvas when it has nothing to draw
+func (f *Flowchart) canvas(opts RenderOptions) *Canvas {
+	if f.empty() {
+		return NewCanvas(0, 0)
 	}
-
-	return strings.TrimSpace(output.String())
+	return f.layout(opts).draw(opts.theme())
 }
 
-func (f *Flowchart) renderHorizontal() string {
-	if len(f.Nodes) == 0 {
-		return ""
-	}
+func (f *Flowchart) empty() bool {
+	return len(f.Nodes) == 0 && len(f.Edges) == 0 && len(f.Subgraphs) == 0
+}
 
-	// Build graph structure
-	nodeMap := make(map[string]Node)
-	outgoing := make(map[string][]Edge)
-	incomingCount := make(map[string]int)
+// minWrapWidth is the narrowest width node labels are wrapped to
+const minWrapWidth = 6
 
-	for _, node := range f.Nodes {
-		nodeMap[node.ID] = node
-		incomingCount[node.ID] = 0
+// layout lays out the flowchart, as narrow as it takes to fit MaxWidth if it
+// can: first with less space between siblings, then with its labels and
+// titles wrapped to the widest width that fits
+func (f *Flowchart) layout(opts RenderOptions) *flowLayout {
+	l := newFlowLayout(f)
+	if opts.MaxWidth <= 0 || l.width <= opts.MaxWidth {
+		return l
 	}
-
-	for _, edge := range f.Edges {
-		outgoing[edge.From] = append(outgoing[edge.From], edge)
-		incomingCount[edge.To]++
+	if l = newSpacedFlowLayout(f, layoutNodeGapCompact); l.width <= opts.MaxWidth {
+		return l
 	}
 
-	// Find root nodes (nodes with no incoming edges)
-	var roots []string
+	widest := 0
 	for _, node := range f.Nodes {
-		if incomingCount[node.ID] == 0 {
-			roots = append(roots, node.ID)
-		}
+		w, _ := labelSize(node)
+		widest = max(widest, w)
 	}
-
-	// If no roots found (cycle graph), use first node
-	if len(roots) == 0 {
-		roots = []string{f.Nodes[0].ID}
+	for _, e := range f.Edges {
+		widest = max(widest, StringWidth(e.Label))
 	}
-
-	// BFS traversal to determine rendering order
-	var renderOrder []string
-	visited := make(map[string]bool)
-	queue := make([]string, len(roots))
-	copy(queue, roots)
-
-	for len(queue) > 0 {
-		nodeID := queue[0]
-		queue = queue[1:]
-
-		if visited[nodeID] {
-			continue
-		}
-		visited[nodeID] = true
-		renderOrder = append(renderOrder, nodeID)
-
-		// Add children to queue
-		for _, edge := range outgoing[nodeID] {
-			if !visited[edge.To] {
-				queue = append(queue, edge.To)
-			}
+	var titles func(subs []*Subgraph)
+	titles = func(subs []*Subgraph) {
+		for _, sub := range subs {
+			widest = max(widest, StringWidth(sub.Title))
+			titles(sub.Subgraphs)
 		}
 	}
-
-	// For horizontal, render nodes inline with edges between them
-	var output strings.Builder
-	rendered := make(map[string]bool)
-
-	for i, nodeID := range renderOrder {
-		if rendered[nodeID] {
-			continue
-		}
-		rendered[nodeID] = true
-
-		node := nodeMap[nodeID]
-
-		// Add spacing if not first node
-		if i > 0 {
-			output.WriteString("  ")
-		}
-
-		// Render the node inline
-		output.WriteString(renderNodeInline(node))
-
-		// If there's exactly one outgoing edge, show it inline
-		edges := outgoing[nodeID]
-		if len(edges) == 1 {
-			output.WriteString(" ")
-			output.WriteString(renderHorizontalEdge(edges[0]))
-		} else if len(edges) > 1 {
-			// Multiple edges - show first inline, others on new lines
-			output.WriteString(" ")
-			output.WriteString(renderHorizontalEdge(edges[0]))
-			for j := 1; j < len(edges); j++ {
-				output.WriteString("\n       ")
-				output.WriteString(BoxVertical)
-				output.WriteString(" ")
-				output.WriteString(renderHorizontalEdge(edges[j]))
-			}
+	titles(f.Subgraphs)
+	best := newSpacedFlowLayout(f.wrapLabels(minWrapWidth), layoutNodeGapCompact)
+	for lo, hi := minWrapWidth+1, widest-1; lo <= hi && best.width <= opts.MaxWidth; {
+		mid := (lo + hi) / 2
+		if l := newSpacedFlowLayout(f.wrapLabels(mid), layoutNodeGapCompact); l.width <= opts.MaxWidth {
+			best, lo = l, mid+1
+		} else {
+			hi = mid - 1
 		}
 	}
-
-	return output.String()
+	return best
 }
 
-func renderNode(node Node) string {
-	switch node.Shape {
-	case ShapeBox:
-		return renderBox(node.Label)
-	case ShapeRounded:
-		return renderRounded(node.Label)
-	case ShapeDiamond:
-		return renderDiamond(node.Label)
-	case ShapeCircle:
-		return renderCircle(node.Label)
-	default:
-		return renderBox(node.Label)
+// wrapLabels returns a copy of the flowchart with node labels wrapped to
+// width, and edge labels and subgraph titles truncated to it
+func (f *Flowchart) wrapLabels(width int) *Flowchart {
+	wrapped := *f
+	wrapped.Nodes = make([]Node, len(f.Nodes))
+	for i, node := range f.Nodes {
+		wrapped.Nodes[i] = wrapLabel(node, width)
 	}
+	wrapped.Edges = make([]Edge, len(f.Edges))
+	for i, e := range f.Edges {
+		e.Label = Truncate(e.Label, width)
+		wrapped.Edges[i] = e
+	}
+	wrapped.Subgraphs = truncateTitles(f.Subgraphs, width)
+	return &wrapped
 }
 
-func renderBox(label string) string {
-	width := len(label) + 4
-	var b strings.Builder
-
-	// Top border
-	b.WriteString(BoxTopLeft)
-	b.WriteString(strings.Repeat(BoxHorizontal, width-2))
-	b.WriteString(BoxTopRight)
-	b.WriteString("\n")
-
-	// Content
-	b.WriteString(BoxVertical)
-	b.WriteString(" ")
-	b.WriteString(label)
-	b.WriteString(" ")
-	b.WriteString(BoxVertical)
-	b.WriteString("\n")
-
-	// Bottom border
-	b.WriteString(BoxBottomLeft)
-	b.WriteString(strings.Repeat(BoxHorizontal, width-2))
-	b.WriteString(BoxBottomRight)
-
-	return b.String()
-}
-
-func renderRounded(label string) string {
-	width := len(label) + 4
-	var b strings.Builder
-
-	// Top border
-	b.WriteString("╭")
-	b.WriteString(strings.Repeat(BoxHorizontal, width-2))
-	b.WriteString("╮")
-	b.WriteString("\n")
-
-	// Content
-	b.WriteString(BoxVertical)
-	b.WriteString(" ")
-	b.WriteString(label)
-	b.WriteString(" ")
-	b.WriteString(BoxVertical)
-	b.WriteString("\n")
-
-	// Bottom border
-	b.WriteString("╰")
-	b.WriteString(strings.Repeat(BoxHorizontal, width-2))
-	b.WriteString("╯")
-
-	return b.String()
+// truncateTitles returns a copy of subgraphs and the subgraphs nested in them
+// with their titles truncated to width
+func truncateTitles(subs []*Subgraph, width int) []*Subgraph {
+	if subs == nil {
+		return nil
+	}
+	truncated := make([]*Subgraph, len(subs))
+	for i, sub := range subs {
+		copied := *sub
+		copied.Title = Truncate(sub.Title, width)
+		copied.Subgraphs = truncateTitles(sub.Subgraphs, width)
+		truncated[i] = &copied
+	}
+	return truncated
 }
 
-func renderDiamond(label string) string {
-	width := len(label) + 4
-	var b strings.Builder
-
-	// Top point
-	padding := width / 2
-	b.WriteString(strings.Repeat(" ", padding))
-	b.WriteString("◆")
-	b.WriteString("\n")
-
-	// Middle with label
-	b.WriteString("< ")
-	b.WriteString(label)
-	b.WriteString(" >")
-	b.WriteString("\n")
-
-	// Bottom point
-	b.WriteString(strings.Repeat(" ", padding))
-	b.WriteString("◆")
-
-	return b.String()
+// nodeSize returns the width and height of a node as drawn on a canvas. Each
+// label line after the first makes the node one row taller.
+func nodeSize(node Node) (int, int) {
+	labelWidth, rows := labelSize(node)
+	width, extra := labelWidth+4, rows-1
+	switch node.Shape {
+	case ShapeCircle:
+		return width, rows
+	case ShapeDoubleCircle:
+		return width + 2, rows
+	case ShapeCylinder:
+		return width, 4 + extra
+	case ShapeAsymmetric:
+		return width + 1, 3 + extra
+	case ShapeSubroutine, ShapeHexagon, ShapeParallelogram, ShapeParallelogramAlt, ShapeTrapezoid, ShapeTrapezoidAlt:
+		return width + 2, 3 + extra
+	}
+	return width, 3 + extra
 }
 
-func renderCircle(label string) string {
-	var b strings.Builder
-
-	// Simple circle representation
-	b.WriteString("( ")
-	b.WriteString(label)
-	b.WriteString(" )")
-
-	return b.String()
-}
+// drawNode draws a node in the given style with its top-left corner at (x, y)
+func drawNode(c *Canvas, x, y int, node Node, ns NodeStyle) {
+	width, height := nodeSize(node)
+	right, bottom := x+width-1, y+height-1
+	labelWidth, rows := labelSize(node)
+	lines := labelLines(node)
 
-func renderNodeInline(node Node) string {
 	switch node.Shape {
-	case ShapeBox:
-		return fmt.Sprintf("[%s]", node.Label)
 	case ShapeRounded:
-		return fmt.Sprintf("(%s)", node.Label)
+		c.Fill(x+1, y+1, width-2, height-2, " ", ns.Fill)
+		c.RoundedBox(x, y, width, height, ns.Border)
+		drawLabel(c, x+2, y+1, labelWidth, lines, ns.text())
 	case ShapeDiamond:
-		return fmt.Sprintf("<%s>", node.Label)
+		c.Set(x+width/2, y, "◆", ns.Border)
+		drawSides(c, x, right, y+1, sideChars(rows, "╱", "<", "╲"), sideChars(rows, "╲", ">", "╱"), ns)
+		drawLabel(c, x+2, y+1, labelWidth, lines, ns.text())
+		c.Set(x+width/2, bottom, "◆", ns.Border)
 	case ShapeCircle:
-		return fmt.Sprintf("((%s))", node.Label)
+		lefts, rights := roundSides(rows)
+		drawSides(c, x, right, y, lefts, rights, ns)
+		drawLabel(c, x+2, y, labelWidth, lines, ns.text())
+	case ShapeDoubleCircle:
+		lefts, rights := roundSides(rows)
+		drawSides(c, x, right, y, lefts, rights, ns)
+		drawSides(c, x+1, right-1, y, lefts, rights, ns)
+		drawLabel(c, x+3, y, labelWidth, lines, ns.text())
+	case ShapeStadium:
+		c.RoundedBox(x, y, width, height, ns.Border)
+		lefts, rights := roundSides(rows)
+		drawSides(c, x, right, y+1, lefts, rights, ns)
+		drawLabel(c, x+2, y+1, labelWidth, lines, ns.text())
+	case ShapeSubroutine:
+		c.Fill(x+1, y+1, width-2, rows, " ", ns.Fill)
+		c.Box(x, y, width, height, ns.Border)
+		c.Path(ns.Border, Point{x + 1, y}, Point{x + 1, bottom})
+		c.Path(ns.Border, Point{right - 1, y}, Point{right - 1, bottom})
+		drawLabel(c, x+3, y+1, labelWidth, lines, ns.text())
+	case ShapeCylinder:
+		// A rounded box with a line below the lid
+		c.Fill(x+1, y+1, width-2, height-2, " ", ns.Fill)
+		c.RoundedBox(x, y, width, height, ns.Border)
+		c.Path(ns.Border, Point{x, y + 1}, Point{right, y + 1})
+		drawLabel(c, x+2, y+2, labelWidth, lines, ns.text())
+	case ShapeAsymmetric:
+		c.Fill(x+1, y+1, width-2, rows, " ", ns.Fill)
+		c.Box(x, y, width, height, ns.Border)
+		c.Set(x, y, "╲", ns.Border)
+		c.Set(x, bottom, "╱", ns.Border)
+		for i, notch := range sideChars(rows, "╲", ">", "╱") {
+			c.Set(x, y+1+i, " ", ns.Fill)
+			c.Set(x+1, y+1+i, notch, ns.Border)
+		}
+		drawLabel(c, x+3, y+1, labelWidth, lines, ns.text())
+	case ShapeHexagon:
+		c.Fill(x+1, y+1, width-2, rows, " ", ns.Fill)
+		c.HLine(x+2, right-2, y, ns.Border)
+		c.HLine(x+2, right-2, bottom, ns.Border)
+		c.Set(x+1, y, "╱", ns.Border)
+		c.Set(right-1, y, "╲", ns.Border)
+		c.Set(x+1, bottom, "╲", ns.Border)
+		c.Set(right-1, bottom, "╱", ns.Border)
+		drawSides(c, x, right, y+1, sideChars(rows, "╱", "<", "╲"), sideChars(rows, "╲", ">", "╱"), ns)
+		drawLabel(c, x+3, y+1, labelWidth, lines, ns.text())
+	case ShapeParallelogram:
+		drawSlanted(c, x, y, width, rows, "╱", "╱", ns)
+		drawLabel(c, x+3, y+1, labelWidth, lines, ns.text())
+	case ShapeParallelogramAlt:
+		drawSlanted(c, x, y, width, rows, "╲", "╲", ns)
+		drawLabel(c, x+3, y+1, labelWidth, lines, ns.text())
+	case ShapeTrapezoid:
+		drawSlanted(c, x, y, width, rows, "╱", "╲", ns)
+		drawLabel(c, x+3, y+1, labelWidth, lines, ns.text())
+	case ShapeTrapezoidAlt:
+		drawSlanted(c, x, y, width, rows, "╲", "╱", ns)
+		drawLabel(c, x+3, y+1, labelWidth, lines, ns.text())
 	default:
-		return fmt.Sprintf("[%s]", node.Label)
+		c.Fill(x+1, y+1, width-2, height-2, " ", ns.Fill)
+		c.Box(x, y, width, height, ns.Border)
+		drawLabel(c, x+2, y+1, labelWidth, lines, ns.text())
 	}
 }
 
-func renderVerticalEdge(edge Edge) string {
-	var b strings.Builder
-
-	if edge.Label != "" {
-		// Edge with label
-		b.WriteString("    ")
-		b.WriteString(BoxVertical)
-		b.WriteString(" ")
-		b.WriteString(edge.Label)
-		b.WriteString("\n")
+// drawSides draws the left and right sides of a shape from row y down, in
+// columns x1 and x2, and clears the cells between them
+func drawSides(c *Canvas, x1, x2, y int, left, right []string, ns NodeStyle) {
+	c.Fill(x1+1, y, x2-x1-1, len(left), " ", ns.Fill)
+	for i := range left {
+		c.Set(x1, y+i, left[i], ns.Border)
+		c.Set(x2, y+i, right[i], ns.Border)
 	}
+}
 
-	b.WriteString("    ")
-	b.WriteString(ArrowDown)
-
-	return b.String()
+// roundSides returns the sides of a round shape n rows tall: parentheses for
+// a single row, and bracket pieces (⎛ ⎜ ⎝) for more
+func roundSides(n int) ([]string, []string) {
+	if n == 1 {
+		return []string{"("}, []string{")"}
+	}
+	left, right := make([]string, n), make([]string, n)
+	for i := range left {
+		left[i], right[i] = "⎜", "⎟"
+	}
+	left[0], right[0] = "⎛", "⎞"
+	left[n-1], right[n-1] = "⎝", "⎠"
+	return left, right
 }
 
-func renderVerticalEdgeWithTarget(edge Edge, targetNode Node) string {
-	var b strings.Builder
-
-	if edge.Label != "" {
-		// Edge with label - just show label, not target (target node renders separately)
-		b.WriteString("    ")
-		b.WriteString(BoxVertical)
-		b.WriteString(" ")
-		b.WriteString(edge.Label)
-		b.WriteString("\n")
-		b.WriteString("    ")
-		b.WriteString(ArrowDown)
+// drawSlanted draws a node whose left and right sides are ╱ or ╲, with rows
+// of label between its top and bottom lines. The top and bottom lines are
+// inset on the side each slant leans away from.
+func drawSlanted(c *Canvas, x, y, width, rows int, left, right string, ns NodeStyle) {
+	last := x + width - 1
+	top, bottom := [2]int{x, last}, [2]int{x, last}
+	if left == "╱" {
+		top[0] += 2
 	} else {
-		// No label - just show arrow
-		b.WriteString("    ")
-		b.WriteString(ArrowDown)
+		bottom[0] += 2
+	}
+	if right == "╱" {
+		bottom[1] -= 2
+	} else {
+		top[1] -= 2
 	}
 
-	return b.String()
+	c.HLine(top[0], top[1], y, ns.Border)
+	c.HLine(bottom[0], bottom[1], y+rows+1, ns.Border)
+	for i := 0; i < rows; i++ {
+		c.Fill(x+2, y+1+i, width-4, 1, " ", ns.Fill)
+		c.Set(x+1, y+1+i, left, ns.Border)
+		c.Set(last-1, y+1+i, right, ns.Border)
+	}
 }
 
-func renderHorizontalEdge(edge Edge) string {
-	if edge.Label != "" {
-		return fmt.Sprintf("%s[%s]%s", BoxHorizontal, edge.Label, ArrowRight)
-	}
-	return fmt.Sprintf("%s%s%s", BoxHorizontal, BoxHorizontal, ArrowRight)
+// renderNode renders a single node on its own
+func renderNode(node Node) string {
+	width, height := nodeSize(node)
+	c := NewCanvas(width, height)
+	drawNode(c, 0, 0, node, NodeStyle{})
+	return c.String()
 }
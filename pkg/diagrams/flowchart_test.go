@@ -75,6 +75,25 @@ func TestFlowchart_AddEdge(t *testing.T) {
 	}
 }
 
+func TestFlowchart_AddSubgraph(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	region := flow.AddSubgraph("region", "EU West").Add("lb")
+	region.AddSubgraph("db", "Database").Add("primary", "replica").SetDirection(LeftToRight)
+
+	if len(flow.Subgraphs) != 1 || flow.Subgraphs[0] != region {
+		t.Fatalf("Expected 1 top-level subgraph, got %d", len(flow.Subgraphs))
+	}
+
+	if region.Title != "EU West" || len(region.Nodes) != 1 || region.Direction != nil {
+		t.Errorf("Unexpected subgraph %+v", region)
+	}
+
+	nested := region.Subgraphs[0]
+	if len(nested.Nodes) != 2 || nested.Direction == nil || *nested.Direction != LeftToRight {
+		t.Errorf("Unexpected nested subgraph %+v", nested)
+	}
+}
+
 func TestFlowchart_RenderVertical(t *testing.T) {
 	flow := NewFlowchart(TopToBottom)
 
@@ -121,13 +140,14 @@ func TestFlowchart_RenderHorizontal(t *testing.T) {
 		t.Error("Render returned empty string")
 	}
 
-	// Should contain node labels in inline format
-	if !strings.Contains(output, "[A]") {
-		t.Error("Expected '[A]' in horizontal flowchart")
+	// Should contain both node boxes on the same rows
+	lines := strings.Split(output, "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %d:\n%s", len(lines), output)
 	}
 
-	if !strings.Contains(output, "[B]") {
-		t.Error("Expected '[B]' in horizontal flowchart")
+	if !strings.Contains(lines[1], "│ A ") || !strings.Contains(lines[1], "│ B │") {
+		t.Errorf("Expected boxes A and B side by side, got:\n%s", output)
 	}
 
 	// Should contain arrow
@@ -146,6 +166,16 @@ func TestRenderNode_AllShapes(t *testing.T) {
 		{ShapeRounded, "Test", "Test"},
 		{ShapeDiamond, "Test", "Test"},
 		{ShapeCircle, "Test", "Test"},
+		{ShapeStadium, "Test", "( Test )"},
+		{ShapeSubroutine, "Test", "││ Test ││"},
+		{ShapeCylinder, "Test", "├──────┤"},
+		{ShapeAsymmetric, "Test", " > Test │"},
+		{ShapeHexagon, "Test", "<  Test  >"},
+		{ShapeParallelogram, "Test", "╱ Test ╱"},
+		{ShapeParallelogramAlt, "Test", "╲ Test ╲"},
+		{ShapeTrapezoid, "Test", "╱ Test ╲"},
+		{ShapeTrapezoidAlt, "Test", "╲ Test ╱"},
+		{ShapeDoubleCircle, "Test", "(( Test ))"},
 	}
 
 	for _, tt := range tests {
@@ -162,8 +192,43 @@ func TestRenderNode_AllShapes(t *testing.T) {
 	}
 }
 
+func TestRenderNode_Cylinder(t *testing.T) {
+	output := renderNode(Node{ID: "db", Label: "DB", Shape: ShapeCylinder})
+
+	expected := "╭────╮\n├────┤\n│ DB │\n╰────╯"
+	if output != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, output)
+	}
+}
+
+func TestRenderNode_MultiLine(t *testing.T) {
+	tests := []struct {
+		node     Node
+		expected string
+	}{
+		{Node{Label: "Line one\nline two", Shape: ShapeBox}, "┌──────────┐\n│ Line one │\n│ line two │\n└──────────┘"},
+		{Node{Label: "Go\nor\nstop", Shape: ShapeDiamond}, "    ◆\n╱  Go  ╲\n<  or  >\n╲ stop ╱\n    ◆"},
+		{Node{Label: "a\nb", Shape: ShapeCircle}, "⎛ a ⎞\n⎝ b ⎠"},
+	}
+
+	for _, tt := range tests {
+		if output := renderNode(tt.node); output != tt.expected {
+			t.Errorf("Expected:\n%s\ngot:\n%s", tt.expected, output)
+		}
+	}
+}
+
+func TestRenderNode_Markdown(t *testing.T) {
+	output := renderNode(Node{Label: "**Bold** *it*", Shape: ShapeBox, Markdown: true})
+
+	expected := "┌─────────┐\n│ \x1b[1mBold\x1b[0m \x1b[3mit\x1b[0m │\n└─────────┘"
+	if output != expected {
+		t.Errorf("Expected %q, got %q", expected, output)
+	}
+}
+
 func TestRenderBox(t *testing.T) {
-	output := renderBox("Hello")
+	output := renderNode(Node{ID: "hello", Label: "Hello", Shape: ShapeBox})
 
 	// Should have 3 lines (top, content, bottom)
 	lines := strings.Split(output, "\n")
@@ -0,0 +1,284 @@
+package diagrams
+
+import "strings"
+
+// FragmentType is the kind of a combined fragment of a sequence diagram
+type FragmentType int
+
+const (
+	// FragmentLoop repeats its messages
+	FragmentLoop FragmentType = iota
+	// FragmentAlt chooses one of its sections, separated by else
+	FragmentAlt
+	// FragmentOpt happens only if its condition holds
+	FragmentOpt
+	// FragmentPar runs its sections, separated by and, in parallel
+	FragmentPar
+	// FragmentCritical must happen atomically, with options for its failures
+	FragmentCritical
+	// FragmentBreak ends the enclosing sequence when it happens
+	FragmentBreak
+	// FragmentRect highlights its messages, optionally with a background colour
+	FragmentRect
+)
+
+// fragmentKeywords are the Mermaid keywords that open each type of fragment
+var fragmentKeywords = map[FragmentType]string{
+	FragmentLoop:     "loop",
+	FragmentAlt:      "alt",
+	FragmentOpt:      "opt",
+	FragmentPar:      "par",
+	FragmentCritical: "critical",
+	FragmentBreak:    "break",
+	FragmentRect:     "rect",
+}
+
+// sectionKeywords are the Mermaid keywords that separate the sections of the
+// fragment types that have several
+var sectionKeywords = map[FragmentType]string{
+	FragmentAlt:      "else",
+	FragmentPar:      "and",
+	FragmentCritical: "option",
+}
+
+// Fragment groups messages and notes of a sequence diagram in a labelled
+// frame, such as a loop or the branches of an alt. Positions count the
+// messages and notes drawn before a place in the diagram, as Note.At does.
+type Fragment struct {
+	Type     FragmentType
+	Sections []FragmentSection // The first section, then one per else, and or option
+	End      int               // Position of the first message or note after the fragment
+	Color    string            // ANSI background of a rect, such as RGB(230, 240, 255).Bg() (optional)
+}
+
+// FragmentSection is a part of a fragment, with its own label and the
+// fragments nested in it
+type FragmentSection struct {
+	Label     string
+	Start     int // Position of the section's first message or note
+	Fragments []*Fragment
+}
+
+// position returns the number of messages and notes added so far
+func (s *SequenceDiagram) position() int {
+	return len(s.Messages) + len(s.Notes)
+}
+
+// StartFragment opens a fragment, labelled with its condition, that holds the
+// messages and notes added until EndFragment. Fragments started before it
+// is ended are nested in it.
+func (s *SequenceDiagram) StartFragment(typ FragmentType, label string) *SequenceDiagram {
+	f := &Fragment{Type: typ, Sections: []FragmentSection{{Label: label, Start: s.position()}}}
+	if n := len(s.open); n > 0 {
+		parent := s.open[n-1]
+		section := &parent.Sections[len(parent.Sections)-1]
+		section.Fragments = append(section.Fragments, f)
+	} else {
+		s.Fragments = append(s.Fragments, f)
+	}
+	s.open = append(s.open, f)
+	return s
+}
+
+// StartRect opens a rect fragment with a background colour, such as
+// RGB(230, 240, 255).Bg(), or none if color is empty
+func (s *SequenceDiagram) StartRect(color string) *SequenceDiagram {
+	s.StartFragment(FragmentRect, "")
+	s.open[len(s.open)-1].Color = color
+	return s
+}
+
+// AddSection starts a new section of the innermost open fragment, such as the
+// else branch of an alt or another branch of a par. Only alt, par and critical
+// fragments have sections; for the other types it does nothing.
+func (s *SequenceDiagram) AddSection(label string) *SequenceDiagram {
+	if n := len(s.open); n > 0 {
+		f := s.open[n-1]
+		if _, ok := sectionKeywords[f.Type]; ok {
+			f.Sections = append(f.Sections, FragmentSection{Label: label, Start: s.position()})
+		}
+	}
+	return s
+}
+
+// EndFragment closes the innermost open fragment
+func (s *SequenceDiagram) EndFragment() *SequenceDiagram {
+	if n := len(s.open); n > 0 {
+		s.open[n-1].End = s.position()
+		s.open = s.open[:n-1]
+	}
+	return s
+}
+
+// start returns the position of the fragment's first message or note
+func (f *Fragment) start() int {
+	return f.Sections[0].Start
+}
+
+// fragmentEnd returns the position after a fragment's last message or note; a
+// fragment that was never ended runs to the end of the diagram
+func (s *SequenceDiagram) fragmentEnd(f *Fragment, total int) int {
+	for _, open := range s.open {
+		if open == f {
+			return total
+		}
+	}
+	if f.End < f.Sections[len(f.Sections)-1].Start {
+		return total
+	}
+	return min(f.End, total)
+}
+
+// seqStep is a step of a sequence diagram from top to bottom: a message or
+// note, or the top edge (section 0), a separator or the bottom edge (section
+// -1) of a fragment
+type seqStep struct {
+	item     seqItem
+	fragment *Fragment
+	section  int
+}
+
+// rows returns the rows a step takes; fragment edges have a lifeline row
+// above them, like messages
+func (st seqStep) rows() int {
+	if st.fragment != nil {
+		return 2
+	}
+	return st.item.rows()
+}
+
+// steps returns the messages and notes with the edges of the fragments
+// around them, in order
+func (s *SequenceDiagram) steps() []seqStep {
+	items := s.items()
+	var steps []seqStep
+	next := 0
+	flush := func(to int) {
+		for ; next < to && next < len(items); next++ {
+			steps = append(steps, seqStep{item: items[next]})
+		}
+	}
+
+	var walk func(fragments []*Fragment)
+	walk = func(fragments []*Fragment) {
+		for _, f := range fragments {
+			for i, section := range f.Sections {
+				flush(section.Start)
+				steps = append(steps, seqStep{fragment: f, section: i})
+				walk(section.Fragments)
+			}
+			flush(s.fragmentEnd(f, len(items)))
+			steps = append(steps, seqStep{fragment: f, section: -1})
+		}
+	}
+	walk(s.Fragments)
+	flush(len(items))
+	return steps
+}
+
+// fragmentBoxes returns the left and right columns of each fragment's frame.
+// A frame reaches three columns past the outermost lifelines of its messages
+// and notes, or of every actor if it has none, and encloses self-call labels,
+// notes and nested frames with a column to spare. It is wide enough for its
+// title and separators, and kept within MaxWidth.
+func (s *SequenceDiagram) fragmentBoxes(l *seqLayout, items []seqItem, index map[string]int) map[*Fragment][2]int {
+	boxes := make(map[*Fragment][2]int)
+	var walk func(fragments []*Fragment)
+	walk = func(fragments []*Fragment) {
+		for _, f := range fragments {
+			end := s.fragmentEnd(f, len(items))
+			inside := items[min(f.start(), end):end]
+			first, last := len(s.Actors), -1
+			for _, it := range inside {
+				for _, id := range it.actors() {
+					if i, ok := index[id]; ok {
+						first, last = min(first, i), max(last, i)
+					}
+				}
+			}
+			if last < 0 {
+				first, last = 0, len(s.Actors)-1
+			}
+
+			left, right := l.center(first)-3, l.center(last)+3
+			for _, it := range inside {
+				if msg := it.message; msg != nil && msg.IsSelf {
+					if i, ok := index[msg.From]; ok {
+						right = max(right, l.center(i)+StringWidth(msg.Label)+5)
+					}
+				} else if it.note != nil {
+					if x, width, ok := it.note.box(l, index); ok {
+						left, right = min(left, x-2), max(right, x+width+1)
+					}
+				}
+			}
+			for _, section := range f.Sections {
+				walk(section.Fragments)
+				for _, child := range section.Fragments {
+					left, right = min(left, boxes[child][0]-2), max(right, boxes[child][1]+2)
+				}
+			}
+			for i := range f.Sections {
+				right = max(right, left+StringWidth(f.text(i))+5)
+			}
+			if l.maxWidth > 0 {
+				left, right = max(left, 0), min(right, l.maxWidth-1)
+			}
+			boxes[f] = [2]int{left, right}
+		}
+	}
+	walk(s.Fragments)
+	return boxes
+}
+
+// drawFragment draws a fragment's frame from column x1 to x2 and row top to
+// bottom, with its title, and a dashed separator on each row of separators.
+// The area of a rect with a colour is tinted.
+func drawFragment(c *Canvas, f *Fragment, x1, x2, top, bottom int, separators []int, theme *Theme) {
+	style := theme.Frame
+	if f.Color != "" {
+		style.Bg = f.Color
+		for y := top; y <= bottom; y++ {
+			for x := x1; x <= x2; x++ {
+				if c.writable(x, y) && c.cells[y][x].Style.Bg == "" {
+					c.cells[y][x].Style.Bg = f.Color
+				}
+			}
+		}
+	}
+
+	c.Box(x1, top, x2-x1+1, bottom-top+1, style)
+	drawFragmentText(c, x1, x2, top, f.text(0), fragmentKeywords[f.Type], theme)
+	for i, y := range separators {
+		c.LinePath(LineDashed, style, Point{x1, y}, Point{x2, y})
+		drawFragmentText(c, x1, x2, y, f.text(i+1), "", theme)
+	}
+}
+
+// text returns the text on a section's edge or separator: the fragment's
+// keyword on its top edge, and the section's label in brackets. A rect has
+// none.
+func (f *Fragment) text(section int) string {
+	if f.Type == FragmentRect {
+		return ""
+	}
+	text := ""
+	if section == 0 {
+		text = fragmentKeywords[f.Type]
+	}
+	if label := f.Sections[section].Label; label != "" {
+		text += " [" + label + "]"
+	}
+	return strings.TrimSpace(text)
+}
+
+// drawFragmentText writes text on an edge or separator from column x1 to
+// x2, shortened to fit, with its leading keyword in the theme's Title style
+func drawFragmentText(c *Canvas, x1, x2, y int, text, keyword string, theme *Theme) {
+	text = Truncate(text, x2-x1-5)
+	if text == "" {
+		return
+	}
+	c.Text(x1+2, y, " "+text+" ", theme.EdgeLabel)
+	c.Text(x1+3, y, Truncate(keyword, StringWidth(text)), theme.Title)
+}
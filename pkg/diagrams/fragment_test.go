@@ -0,0 +1,177 @@
+package diagrams
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSequenceDiagram_StartFragment(t *testing.T) {
+	seq := NewSequenceDiagram().AddActor("A", "Alice").AddActor("B", "Bob").
+		AddMessage("A", "B", "login", MessageSync).
+		StartFragment(FragmentAlt, "valid").
+		AddMessage("B", "A", "token", MessageReturn).
+		StartFragment(FragmentLoop, "refresh").
+		AddMessage("A", "B", "renew", MessageSync).
+		EndFragment().
+		AddSection("invalid").
+		AddNote(NoteOver, "locked", "A", "B").
+		EndFragment().
+		AddMessage("A", "B", "logout", MessageSync)
+
+	if len(seq.Fragments) != 1 {
+		t.Fatalf("Expected 1 top-level fragment, got %d", len(seq.Fragments))
+	}
+	alt := seq.Fragments[0]
+	if len(alt.Sections) != 2 || alt.Sections[0].Start != 1 || alt.Sections[1].Start != 3 || alt.End != 4 {
+		t.Errorf("Expected sections at 1 and 3 ending at 4, got %+v", alt)
+	}
+	if inner := alt.Sections[0].Fragments; len(inner) != 1 || inner[0].Type != FragmentLoop || inner[0].start() != 2 || inner[0].End != 3 {
+		t.Errorf("Expected the loop nested in the first section, got %+v", inner)
+	}
+
+	var order []string
+	for _, st := range seq.steps() {
+		switch {
+		case st.fragment != nil:
+			order = append(order, fragmentKeywords[st.fragment.Type]+string(rune('0'+st.section+1)))
+		case st.item.note != nil:
+			order = append(order, st.item.note.Text)
+		default:
+			order = append(order, st.item.message.Label)
+		}
+	}
+	want := "login,alt1,token,loop1,renew,loop0,alt2,locked,alt0,logout"
+	if got := strings.Join(order, ","); got != want {
+		t.Errorf("Expected steps %s, got %s", want, got)
+	}
+
+	open := NewSequenceDiagram().AddActor("A", "Alice").
+		StartFragment(FragmentOpt, "").
+		AddMessage("A", "A", "unclosed", MessageSync)
+	if steps := open.steps(); len(steps) != 3 || steps[1].item.message == nil || steps[2].section != -1 {
+		t.Errorf("Expected a fragment that is never ended to run to the end, got %+v", steps)
+	}
+}
+
+func TestSequenceDiagram_Render_Fragments(t *testing.T) {
+	seq := NewSequenceDiagram().AddActor("A", "Alice").AddActor("B", "Bob").
+		StartFragment(FragmentAlt, "cached").
+		AddMessage("A", "A", "read", MessageSync).
+		AddSection("miss").
+		StartFragment(FragmentLoop, "").
+		AddMessage("A", "B", "fetch", MessageSync).
+		EndFragment().
+		EndFragment().
+		AddNote(NoteOver, "done", "A", "B")
+
+	expected := strings.Join([]string{
+		"   Alice              Bob",
+		"┌─────┬────┐      ┌─────┬────┐",
+		"      │                 │",
+		" ┌─ alt [cached] ───────┼────┐",
+		" │    │                 │    │",
+		" │    │→[read]          │    │",
+		" │    │                 │    │",
+		" ├┄ [miss] ┄┄┄┄┄┄┄┄┄┄┄┄┄┼┄┄┄┄┤",
+		" │    │                 │    │",
+		" │ ┌─ loop ─────────────┼──┐ │",
+		" │ │  │                 │  │ │",
+		" │ │  ├───── fetch ────→│  │ │",
+		" │ │  │                 │  │ │",
+		" │ └──┼─────────────────┼──┘ │",
+		" │    │                 │    │",
+		" └────┼─────────────────┼────┘",
+		"      │                 │",
+		"    ┌─────────────────────┐",
+		"    │        done         │",
+		"    └─────────────────────┘",
+		"      │                 │",
+		"└─────┴────┘      └─────┴────┘",
+	}, "\n")
+	if got := seq.Render(); got != expected {
+		t.Errorf("Expected:\n%s\n\nGot:\n%s", expected, got)
+	}
+
+	opts := RenderOptions{MaxWidth: 20}
+	if width, _ := seq.Size(opts); width > 20 {
+		t.Errorf("Expected at most 20 columns, got %d", width)
+	}
+
+	// A note keeps a column clear of the frame on either side
+	noted := NewSequenceDiagram().AddActor("A", "Alice").AddActor("B", "Bob").
+		StartFragment(FragmentOpt, "").
+		AddNote(NoteOver, "wait", "B").
+		EndFragment()
+	if output := noted.Render(); !strings.Contains(output, "│ │ wait │ │") {
+		t.Errorf("Expected a space between the note and the frame:\n%s", output)
+	}
+}
+
+func TestSequenceDiagram_Render_RectColor(t *testing.T) {
+	seq := NewSequenceDiagram().AddActor("A", "Alice").AddActor("B", "Bob").
+		StartRect(RGB(0, 0, 255).Bg()).
+		AddMessage("A", "B", "call", MessageSync).
+		EndFragment()
+
+	c := seq.canvas(RenderOptions{})
+	if bg := c.cells[4][4].Style.Bg; bg != RGB(0, 0, 255).Bg() {
+		t.Errorf("Expected the rect tinted, got background %q", bg)
+	}
+	if bg := c.cells[2][4].Style.Bg; bg != "" {
+		t.Errorf("Expected no tint outside the rect, got background %q", bg)
+	}
+}
+
+func TestParseMermaidSequence_Fragments(t *testing.T) {
+	seq, err := ParseMermaidSequence(`sequenceDiagram
+    loop Every minute
+        A->>B: ping
+        alt healthy
+            B-->>A: pong
+        else down
+            Note over B: restart
+        end
+    end
+    Par fan out
+        A->>B: one
+    and
+        A->>C: two
+    end
+    rect rgba(0, 0, 255, 0.1)
+        End->>A: an actor named End
+    end`)
+	if err != nil {
+		t.Fatalf("ParseMermaidSequence failed: %v", err)
+	}
+
+	if len(seq.Fragments) != 3 {
+		t.Fatalf("Expected 3 fragments, got %d", len(seq.Fragments))
+	}
+	loop, par, rect := seq.Fragments[0], seq.Fragments[1], seq.Fragments[2]
+	if loop.Type != FragmentLoop || loop.Sections[0].Label != "Every minute" || loop.End != 3 {
+		t.Errorf("Unexpected loop %+v", loop)
+	}
+	alt := loop.Sections[0].Fragments
+	if len(alt) != 1 || alt[0].Type != FragmentAlt || len(alt[0].Sections) != 2 || alt[0].Sections[1].Label != "down" || alt[0].Sections[1].Start != 2 {
+		t.Errorf("Expected an alt with an else nested in the loop, got %+v", alt)
+	}
+	if par.Type != FragmentPar || len(par.Sections) != 2 || par.Sections[1].Label != "" || par.start() != 3 || par.End != 5 {
+		t.Errorf("Unexpected par %+v", par)
+	}
+	if rect.Type != FragmentRect || rect.Color != RGB(0, 0, 255).Bg() || rect.End != 6 {
+		t.Errorf("Unexpected rect %+v", rect)
+	}
+
+	errors := map[string]string{
+		"sequenceDiagram\n    A->>B: x\n    end":                  `line 3, column 5: "end" without an open block`,
+		"sequenceDiagram\n    loop x\n    else\n    end":          `line 3, column 5: "else" without an open alt`,
+		"sequenceDiagram\n    alt x\n    and y\n    end":          `line 3, column 5: "and" without an open par`,
+		"sequenceDiagram\n    critical x\n        A->>B: x\n    ": `line 2, column 5: critical is never closed, expected "end"`,
+	}
+	for src, want := range errors {
+		_, err := ParseMermaidSequence(src)
+		if err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("Expected error %q for %q, got %v", want, src, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package diagrams
+
+import "io"
+
+// Frame draws a border around a diagram, with an optional title in the top
+// edge and footer in the bottom edge, so that diagrams of every type can be
+// labelled the same way. The frame widens to fit its title and footer.
+//
+// Within MaxWidth and MaxHeight, the diagram is rendered to fit inside the
+// border and the title and footer are shortened.
+type Frame struct {
+	Diagram     Diagram
+	Title       string
+	Footer      string
+	TitleAlign  Align // Position of the title along the top edge
+	FooterAlign Align // Position of the footer along the bottom edge
+	Padding     int   // Blank columns between the border and the diagram
+}
+
+// NewFrame creates a frame around a diagram, with 1 column of padding
+func NewFrame(d Diagram) *Frame {
+	return &Frame{Diagram: d, Padding: 1}
+}
+
+// SetTitle sets the title shown in the top edge and its position
+func (f *Frame) SetTitle(title string, align Align) *Frame {
+	f.Title, f.TitleAlign = title, align
+	return f
+}
+
+// SetFooter sets the footer shown in the bottom edge and its position
+func (f *Frame) SetFooter(footer string, align Align) *Frame {
+	f.Footer, f.FooterAlign = footer, align
+	return f
+}
+
+// SetPadding sets the blank columns between the border and the diagram
+func (f *Frame) SetPadding(padding int) *Frame {
+	f.Padding = padding
+	return f
+}
+
+// Render renders the framed diagram with the default options
+func (f *Frame) Render() string {
+	return f.RenderWithOptions(RenderOptions{})
+}
+
+// RenderWithOptions renders the framed diagram in the options' theme, charset
+// and colour profile. The border is drawn in the theme's Frame style, the
+// title in its Title style and the footer in its Text style.
+func (f *Frame) RenderWithOptions(opts RenderOptions) string {
+	return f.canvas(opts).render(opts)
+}
+
+// RenderTo writes the framed diagram to w as RenderWithOptions renders it,
+// one row at a time, and returns the number of bytes written
+func (f *Frame) RenderTo(w io.Writer, opts RenderOptions) (int64, error) {
+	return f.canvas(opts).writeTo(w, opts)
+}
+
+// WriteTo writes the framed diagram to w as Render renders it, implementing
+// io.WriterTo
+func (f *Frame) WriteTo(w io.Writer) (int64, error) {
+	return f.RenderTo(w, RenderOptions{})
+}
+
+// Size returns the columns and rows the framed diagram takes when rendered
+// with opts
+func (f *Frame) Size(opts RenderOptions) (int, int) {
+	width, height, _ := f.layout(opts)
+	return opts.clamp(width, height)
+}
+
+// layout returns the frame's size and the options the diagram is rendered
+// with to fit inside it
+func (f *Frame) layout(opts RenderOptions) (int, int, RenderOptions) {
+	// Border and padding on each side
+	extra := 2 + 2*f.Padding
+
+	inner := opts
+	inner.Charset, inner.ColorProfile = nil, ProfileTrueColor
+	if opts.MaxWidth > 0 {
+		inner.MaxWidth = max(opts.MaxWidth-extra, 1)
+	}
+	if opts.MaxHeight > 0 {
+		inner.MaxHeight = max(opts.MaxHeight-2, 1)
+	}
+	width, height := diagramSize(f.Diagram, inner)
+
+	// A corner, a line and a space on each side of the title and footer
+	width = max(width+extra, max(StringWidth(f.Title), StringWidth(f.Footer))+6)
+	if opts.MaxWidth > 0 {
+		width = min(width, opts.MaxWidth)
+	}
+	return width, height + 2, inner
+}
+
+// canvas draws the border, then the diagram inside it
+func (f *Frame) canvas(opts RenderOptions) *Canvas {
+	width, height, inner := f.layout(opts)
+	theme := opts.theme()
+	c := NewCanvas(width, height)
+
+	c.Box(0, 0, width, height, theme.Frame)
+	drawEdgeText(c, 0, f.Title, f.TitleAlign, theme.Title)
+	drawEdgeText(c, height-1, f.Footer, f.FooterAlign, theme.Text)
+
+	area := rect{1 + f.Padding, 1, width - 2 - 2*f.Padding, height - 2}
+	drawDiagram(c, area, f.Diagram, inner, AlignStart, AlignStart)
+	return c
+}
+
+// drawEdgeText draws text on the top or bottom edge of a frame, between a
+// corner and a line at each end, shortened to fit
+func drawEdgeText(c *Canvas, y int, text string, align Align, style Style) {
+	if text == "" || c.Width() < 7 {
+		return
+	}
+	text = " " + Truncate(text, c.Width()-6) + " "
+	space := c.Width() - 4
+	c.Text(2+align.offset(space, StringWidth(text)), y, text, style)
+}
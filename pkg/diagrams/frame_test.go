@@ -0,0 +1,63 @@
+package diagrams
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFrame_Render(t *testing.T) {
+	frame := NewFrame(block("body", "text")).
+		SetTitle("Title", AlignStart).
+		SetFooter("end", AlignEnd)
+
+	expected := strings.Join([]string{
+		"┌─ Title ─┐",
+		"│ body    │",
+		"│ text    │",
+		"└─── end ─┘",
+	}, "\n")
+	if got := frame.Render(); got != expected {
+		t.Errorf("Expected:\n%s\n\nGot:\n%s", expected, got)
+	}
+}
+
+func TestFrame_TitleAlign(t *testing.T) {
+	tests := []struct {
+		align Align
+		top   string
+	}{
+		{AlignStart, "┌─ ab ─────────┐"},
+		{AlignCenter, "┌───── ab ─────┐"},
+		{AlignEnd, "┌───────── ab ─┐"},
+	}
+
+	for _, tt := range tests {
+		frame := NewFrame(block("fourteen chars")).SetPadding(0).SetTitle("ab", tt.align)
+		if got := strings.Split(frame.Render(), "\n")[0]; got != tt.top {
+			t.Errorf("Align %d: expected %q, got %q", tt.align, tt.top, got)
+		}
+	}
+}
+
+func TestFrame_RenderWithOptions(t *testing.T) {
+	chart := NewBarChart("", Horizontal).SetWidth(40).AddBar("a", 1)
+	frame := NewFrame(chart).SetTitle("A long chart title", AlignCenter)
+
+	output := frame.RenderWithOptions(RenderOptions{MaxWidth: 20})
+	expected := strings.Join([]string{
+		"┌─ A long chart … ─┐",
+		"│ a │ ██████████ 1 │",
+		"└──────────────────┘",
+	}, "\n")
+	if output != expected {
+		t.Errorf("Expected:\n%s\n\nGot:\n%s", expected, output)
+	}
+
+	theme := DarkTheme()
+	output = frame.RenderWithOptions(RenderOptions{Theme: theme})
+	for _, want := range []string{theme.Frame.sequence() + "┌─", theme.Title.sequence() + " A long chart title "} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected %q in:\n%q", want, output)
+		}
+	}
+}
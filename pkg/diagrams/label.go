@@ -0,0 +1,199 @@
+package diagrams
+
+import "strings"
+
+// labelLines splits a node's label into lines of styled runs. A newline in the
+// label starts a new line, and escape sequences style the text after them,
+// across line breaks. In a Markdown label **bold** and *italic* text is styled.
+func labelLines(node Node) []StyledText {
+	if node.Markdown {
+		var lines []StyledText
+		for _, line := range strings.Split(node.Label, "\n") {
+			lines = append(lines, markdownRuns(line))
+		}
+		return lines
+	}
+
+	lines := []StyledText{nil}
+	for _, run := range ParseStyledText(node.Label, Style{}) {
+		for i, text := range strings.Split(run.Text, "\n") {
+			if i > 0 {
+				lines = append(lines, nil)
+			}
+			if text != "" {
+				lines[len(lines)-1] = lines[len(lines)-1].Append(text, run.Style)
+			}
+		}
+	}
+	return lines
+}
+
+// markdownRuns splits a line of Markdown into runs at its ** and * markers.
+// A marker without a matching closing marker is kept as text.
+func markdownRuns(line string) StyledText {
+	var runs StyledText
+	var style Style
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			runs = runs.Append(text.String(), style)
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(line); {
+		switch {
+		case strings.HasPrefix(line[i:], "**") && (style.Bold || strings.Contains(line[i+2:], "**")):
+			flush()
+			style.Bold = !style.Bold
+			i += 2
+		case line[i] == '*' && (style.Italic || strings.Contains(line[i+1:], "*")):
+			flush()
+			style.Italic = !style.Italic
+			i++
+		default:
+			text.WriteByte(line[i])
+			i++
+		}
+	}
+	flush()
+
+	if len(runs) == 0 {
+		return StyledText{{}}
+	}
+	return runs
+}
+
+// labelSize returns the width of a node's widest label line and the number of lines
+func labelSize(node Node) (int, int) {
+	lines := labelLines(node)
+	width := 0
+	for _, line := range lines {
+		width = max(width, line.Width())
+	}
+	return width, len(lines)
+}
+
+// drawLabel draws a label's lines from row y, each centred in the columns
+// from x to x+width-1. Runs are drawn over the given style.
+func drawLabel(c *Canvas, x, y, width int, lines []StyledText, style Style) {
+	for i, line := range lines {
+		col := x + (width-line.Width())/2
+		for _, r := range line {
+			c.Text(col, y+i, r.Text, r.Style.over(style))
+			col += StringWidth(r.Text)
+		}
+	}
+}
+
+// sideChars returns the characters of one side of a shape that is n rows
+// tall: top in the upper half, bottom in the lower half and middle on the
+// centre row when n is odd
+func sideChars(n int, top, middle, bottom string) []string {
+	sides := make([]string, n)
+	for i := range sides {
+		switch {
+		case n%2 == 1 && i == n/2:
+			sides[i] = middle
+		case i < n/2:
+			sides[i] = top
+		default:
+			sides[i] = bottom
+		}
+	}
+	return sides
+}
+
+// wrapLabel returns the node with the lines of its label wrapped to at most
+// width columns at spaces, with words too long for a line cut short by "…".
+// A wrapped Markdown label is rewritten with escape codes, which keep its
+// styles across the new breaks.
+func wrapLabel(node Node, width int) Node {
+	lines := labelLines(node)
+	var wrapped []string
+	changed := false
+	for _, line := range lines {
+		if line.Width() <= width {
+			wrapped = append(wrapped, line.String())
+			continue
+		}
+		changed = true
+		for _, part := range wrapStyled(line, width) {
+			wrapped = append(wrapped, part.String())
+		}
+	}
+	if !changed {
+		return node
+	}
+	node.Label = strings.Join(wrapped, "\n")
+	node.Markdown = false
+	return node
+}
+
+// wrapStyled breaks a line of styled text into lines of at most width
+// columns at the last space that fits. A word too long for a line of its own
+// is cut short and ends in "…".
+func wrapStyled(line StyledText, width int) []StyledText {
+	type piece struct {
+		text  string
+		style Style
+	}
+	var pieces []piece
+	for _, r := range line {
+		for _, g := range graphemes(r.Text) {
+			pieces = append(pieces, piece{g, r.Style})
+		}
+	}
+
+	var lines []StyledText
+	for start := 0; start < len(pieces); {
+		if pieces[start].text == " " {
+			start++
+			continue
+		}
+		end, used, space := start, 0, -1
+		for ; end < len(pieces); end++ {
+			w := graphemeWidth(pieces[end].text)
+			if used+w > width {
+				break
+			}
+			if pieces[end].text == " " {
+				space = end
+			}
+			used += w
+		}
+		next := end
+		switch {
+		case end < len(pieces) && pieces[end].text != " " && space > start:
+			end, next = space, space
+		case end == start:
+			end, next = end+1, end+1 // A character wider than the line
+		case end < len(pieces) && pieces[end].text != " ":
+			for end > start+1 && used+graphemeWidth(Ellipsis) > width {
+				end--
+				used -= graphemeWidth(pieces[end].text)
+			}
+			for next < len(pieces) && pieces[next].text != " " {
+				next++
+			}
+		}
+
+		var out StyledText
+		for _, p := range pieces[start:end] {
+			if n := len(out); n > 0 && out[n-1].Style == p.style {
+				out[n-1].Text += p.text
+			} else {
+				out = out.Append(p.text, p.style)
+			}
+		}
+		if n := len(out); n > 0 {
+			out[n-1].Text = strings.TrimRight(out[n-1].Text, " ")
+		}
+		if next > end {
+			out[len(out)-1].Text += Ellipsis
+		}
+		lines = append(lines, out)
+		start = next
+	}
+	return lines
+}
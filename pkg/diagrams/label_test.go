@@ -0,0 +1,80 @@
+package diagrams
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkdownRuns(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected StyledText
+	}{
+		{"plain", StyledText{{Text: "plain"}}},
+		{"**bold** text", StyledText{{"bold", Style{Bold: true}}, {" text", Style{}}}},
+		{"*a **b***", StyledText{{"a ", Style{Italic: true}}, {"b", Style{Bold: true, Italic: true}}}},
+		{"2 * 3", StyledText{{Text: "2 * 3"}}},
+		{"", StyledText{{}}},
+	}
+
+	for _, tt := range tests {
+		runs := markdownRuns(tt.line)
+		if len(runs) != len(tt.expected) {
+			t.Errorf("markdownRuns(%q): expected %v, got %v", tt.line, tt.expected, runs)
+			continue
+		}
+		for i := range runs {
+			if runs[i] != tt.expected[i] {
+				t.Errorf("markdownRuns(%q): expected %v, got %v", tt.line, tt.expected, runs)
+				break
+			}
+		}
+	}
+}
+
+func TestLabelSize(t *testing.T) {
+	width, lines := labelSize(Node{Label: "**Title**\nlonger line", Markdown: true})
+	if width != 11 || lines != 2 {
+		t.Errorf("Expected 11 columns and 2 lines, got %d and %d", width, lines)
+	}
+}
+
+func TestWrapStyled(t *testing.T) {
+	bold := Style{Bold: true}
+	tests := []struct {
+		line     StyledText
+		width    int
+		expected []string
+	}{
+		{StyledText{{Text: "Validate the request"}}, 10, []string{"Validate", "the", "request"}},
+		{StyledText{{Text: "Validate the request"}}, 12, []string{"Validate the", "request"}},
+		{StyledText{{Text: "Authentication"}}, 6, []string{"Authe…"}},
+		{StyledText{{Text: "an Authentication step"}}, 8, []string{"an", "Authent…", "step"}},
+		{StyledText{{Text: "日本語"}}, 3, []string{"日…"}},
+		{StyledText{{Text: "a "}, {"bold", bold}, {Text: " word"}}, 6, []string{"a " + Styled("bold", bold).String(), "word"}},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, line := range wrapStyled(tt.line, tt.width) {
+			got = append(got, line.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("wrapStyled(%q, %d) = %q, want %q", tt.line.String(), tt.width, got, tt.expected)
+		}
+	}
+}
+
+func TestWrapLabel(t *testing.T) {
+	node := Node{Label: "**Check** the input", Markdown: true}
+	wrapped := wrapLabel(node, 9)
+	if wrapped.Markdown {
+		t.Error("Expected a wrapped Markdown label to be rewritten as styled text")
+	}
+	if width, lines := labelSize(wrapped); width != 9 || lines != 2 {
+		t.Errorf("Expected 9 columns and 2 lines, got %d and %d", width, lines)
+	}
+	if unchanged := wrapLabel(node, 20); unchanged != node {
+		t.Errorf("Expected a label that fits to be unchanged, got %+v", unchanged)
+	}
+}
@@ -0,0 +1,651 @@
+package diagrams
+
+import (
+	"math"
+	"sort"
+)
+
+// Spacing used by the layered flowchart layout, in terminal cells
+const (
+	layoutNodeGap        = 3 // Columns between siblings in a TopToBottom rank
+	layoutNodeGapCompact = 1 // The same in layouts squeezed into a narrow width
+	layoutNodeGapLR      = 1 // Rows between siblings in a LeftToRight rank
+	layoutOrderPasses    = 8
+	layoutPositionPasses = 10
+)
+
+// layoutNode is a flowchart node (or a dummy node on a long edge) placed by the layout
+type layoutNode struct {
+	node  Node
+	dummy bool
+	rank  int
+	order int
+	pos   float64 // Centre on the minor axis (x for TopToBottom, y for LeftToRight)
+	x, y  int
+	w, h  int
+	preds []int
+	succs []int
+	loops []Edge // Self-loops, drawn beside the node rather than routed
+	back  int    // Rows below the node taken by the back edges that meet it (LeftToRight)
+	align [2]int // Offsets from its predecessor's and successor's centres a dummy node lines up with
+
+	cluster *layoutCluster // Innermost cluster framing the node
+	nested  *flowLayout    // Layout of a subgraph placed as a single node
+}
+
+// layoutEdge is a flowchart edge routed through one layout node per rank
+type layoutEdge struct {
+	edge     Edge
+	path     []int       // Layout node indices from source to target, including dummies
+	hops     []*routeHop // One hop per pair of adjacent ranks along the path
+	labelHop int         // Hop whose track carries the label, -1 to label the final segment
+	reversed bool        // Back edge laid out from target to source; its ToHead is at the start of path
+	ports    [2]int      // Offsets from the centre where the path leaves its first node and reaches its last
+	columns  [2]int      // Columns of a LeftToRight back edge below its first and last nodes, from their left
+}
+
+// rankedEdge is an edge between two layout nodes used while assigning ranks
+type rankedEdge struct {
+	from     int
+	to       int
+	edge     Edge
+	reversed bool
+}
+
+// flowLayout is a layered (Sugiyama-style) layout of a flowchart.
+//
+// Nodes are assigned to ranks along the flow direction, long edges are split
+// into chains of dummy nodes, the order within each rank is chosen to reduce
+// edge crossings, and finally every node receives grid coordinates.
+type flowLayout struct {
+	direction Direction
+	nodes     []*layoutNode
+	edges     []*layoutEdge
+	layers    [][]int
+	rankStart []int // First row (TopToBottom) or column (LeftToRight) of each rank
+	rankSize  []int // Height (TopToBottom) or width (LeftToRight) of each rank
+	gaps      []routeGap
+	clusters  []*layoutCluster
+	opening   []int // Nested frame borders needed before each rank
+	closing   []int // Nested frame borders needed after each rank
+	gap       int   // Columns between siblings in a TopToBottom rank
+	width     int
+	height    int
+}
+
+// newFlowLayout computes the layout for a flowchart
+func newFlowLayout(f *Flowchart) *flowLayout {
+	return newSpacedFlowLayout(f, layoutNodeGap)
+}
+
+// newSpacedFlowLayout computes the layout for a flowchart, leaving gap columns
+// between the siblings of a TopToBottom rank
+func newSpacedFlowLayout(f *Flowchart, gap int) *flowLayout {
+	l := &flowLayout{direction: f.Direction, gap: gap}
+	index := make(map[string]int)
+	hidden := l.hiddenMembers(f, f.Subgraphs, make(map[string]bool))
+
+	for _, node := range f.Nodes {
+		if _, ok := index[node.ID]; !ok && !hidden[node.ID] {
+			index[node.ID] = l.addNode(node, false)
+		}
+	}
+	l.addSubgraphs(f, f.Subgraphs, nil, index)
+
+	// Edges may reference nodes that were never declared; draw them as plain boxes
+	edges := make([]rankedEdge, 0, len(f.Edges))
+	for _, edge := range f.Edges {
+		if hidden[edge.From] || hidden[edge.To] {
+			continue
+		}
+		for _, id := range []string{edge.From, edge.To} {
+			if _, ok := index[id]; !ok {
+				index[id] = l.addNode(Node{ID: id, Label: id, Shape: ShapeBox}, false)
+			}
+		}
+		edges = append(edges, rankedEdge{from: index[edge.From], to: index[edge.To], edge: edge})
+	}
+
+	forward := l.acyclicEdges(edges)
+	l.assignRanks(forward)
+	l.insertDummies(forward)
+	l.clusterRanks()
+	l.orderLayers()
+	if l.direction == LeftToRight {
+		l.assignBackPorts()
+	}
+	l.assignCoordinates()
+	l.routeEdges()
+	l.placeRanks()
+
+	return l
+}
+
+func (l *flowLayout) addNode(node Node, dummy bool) int {
+	n := &layoutNode{node: node, dummy: dummy}
+	if dummy {
+		n.w, n.h = 1, 1
+	} else {
+		n.w, n.h = nodeSize(node)
+	}
+	l.nodes = append(l.nodes, n)
+	return len(l.nodes) - 1
+}
+
+// acyclicEdges moves self-loops onto their node, dropping invisible ones, and
+// reverses the edges that close a cycle, found by a depth-first search that
+// starts from the source nodes in declaration order
+func (l *flowLayout) acyclicEdges(edges []rankedEdge) []rankedEdge {
+	adjacency := make([][]int, len(l.nodes))
+	incoming := make([]int, len(l.nodes))
+	for i, e := range edges {
+		if e.from == e.to {
+			continue
+		}
+		adjacency[e.from] = append(adjacency[e.from], i)
+		incoming[e.to]++
+	}
+
+	const (
+		unvisited = iota
+		active
+		done
+	)
+	state := make([]int, len(l.nodes))
+	back := make([]bool, len(edges))
+
+	var visit func(v int)
+	visit = func(v int) {
+		state[v] = active
+		for _, i := range adjacency[v] {
+			switch state[edges[i].to] {
+			case unvisited:
+				visit(edges[i].to)
+			case active:
+				back[i] = true
+			}
+		}
+		state[v] = done
+	}
+
+	for v := range l.nodes {
+		if incoming[v] == 0 && state[v] == unvisited {
+			visit(v)
+		}
+	}
+	for v := range l.nodes {
+		if state[v] == unvisited {
+			visit(v)
+		}
+	}
+
+	var forward []rankedEdge
+	for i, e := range edges {
+		switch {
+		case e.from == e.to && e.edge.Style == EdgeInvisible:
+			// An invisible self-loop has no effect on the layout
+		case e.from == e.to:
+			l.nodes[e.from].loops = append(l.nodes[e.from].loops, e.edge)
+		case back[i]:
+			forward = append(forward, rankedEdge{from: e.to, to: e.from, edge: e.edge, reversed: true})
+		default:
+			forward = append(forward, e)
+		}
+	}
+	return forward
+}
+
+// assignRanks places every node on the longest path from a source, then pulls
+// sources down next to their first child so that short side branches stay compact
+func (l *flowLayout) assignRanks(edges []rankedEdge) {
+	incoming := make([]int, len(l.nodes))
+	outgoing := make([][]int, len(l.nodes))
+	for _, e := range edges {
+		incoming[e.to]++
+		outgoing[e.from] = append(outgoing[e.from], e.to)
+	}
+
+	var queue, topo []int
+	for v := range l.nodes {
+		if incoming[v] == 0 {
+			queue = append(queue, v)
+		}
+	}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		topo = append(topo, v)
+		for _, w := range outgoing[v] {
+			l.nodes[w].rank = max(l.nodes[w].rank, l.nodes[v].rank+1)
+			incoming[w]--
+			if incoming[w] == 0 {
+				queue = append(queue, w)
+			}
+		}
+	}
+
+	hasPreds := make([]bool, len(l.nodes))
+	for _, e := range edges {
+		hasPreds[e.to] = true
+	}
+	for i := len(topo) - 1; i >= 0; i-- {
+		v := topo[i]
+		if hasPreds[v] || len(outgoing[v]) == 0 {
+			continue
+		}
+		lowest := math.MaxInt
+		for _, w := range outgoing[v] {
+			lowest = min(lowest, l.nodes[w].rank)
+		}
+		l.nodes[v].rank = lowest - 1
+	}
+}
+
+// insertDummies splits edges spanning several ranks into chains of dummy nodes
+func (l *flowLayout) insertDummies(edges []rankedEdge) {
+	for _, e := range edges {
+		path := []int{e.from}
+		cluster := commonCluster(l.nodes[e.from].cluster, l.nodes[e.to].cluster)
+		for r := l.nodes[e.from].rank + 1; r < l.nodes[e.to].rank; r++ {
+			d := l.addNode(Node{}, true)
+			l.nodes[d].rank = r
+			l.nodes[d].cluster = cluster
+			path = append(path, d)
+		}
+		path = append(path, e.to)
+
+		for i := 0; i+1 < len(path); i++ {
+			l.nodes[path[i]].succs = append(l.nodes[path[i]].succs, path[i+1])
+			l.nodes[path[i+1]].preds = append(l.nodes[path[i+1]].preds, path[i])
+		}
+		l.edges = append(l.edges, &layoutEdge{edge: e.edge, path: path, reversed: e.reversed})
+	}
+}
+
+// orderLayers orders nodes within each rank using alternating barycenter sweeps,
+// keeping the ordering with the fewest crossings
+func (l *flowLayout) orderLayers() {
+	ranks := 0
+	for _, n := range l.nodes {
+		ranks = max(ranks, n.rank+1)
+	}
+	l.layers = make([][]int, ranks)
+
+	// Initial order: depth-first from the sources so that subtrees stay together
+	visited := make([]bool, len(l.nodes))
+	var place func(v int)
+	place = func(v int) {
+		if visited[v] {
+			return
+		}
+		visited[v] = true
+		l.layers[l.nodes[v].rank] = append(l.layers[l.nodes[v].rank], v)
+		for _, w := range l.nodes[v].succs {
+			place(w)
+		}
+	}
+	for v, n := range l.nodes {
+		if len(n.preds) == 0 {
+			place(v)
+		}
+	}
+	for v := range l.nodes {
+		place(v)
+	}
+	l.updateOrder()
+	for r := range l.layers {
+		l.groupLayer(r)
+	}
+
+	best := l.copyLayers()
+	bestCrossings := l.crossings()
+	for pass := 0; pass < layoutOrderPasses && bestCrossings > 0; pass++ {
+		if pass%2 == 0 {
+			for r := 1; r < len(l.layers); r++ {
+				l.sortLayer(r, true)
+			}
+		} else {
+			for r := len(l.layers) - 2; r >= 0; r-- {
+				l.sortLayer(r, false)
+			}
+		}
+		if c := l.crossings(); c < bestCrossings {
+			best = l.copyLayers()
+			bestCrossings = c
+		}
+	}
+	l.layers = best
+	l.updateOrder()
+}
+
+func (l *flowLayout) copyLayers() [][]int {
+	layers := make([][]int, len(l.layers))
+	for r, layer := range l.layers {
+		layers[r] = append([]int(nil), layer...)
+	}
+	return layers
+}
+
+func (l *flowLayout) updateOrder() {
+	for _, layer := range l.layers {
+		for i, v := range layer {
+			l.nodes[v].order = i
+		}
+	}
+}
+
+// sortLayer reorders a rank by the mean position of each node's neighbours in
+// the previous (down) or next (up) rank
+func (l *flowLayout) sortLayer(r int, down bool) {
+	layer := l.layers[r]
+	keys := make(map[int]float64, len(layer))
+	for i, v := range layer {
+		neighbours := l.nodes[v].succs
+		if down {
+			neighbours = l.nodes[v].preds
+		}
+		if len(neighbours) == 0 {
+			keys[v] = float64(i)
+			continue
+		}
+		sum := 0.0
+		for _, w := range neighbours {
+			sum += float64(l.nodes[w].order)
+		}
+		keys[v] = sum / float64(len(neighbours))
+	}
+	sort.SliceStable(layer, func(i, j int) bool {
+		return keys[layer[i]] < keys[layer[j]]
+	})
+	for i, v := range layer {
+		l.nodes[v].order = i
+	}
+	l.groupLayer(r)
+}
+
+// crossings counts edge crossings between all pairs of adjacent ranks
+func (l *flowLayout) crossings() int {
+	total := 0
+	for r := 0; r+1 < len(l.layers); r++ {
+		type pair struct{ a, b int }
+		var pairs []pair
+		for _, v := range l.layers[r] {
+			for _, w := range l.nodes[v].succs {
+				pairs = append(pairs, pair{l.nodes[v].order, l.nodes[w].order})
+			}
+		}
+		sort.Slice(pairs, func(i, j int) bool {
+			if pairs[i].a != pairs[j].a {
+				return pairs[i].a < pairs[j].a
+			}
+			return pairs[i].b < pairs[j].b
+		})
+
+		// Count inversions of the lower endpoints with a Fenwick tree
+		tree := make([]int, len(l.layers[r+1])+1)
+		for i, p := range pairs {
+			smallerOrEqual := 0
+			for k := p.b + 1; k > 0; k -= k & -k {
+				smallerOrEqual += tree[k]
+			}
+			total += i - smallerOrEqual
+			for k := p.b + 1; k < len(tree); k += k & -k {
+				tree[k]++
+			}
+		}
+	}
+	return total
+}
+
+// minorSize returns a node's extent across the flow direction
+func (l *flowLayout) minorSize(n *layoutNode) int {
+	if l.direction == LeftToRight {
+		return n.h
+	}
+	return n.w
+}
+
+// majorSize returns a node's extent along the flow direction
+func (l *flowLayout) majorSize(n *layoutNode) int {
+	if l.direction == LeftToRight {
+		return max(n.w, StringWidth(loopLabel(n)))
+	}
+	return n.h
+}
+
+// afterSize returns the space taken after a node across the flow direction by
+// its self-loops, and in LeftToRight layouts by the back edges below it
+func (l *flowLayout) afterSize(n *layoutNode) int {
+	return l.loopSize(n) + n.back
+}
+
+// loopSize returns the space taken by a node's self-loops after the node
+// across the flow direction: right of it (TopToBottom) or below it (LeftToRight)
+func (l *flowLayout) loopSize(n *layoutNode) int {
+	if len(n.loops) == 0 {
+		return 0
+	}
+	label := loopLabel(n)
+	if l.direction == LeftToRight {
+		if label != "" {
+			return 3
+		}
+		return 2
+	}
+	if label != "" {
+		return 3 + StringWidth(label)
+	}
+	return 2
+}
+
+// nodeGap returns the free space between two adjacent nodes of a rank
+func (l *flowLayout) nodeGap() int {
+	if l.direction == LeftToRight {
+		return layoutNodeGapLR
+	}
+	return l.gap
+}
+
+// separation returns the minimum distance between the centres of two adjacent nodes
+func (l *flowLayout) separation(a, b *layoutNode) int {
+	sa, sb := l.minorSize(a), l.minorSize(b)
+	return sa - sa/2 + l.afterSize(a) + sb/2 + l.nodeGap() + l.clusterPad(a, b)
+}
+
+// assignCoordinates positions nodes across the flow direction by repeatedly
+// moving each rank towards its neighbours
+func (l *flowLayout) assignCoordinates() {
+	for _, layer := range l.layers {
+		pos := 0.0
+		for i, v := range layer {
+			if i > 0 {
+				pos += float64(l.separation(l.nodes[layer[i-1]], l.nodes[v]))
+			}
+			l.nodes[v].pos = pos
+		}
+	}
+
+	for pass := 0; pass < layoutPositionPasses; pass++ {
+		if pass%2 == 0 {
+			for r := 1; r < len(l.layers); r++ {
+				l.alignLayer(r, true)
+			}
+		} else {
+			for r := len(l.layers) - 2; r >= 0; r-- {
+				l.alignLayer(r, false)
+			}
+		}
+	}
+
+	// Snap to whole cells, keeping every pair of neighbours apart
+	lowest := math.MaxInt
+	centres := make([]int, len(l.nodes))
+	starts := make([]int, len(l.nodes))
+	for _, layer := range l.layers {
+		for i, v := range layer {
+			n := l.nodes[v]
+			centres[v] = int(math.Round(n.pos))
+			if i > 0 {
+				prev := layer[i-1]
+				centres[v] = max(centres[v], centres[prev]+l.separation(l.nodes[prev], n))
+			}
+			starts[v] = centres[v] - l.minorSize(n)/2
+			lowest = min(lowest, starts[v])
+		}
+	}
+	for v, n := range l.nodes {
+		if l.direction == LeftToRight {
+			n.y = starts[v] - lowest
+		} else {
+			n.x = starts[v] - lowest
+		}
+	}
+	l.fitClusters()
+}
+
+// placeRanks stacks the ranks along the flow direction, leaving each gap
+// between ranks just large enough for its edge routing
+func (l *flowLayout) placeRanks() {
+	l.rankStart = make([]int, len(l.layers))
+	l.rankSize = make([]int, len(l.layers))
+	for r, layer := range l.layers {
+		for _, v := range layer {
+			if !l.nodes[v].dummy {
+				l.rankSize[r] = max(l.rankSize[r], l.majorSize(l.nodes[v]))
+			}
+		}
+		l.rankSize[r] = max(l.rankSize[r], 1)
+		switch {
+		case r > 0:
+			l.rankStart[r] = l.rankStart[r-1] + l.rankSize[r-1] + l.gapSize(r-1)
+		case l.opening[0] > 0:
+			// Frames around the first rank, and a blank row or column inside them
+			l.rankStart[0] = l.opening[0] + 1
+		}
+		if l.direction == LeftToRight {
+			l.fitTitles(r)
+		}
+	}
+
+	for _, n := range l.nodes {
+		major := l.rankStart[n.rank]
+		if l.direction == LeftToRight {
+			if n.dummy {
+				// Dummy nodes stretch across their rank so edges pass straight through
+				n.w = l.rankSize[n.rank]
+			}
+			n.x = major
+		} else {
+			if n.dummy {
+				n.h = l.rankSize[n.rank]
+			}
+			n.y = major
+		}
+		if l.direction == LeftToRight {
+			l.width = max(l.width, n.x+l.majorSize(n))
+			l.height = max(l.height, n.y+n.h+l.afterSize(n))
+		} else {
+			l.width = max(l.width, n.x+n.w+l.loopSize(n))
+			l.height = max(l.height, n.y+n.h)
+		}
+	}
+
+	for _, c := range l.clusters {
+		x, y, w, h := l.frame(c)
+		l.width = max(l.width, x+w)
+		l.height = max(l.height, y+h)
+	}
+
+	// Labels centred on a vertical segment may reach past the rightmost node,
+	// and labels written beside a back edge even further
+	if l.direction == TopToBottom {
+		l.width += l.labelMargin()
+	}
+}
+
+func (l *flowLayout) labelMargin() int {
+	margin := 0
+	for _, e := range l.edges {
+		if e.reversed && e.edge.Label != "" {
+			margin = max(margin, StringWidth(e.edge.Label)+3)
+		} else {
+			margin = max(margin, StringWidth(e.edge.Label)/2+1)
+		}
+	}
+	return margin
+}
+
+// alignLayer moves the nodes of rank r towards the mean centre of their
+// neighbours in the previous (down) or next (up) rank without changing their order
+func (l *flowLayout) alignLayer(r int, down bool) {
+	layer := l.layers[r]
+	targets := make([]float64, len(layer))
+	weights := make([]float64, len(layer))
+	offset := 0.0
+	for i, v := range layer {
+		n := l.nodes[v]
+		if i > 0 {
+			offset += float64(l.separation(l.nodes[layer[i-1]], n))
+		}
+
+		neighbours, side := n.succs, 1
+		if down {
+			neighbours, side = n.preds, 0
+		}
+		target := n.pos
+		if len(neighbours) > 0 {
+			sum := 0.0
+			for _, w := range neighbours {
+				sum += l.nodes[w].pos + float64(n.align[side])
+			}
+			target = sum / float64(len(neighbours))
+		}
+
+		// Subtracting the cumulative separation turns the spacing constraint
+		// into a plain ordering constraint that isotonic regression can solve
+		targets[i] = target - offset
+		weights[i] = 1
+		if n.dummy {
+			weights[i] = 2 // Keep long edges straight
+		}
+	}
+
+	fitted := isotonic(targets, weights)
+	offset = 0.0
+	for i, v := range layer {
+		if i > 0 {
+			offset += float64(l.separation(l.nodes[layer[i-1]], l.nodes[v]))
+		}
+		l.nodes[v].pos = fitted[i] + offset
+	}
+}
+
+// isotonic returns the weighted least-squares non-decreasing fit of values
+// using the pool adjacent violators algorithm
+func isotonic(values, weights []float64) []float64 {
+	type block struct {
+		sum    float64
+		weight float64
+		count  int
+	}
+	var blocks []block
+	for i, v := range values {
+		blocks = append(blocks, block{sum: v * weights[i], weight: weights[i], count: 1})
+		for len(blocks) > 1 {
+			a, b := blocks[len(blocks)-2], blocks[len(blocks)-1]
+			if a.sum/a.weight <= b.sum/b.weight {
+				break
+			}
+			blocks = blocks[:len(blocks)-1]
+			blocks[len(blocks)-1] = block{sum: a.sum + b.sum, weight: a.weight + b.weight, count: a.count + b.count}
+		}
+	}
+
+	fitted := make([]float64, 0, len(values))
+	for _, b := range blocks {
+		for i := 0; i < b.count; i++ {
+			fitted = append(fitted, b.sum/b.weight)
+		}
+	}
+	return fitted
+}
@@ -0,0 +1,184 @@
+package diagrams
+
+import (
+	"strings"
+	"testing"
+)
+
+func layoutNodeByID(l *flowLayout, id string) *layoutNode {
+	for _, n := range l.nodes {
+		if !n.dummy && n.node.ID == id {
+			return n
+		}
+	}
+	return nil
+}
+
+func TestFlowLayout_Ranks(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddNode("a", "A", ShapeBox).
+		AddNode("b", "B", ShapeBox).
+		AddNode("c", "C", ShapeBox).
+		AddNode("d", "D", ShapeBox).
+		AddEdge("a", "b", "").
+		AddEdge("b", "c", "").
+		AddEdge("a", "d", "").
+		AddEdge("c", "d", "")
+
+	l := newFlowLayout(flow)
+
+	expected := map[string]int{"a": 0, "b": 1, "c": 2, "d": 3}
+	for id, rank := range expected {
+		if got := layoutNodeByID(l, id).rank; got != rank {
+			t.Errorf("Node %s: expected rank %d, got %d", id, rank, got)
+		}
+	}
+
+	// a -> d spans three ranks and needs two dummy nodes
+	dummies := 0
+	for _, n := range l.nodes {
+		if n.dummy {
+			dummies++
+		}
+	}
+	if dummies != 2 {
+		t.Errorf("Expected 2 dummy nodes, got %d", dummies)
+	}
+}
+
+func TestFlowLayout_SiblingsSideBySide(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddNode("q", "Valid?", ShapeDiamond).
+		AddNode("yes", "Process", ShapeBox).
+		AddNode("no", "Reject", ShapeBox).
+		AddEdge("q", "yes", "yes").
+		AddEdge("q", "no", "no")
+
+	l := newFlowLayout(flow)
+	yes, no := layoutNodeByID(l, "yes"), layoutNodeByID(l, "no")
+
+	if yes.y != no.y {
+		t.Errorf("Expected siblings on the same row, got y=%d and y=%d", yes.y, no.y)
+	}
+	if yes.x+yes.w > no.x && no.x+no.w > yes.x {
+		t.Errorf("Expected siblings not to overlap, got x=%d..%d and x=%d..%d",
+			yes.x, yes.x+yes.w, no.x, no.x+no.w)
+	}
+}
+
+func TestFlowLayout_ReducesCrossings(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddNode("a", "A", ShapeBox).
+		AddNode("b", "B", ShapeBox).
+		AddNode("c", "C", ShapeBox).
+		AddNode("d", "D", ShapeBox).
+		AddEdge("a", "d", "").
+		AddEdge("b", "c", "")
+
+	l := newFlowLayout(flow)
+
+	if c := l.crossings(); c != 0 {
+		t.Errorf("Expected 0 crossings, got %d", c)
+	}
+
+	// Swapping the lower rank makes the two edges cross
+	layer := l.layers[1]
+	layer[0], layer[1] = layer[1], layer[0]
+	l.updateOrder()
+	if c := l.crossings(); c != 1 {
+		t.Errorf("Expected 1 crossing after swap, got %d", c)
+	}
+
+	l.sortLayer(1, true)
+	if c := l.crossings(); c != 0 {
+		t.Errorf("Expected barycenter sort to remove the crossing, got %d", c)
+	}
+}
+
+func TestFlowLayout_UndeclaredNodes(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddNode("a", "Start", ShapeBox).
+		AddEdge("a", "missing", "")
+
+	output := flow.Render()
+
+	if !strings.Contains(output, "missing") {
+		t.Errorf("Expected undeclared node to be drawn with its ID, got:\n%s", output)
+	}
+}
+
+func TestFlowLayout_Cycle(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddNode("a", "A", ShapeBox).
+		AddNode("b", "B", ShapeBox).
+		AddEdge("a", "b", "").
+		AddEdge("b", "a", "")
+
+	l := newFlowLayout(flow)
+
+	if layoutNodeByID(l, "a").rank != 0 || layoutNodeByID(l, "b").rank != 1 {
+		t.Error("Expected cycle to be broken in declaration order")
+	}
+
+	// The edge closing the cycle is kept, laid out from its target
+	if len(l.edges) != 2 || !l.edges[1].reversed {
+		t.Errorf("Expected the back edge to be reversed, got %d edges", len(l.edges))
+	}
+}
+
+func TestFlowLayout_SelfLoop(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddNode("a", "Poll", ShapeBox).
+		AddNode("b", "Next", ShapeBox).
+		AddEdge("a", "a", "wait").
+		AddEdge("a", "b", "")
+
+	l := newFlowLayout(flow)
+	a := layoutNodeByID(l, "a")
+
+	if len(a.loops) != 1 || len(l.edges) != 1 {
+		t.Errorf("Expected the self-loop to stay on its node, got %d loops and %d edges", len(a.loops), len(l.edges))
+	}
+
+	// The loop and its label fit inside the layout
+	if l.width < a.x+a.w+3+len("wait") {
+		t.Errorf("Expected room for the loop label, got width %d", l.width)
+	}
+}
+
+func TestFlowchart_RenderFanOut(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddNode("q", "Valid?", ShapeDiamond).
+		AddNode("yes", "Process", ShapeBox).
+		AddNode("no", "Reject", ShapeBox).
+		AddEdge("q", "yes", "yes").
+		AddEdge("q", "no", "no")
+
+	output := flow.Render()
+	lines := strings.Split(output, "\n")
+
+	// Both targets must appear on a single row, each with an arrow above it
+	found := false
+	for i, line := range lines {
+		if strings.Contains(line, "Process") && strings.Contains(line, "Reject") {
+			found = true
+			if strings.Count(lines[i-2], ArrowDown) != 2 {
+				t.Errorf("Expected two arrows above the targets, got:\n%s", output)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected branches side by side, got:\n%s", output)
+	}
+}
+
+func TestIsotonic(t *testing.T) {
+	fitted := isotonic([]float64{1, 3, 2, 4}, []float64{1, 1, 1, 1})
+	expected := []float64{1, 2.5, 2.5, 4}
+
+	for i := range expected {
+		if fitted[i] != expected[i] {
+			t.Errorf("isotonic()[%d] = %v, expected %v", i, fitted[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package diagrams
+
+import (
+	"io"
+	"strings"
+)
+
+// LegendEntry explains one colour or line style of a diagram
+type LegendEntry struct {
+	Symbol string // A sample of the colour or line, such as ██ or ┄┄┄→
+	Style  Style  // Style the symbol is drawn in
+	Label  string // What the symbol stands for
+}
+
+// Legend lists what the colours and line styles of a diagram stand for, each
+// as a symbol followed by its label
+type Legend struct {
+	Entries   []LegendEntry
+	Direction Direction // TopToBottom lists one entry per row; LeftToRight fills rows left to right
+}
+
+// legendGap is the number of columns between entries of a left-to-right legend
+const legendGap = 3
+
+// NewLegend creates an empty legend
+func NewLegend(direction Direction) *Legend {
+	return &Legend{Direction: direction}
+}
+
+// Add appends an entry to the legend
+func (l *Legend) Add(symbol, label string, style Style) *Legend {
+	l.Entries = append(l.Entries, LegendEntry{Symbol: symbol, Style: style, Label: label})
+	return l
+}
+
+// LegendFor returns a top-to-bottom legend derived from a diagram, in the
+// colours of theme, which may be nil: a block per coloured bar of a bar chart,
+// a line per edge style of a flowchart and an arrow per message type of a
+// sequence diagram. The entries of composites and frames are collected from
+// the diagrams inside them, without repeats. Entries can be relabelled before
+// the legend is rendered.
+func LegendFor(d Diagram, theme *Theme) *Legend {
+	l := NewLegend(TopToBottom)
+	l.collect(d, RenderOptions{Theme: theme}.theme())
+	return l
+}
+
+// collect appends the entries of a diagram that aren't in the legend yet
+func (l *Legend) collect(d Diagram, theme *Theme) {
+	add := func(e LegendEntry) {
+		for _, existing := range l.Entries {
+			if existing == e {
+				return
+			}
+		}
+		l.Entries = append(l.Entries, e)
+	}
+
+	switch d := d.(type) {
+	case *BarChart:
+		for i, bar := range d.Bars {
+			if color := theme.barColor(i, bar); color != "" {
+				add(LegendEntry{Symbol: "██", Style: Style{Fg: color}, Label: bar.Label})
+			}
+		}
+	case *Flowchart:
+		for _, e := range d.Edges {
+			if e.Style != EdgeInvisible {
+				add(LegendEntry{Symbol: edgeSymbol(e), Style: theme.Edge, Label: e.Style.name()})
+			}
+		}
+	case *SequenceDiagram:
+		for _, msg := range d.Messages {
+			add(LegendEntry{Symbol: msg.Type.symbol(), Style: theme.Edge, Label: msg.Type.name()})
+		}
+	case *Frame:
+		l.collect(d.Diagram, theme)
+	case *Grid:
+		for _, cell := range d.Cells {
+			l.collect(cell.Diagram, theme)
+		}
+	case *HStack:
+		l.collect(d.grid(), theme)
+	case *VStack:
+		l.collect(d.grid(), theme)
+	}
+}
+
+// edgeSymbol returns a short horizontal sample of an edge's line and markers
+func edgeSymbol(e Edge) string {
+	line := BoxHorizontal
+	if char, ok := styledLines[e.Style.line()][lineLeft|lineRight]; ok {
+		line = char
+	}
+	from := line
+	if e.FromHead != HeadNone {
+		from = headChar(e.FromHead, ArrowLeft)
+	}
+	to := line
+	if e.ToHead != HeadNone {
+		to = headChar(e.ToHead, ArrowRight)
+	}
+	return from + strings.Repeat(line, 2) + to
+}
+
+// name returns the edge style's name, as shown in legends
+func (s EdgeStyle) name() string {
+	switch s {
+	case EdgeDotted:
+		return "dotted"
+	case EdgeThick:
+		return "thick"
+	case EdgeInvisible:
+		return "invisible"
+	}
+	return "solid"
+}
+
+// symbol returns a sample of the message type's arrow, as drawn between lifelines
+func (t MessageType) symbol() string {
+	switch t {
+	case MessageAsync:
+		return "---" + ArrowRight
+	case MessageReturn:
+		return ArrowLeft + "---"
+	}
+	return strings.Repeat(BoxHorizontal, 3) + ArrowRight
+}
+
+// name returns the message type's name, as shown in legends
+func (t MessageType) name() string {
+	switch t {
+	case MessageAsync:
+		return "async"
+	case MessageReturn:
+		return "return"
+	}
+	return "sync"
+}
+
+// Render renders the legend with the default options
+func (l *Legend) Render() string {
+	return l.RenderWithOptions(RenderOptions{})
+}
+
+// RenderWithOptions renders the legend in the options' theme, charset and
+// colour profile, with labels in the theme's Text style. A left-to-right
+// legend wider than MaxWidth moves entries onto more rows; a top-to-bottom
+// one shortens its labels.
+func (l *Legend) RenderWithOptions(opts RenderOptions) string {
+	return l.canvas(opts).render(opts)
+}
+
+// RenderTo writes the legend to w as RenderWithOptions renders it, one row at
+// a time, and returns the number of bytes written
+func (l *Legend) RenderTo(w io.Writer, opts RenderOptions) (int64, error) {
+	return l.canvas(opts).writeTo(w, opts)
+}
+
+// WriteTo writes the legend to w as Render renders it, implementing io.WriterTo
+func (l *Legend) WriteTo(w io.Writer) (int64, error) {
+	return l.RenderTo(w, RenderOptions{})
+}
+
+// Size returns the columns and rows the legend takes when rendered with opts
+func (l *Legend) Size(opts RenderOptions) (int, int) {
+	_, width, height := l.layout(opts)
+	return opts.clamp(width, height)
+}
+
+// layout returns where each entry starts and the legend's size. Symbols of a
+// top-to-bottom legend are padded to the widest, so that labels line up.
+func (l *Legend) layout(opts RenderOptions) ([]Point, int, int) {
+	points := make([]Point, len(l.Entries))
+	width, height := 0, 0
+	if l.Direction == LeftToRight {
+		x := 0
+		for i, e := range l.Entries {
+			w := StringWidth(e.Symbol) + 1 + StringWidth(e.Label)
+			if x > 0 && opts.MaxWidth > 0 && x+legendGap+w > opts.MaxWidth {
+				x, height = 0, height+1
+			} else if x > 0 {
+				x += legendGap
+			}
+			points[i] = Point{x, height}
+			x += w
+			width = max(width, x)
+		}
+		if len(l.Entries) > 0 {
+			height++
+		}
+		return points, width, height
+	}
+
+	symbolWidth := l.symbolWidth()
+	for i, e := range l.Entries {
+		points[i] = Point{0, i}
+		width = max(width, symbolWidth+1+StringWidth(e.Label))
+	}
+	return points, width, len(l.Entries)
+}
+
+// symbolWidth returns the width of the widest symbol
+func (l *Legend) symbolWidth() int {
+	width := 0
+	for _, e := range l.Entries {
+		width = max(width, StringWidth(e.Symbol))
+	}
+	return width
+}
+
+// canvas draws each entry's symbol and label
+func (l *Legend) canvas(opts RenderOptions) *Canvas {
+	points, width, height := l.layout(opts)
+	width, height = opts.clamp(width, height)
+	theme := opts.theme()
+	c := NewCanvas(width, height)
+
+	labelX := l.symbolWidth() + 1
+	for i, e := range l.Entries {
+		at := points[i]
+		c.glyphs(at.X, at.Y, e.Symbol, e.Style)
+		if l.Direction == LeftToRight {
+			labelX = StringWidth(e.Symbol) + 1
+		}
+		c.Text(at.X+labelX, at.Y, Truncate(e.Label, width-at.X-labelX), theme.Text)
+	}
+	return c
+}
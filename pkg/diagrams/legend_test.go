@@ -0,0 +1,75 @@
+package diagrams
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLegendFor(t *testing.T) {
+	green, blue := sgr(32), sgr(34)
+	chart := NewBarChart("", Horizontal).
+		AddBarWithColor("api", 2, green).
+		AddBar("plain", 1).
+		AddBarWithColor("db", 1, blue)
+	flow := NewFlowchart(TopToBottom).AddNode("a", "A", ShapeBox).AddNode("b", "B", ShapeBox)
+	flow.AddEdge("a", "b", "").
+		AddEdge("b", "a", "").
+		AddStyledEdge("a", "b", "", EdgeThick, HeadCircle, HeadCross).
+		AddStyledEdge("a", "b", "", EdgeInvisible, HeadNone, HeadArrow)
+	seq := NewSequenceDiagram().
+		AddMessage("A", "B", "", MessageAsync).
+		AddMessage("B", "A", "", MessageReturn)
+
+	legend := LegendFor(NewVStack(NewFrame(chart), NewHStack(flow, seq)), nil)
+	expected := []LegendEntry{
+		{"██", Style{Fg: green}, "api"},
+		{"██", Style{Fg: blue}, "db"},
+		{"───→", Style{}, "solid"},
+		{"◯━━✕", Style{}, "thick"},
+		{"---→", Style{}, "async"},
+		{"←---", Style{}, "return"},
+	}
+	if len(legend.Entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %+v", len(expected), legend.Entries)
+	}
+	for i, e := range legend.Entries {
+		if e != expected[i] {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, expected[i], e)
+		}
+	}
+}
+
+func TestLegendFor_Theme(t *testing.T) {
+	theme := &Theme{Palette: []string{sgr(31)}, Edge: Style{Fg: sgr(36)}}
+	chart := NewBarChart("", Horizontal).AddBar("a", 1)
+	flow := NewFlowchart(TopToBottom).AddNode("a", "A", ShapeBox).AddNode("b", "B", ShapeBox)
+	flow.AddEdge("a", "b", "")
+
+	legend := LegendFor(NewHStack(chart, flow), theme)
+	if len(legend.Entries) != 2 || legend.Entries[0].Style.Fg != sgr(31) || legend.Entries[1].Style != theme.Edge {
+		t.Errorf("Expected the palette colour and edge style, got %+v", legend.Entries)
+	}
+}
+
+func TestLegend_Render(t *testing.T) {
+	legend := NewLegend(TopToBottom).
+		Add("██", "Completed", Style{}).
+		Add("┄┄→", "Planned", Style{})
+
+	if got, want := legend.Render(), "██  Completed\n┄┄→ Planned"; got != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+
+	legend.Direction = LeftToRight
+	if got, want := legend.Render(), "██ Completed   ┄┄→ Planned"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got, want := legend.RenderWithOptions(RenderOptions{MaxWidth: 20}), "██ Completed\n┄┄→ Planned"; got != want {
+		t.Errorf("Expected entries moved onto a new row, got %q", got)
+	}
+
+	legend.Direction = TopToBottom
+	if got := legend.RenderWithOptions(RenderOptions{MaxWidth: 8}); !strings.HasPrefix(got, "██  Com…\n") {
+		t.Errorf("Expected labels shortened, got %q", got)
+	}
+}
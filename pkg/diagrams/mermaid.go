@@ -1,7 +1,9 @@
 package diagrams
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
 	"strings"
@@ -15,161 +17,157 @@ import (
 //	A[Start] --> B{Decision}
 //	B -->|Yes| C[OK]
 //	B -->|No| D[End]
+//
+// Nodes can be grouped with subgraph ... end blocks, which may be nested and
+// may set their own direction:
+//
+//	subgraph api [API Layer]
+//	  direction LR
+//	  C --> D
+//	end
 func ParseMermaidFlowchart(mermaidText string) (*Flowchart, error) {
-	lines := strings.Split(mermaidText, "\n")
-	if len(lines) == 0 {
-		return nil, fmt.Errorf("empty mermaid text")
-	}
+	flow, _, err := parseFlowchart(mermaidText)
+	return flow, err
+}
 
-	// Parse direction from first line
-	direction := TopToBottom
-	firstLine := strings.TrimSpace(lines[0])
-	if strings.HasPrefix(firstLine, "graph ") || strings.HasPrefix(firstLine, "flowchart ") {
-		dirStr := strings.Fields(firstLine)
-		if len(dirStr) > 1 {
-			switch dirStr[1] {
-			case "LR", "RL":
-				direction = LeftToRight
-			case "TD", "TB":
-				direction = TopToBottom
-			}
-		}
+// parseFlowchart parses a flowchart and returns it with the parser's warnings
+func parseFlowchart(mermaidText string) (*Flowchart, []ParseWarning, error) {
+	ast, warnings, err := parseFlowchartAST(mermaidText)
+	if err != nil {
+		return nil, nil, err
 	}
+	flow, more := ast.flowchart()
+	return flow, append(warnings, more...), nil
+}
 
-	flow := NewFlowchart(direction)
-	nodes := make(map[string]bool)
-
-	// Regex patterns
-	// Match nodes: A[text], A(text), A{text}, A((text))
-	nodeRegex := regexp.MustCompile(`([A-Za-z0-9_]+)(\(\(|[\[\(\{])([^\]\)\}]+)(\)\)|[\]\)\}])`)
-	// Match edges: A --> B or A[text] --> B[text] or A -->|label| B
-	// Allows optional shape syntax after node IDs
-	edgeRegex := regexp.MustCompile(`([A-Za-z0-9_]+)(?:\[[^\]]+\]|\([^)]+\)|\{[^}]+\}|\(\([^)]+\)\))?\s*--+>\s*(?:\|([^|]+)\|)?\s*([A-Za-z0-9_]+)(?:\[[^\]]+\]|\([^)]+\)|\{[^}]+\}|\(\([^)]+\)\))?`)
+var subgraphRegex = regexp.MustCompile(`^([A-Za-z0-9_]+)\s*\[(.*)\]$`)
 
-	for i := 1; i < len(lines); i++ {
-		line := strings.TrimSpace(lines[i])
-		if line == "" || strings.HasPrefix(line, "%%") {
-			continue
-		}
+// parseDirection converts a Mermaid direction keyword such as TD or LR
+func parseDirection(keyword string) (Direction, bool) {
+	switch keyword {
+	case "LR", "RL":
+		return LeftToRight, true
+	case "TD", "TB", "BT":
+		return TopToBottom, true
+	}
+	return TopToBottom, false
+}
 
-		// Extract nodes
-		nodeMatches := nodeRegex.FindAllStringSubmatch(line, -1)
-		for _, match := range nodeMatches {
-			if len(match) >= 4 {
-				id := match[1]
-				label := match[3]
-				shapeStart := match[2]
-
-				if !nodes[id] {
-					shape := ShapeBox
-					switch shapeStart {
-					case "[":
-						shape = ShapeBox
-					case "(":
-						shape = ShapeRounded
-					case "((":
-						shape = ShapeCircle
-					case "{":
-						shape = ShapeDiamond
-					}
-
-					flow.AddNode(id, label, shape)
-					nodes[id] = true
-				}
-			}
-		}
+// parseLink converts the parts of a Mermaid link, its start marker, line and
+// end marker (for example "<", "-.-" and ">"), into an edge style and markers
+func parseLink(start, line, end string) (EdgeStyle, ArrowHead, ArrowHead) {
+	style := EdgeSolid
+	switch {
+	case strings.Contains(line, "~"):
+		style = EdgeInvisible
+	case strings.Contains(line, "="):
+		style = EdgeThick
+	case strings.Contains(line, "."):
+		style = EdgeDotted
+	}
+	return style, parseArrowHead(start), parseArrowHead(end)
+}
 
-		// Extract edges
-		edgeMatches := edgeRegex.FindAllStringSubmatch(line, -1)
-		for _, match := range edgeMatches {
-			if len(match) >= 4 {
-				from := match[1]
-				label := ""
-				if len(match) > 2 && match[2] != "" {
-					label = match[2]
-				}
-				to := match[3]
-				flow.AddEdge(from, to, label)
-			}
-		}
+// parseArrowHead converts a Mermaid link marker: <, >, o or x
+func parseArrowHead(marker string) ArrowHead {
+	switch marker {
+	case "<", ">":
+		return HeadArrow
+	case "o":
+		return HeadCircle
+	case "x":
+		return HeadCross
 	}
+	return HeadNone
+}
 
-	return flow, nil
+// parseSubgraphHeader parses what follows the subgraph keyword: an ID with an
+// optional [Title], or a title on its own that doubles as the ID
+func parseSubgraphHeader(header string) *Subgraph {
+	if match := subgraphRegex.FindStringSubmatch(header); match != nil {
+		return &Subgraph{ID: match[1], Title: decodeText(match[2])}
+	}
+	title := decodeText(header)
+	return &Subgraph{ID: title, Title: title}
 }
 
 // ParseMermaidSequence parses Mermaid sequence diagram syntax
+//
+// Supports participant and actor declarations and messages:
+//
+//	sequenceDiagram
+//	participant A as Alice
+//	A->>B: Request
+//	B-->>A: Response
+//	A-)B: Event
 func ParseMermaidSequence(mermaidText string) (*SequenceDiagram, error) {
-	lines := strings.Split(mermaidText, "\n")
-	if len(lines) == 0 {
-		return nil, fmt.Errorf("empty mermaid text")
-	}
-
-	seq := NewSequenceDiagram()
-	actors := make(map[string]bool)
+	seq, _, err := parseSequence(mermaidText)
+	return seq, err
+}
 
-	msgRegex := regexp.MustCompile(`([A-Za-z0-9_]+)\s*(-->>|->>|-->|->)\s*([A-Za-z0-9_]+)\s*:\s*(.+)`)
+// parseSequence parses a sequence diagram and returns it with the parser's warnings
+func parseSequence(mermaidText string) (*SequenceDiagram, []ParseWarning, error) {
+	ast, warnings, err := parseSequenceAST(mermaidText)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ast.sequence(), warnings, nil
+}
 
-	for i, line := range lines {
-		line = strings.TrimSpace(line)
+// ParseResult is a diagram parsed by ParseMermaid
+type ParseResult struct {
+	Diagram  Diagram
+	Warnings []ParseWarning // Statements that were skipped or only partly supported
+}
 
-		if i == 0 || line == "" || strings.HasPrefix(line, "%%") {
-			continue
-		}
+// ParseMermaid parses a flowchart or sequence diagram, detecting the type from
+// its header. Syntax errors are returned as a *ParseError.
+func ParseMermaid(mermaidText string) (*ParseResult, error) {
+	var diagram Diagram
+	var warnings []ParseWarning
+	var err error
+
+	switch mermaidType(mermaidText) {
+	case "flowchart":
+		diagram, warnings, err = parseFlowchart(mermaidText)
+	case "sequenceDiagram":
+		diagram, warnings, err = parseSequence(mermaidText)
+	default:
+		err = newParser(mermaidText, lexFlowchart).parseHeader("graph", "flowchart", "sequenceDiagram")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ParseResult{Diagram: diagram, Warnings: warnings}, nil
+}
 
-		// Parse participant declarations
-		if strings.HasPrefix(line, "participant ") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				id := parts[1]
-				name := id
-				if len(parts) > 3 && parts[2] == "as" {
-					name = strings.Join(parts[3:], " ")
-				}
-				if !actors[id] {
-					seq.AddActor(id, name)
-					actors[id] = true
-				}
-			}
+// mermaidType returns the diagram type named on the first line that is not
+// blank or a comment: "flowchart", "sequenceDiagram" or "unknown"
+func mermaidType(mermaidText string) string {
+	for _, line := range strings.Split(mermaidText, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "%%") {
 			continue
 		}
-
-		// Parse messages
-		matches := msgRegex.FindStringSubmatch(line)
-		if len(matches) >= 5 {
-			from := matches[1]
-			arrow := matches[2]
-			to := matches[3]
-			label := matches[4]
-
-			if !actors[from] {
-				seq.AddActor(from, from)
-				actors[from] = true
-			}
-			if !actors[to] {
-				seq.AddActor(to, to)
-				actors[to] = true
-			}
-
-			msgType := MessageSync
-			switch arrow {
-			case "->>", "->":
-				msgType = MessageSync
-			case "-->>", "-->":
-				msgType = MessageReturn
-			}
-
-			seq.AddMessage(from, to, label, msgType)
+		switch fields[0] {
+		case "graph", "flowchart":
+			return "flowchart"
+		case "sequenceDiagram":
+			return "sequenceDiagram"
 		}
+		break
 	}
-
-	return seq, nil
+	return "unknown"
 }
 
 // MermaidBlock represents a Mermaid diagram found in Markdown
 type MermaidBlock struct {
-	Type    string  // "flowchart", "sequenceDiagram", etc.
-	Content string  // The mermaid code
-	Diagram Diagram // Parsed diagram (if successful)
+	Type     string         // "flowchart", "sequenceDiagram", etc.
+	Content  string         // The mermaid code
+	Line     int            // Line of the Markdown file where the code starts
+	Diagram  Diagram        // Parsed diagram (if successful)
+	Err      error          // Parse error, for a supported type that failed to parse
+	Warnings []ParseWarning // Non-fatal parse warnings
 }
 
 // ExtractMermaidFromMarkdown extracts all ```mermaid blocks from Markdown content
@@ -179,51 +177,37 @@ func ExtractMermaidFromMarkdown(markdown string) ([]MermaidBlock, error) {
 
 	inMermaidBlock := false
 	var currentBlock []string
-	var blockType string
+	var blockLine int
 
-	for _, line := range lines {
+	for i, line := range lines {
 		trimmed := strings.TrimSpace(line)
 
 		// Check for start of mermaid block
 		if strings.HasPrefix(trimmed, "```mermaid") {
 			inMermaidBlock = true
 			currentBlock = []string{}
+			blockLine = i + 2
 			continue
 		}
 
 		// Check for end of code block
 		if inMermaidBlock && strings.HasPrefix(trimmed, "```") {
-			// Parse the accumulated mermaid code
 			content := strings.Join(currentBlock, "\n")
-
-			// Determine diagram type from first line
-			firstLine := strings.TrimSpace(currentBlock[0])
-			if strings.HasPrefix(firstLine, "graph ") || strings.HasPrefix(firstLine, "flowchart ") {
-				blockType = "flowchart"
-			} else if strings.HasPrefix(firstLine, "sequenceDiagram") {
-				blockType = "sequenceDiagram"
-			} else {
-				blockType = "unknown"
-			}
-
 			block := MermaidBlock{
-				Type:    blockType,
+				Type:    mermaidType(content),
 				Content: content,
+				Line:    blockLine,
 			}
 
-			// Try to parse the diagram
-			var diagram Diagram
-			var err error
-
-			switch blockType {
-			case "flowchart":
-				diagram, err = ParseMermaidFlowchart(content)
-			case "sequenceDiagram":
-				diagram, err = ParseMermaidSequence(content)
-			}
-
-			if err == nil && diagram != nil {
-				block.Diagram = diagram
+			// Parse supported diagrams, keeping any error with the block
+			if block.Type != "unknown" {
+				result, err := ParseMermaid(content)
+				if err != nil {
+					block.Err = err
+				} else {
+					block.Diagram = result.Diagram
+					block.Warnings = result.Warnings
+				}
 			}
 
 			blocks = append(blocks, block)
@@ -241,7 +225,9 @@ func ExtractMermaidFromMarkdown(markdown string) ([]MermaidBlock, error) {
 	return blocks, nil
 }
 
-// RenderMarkdownFile reads a Markdown file and renders all Mermaid diagrams
+// RenderMarkdownFile reads a Markdown file and renders all Mermaid diagrams.
+// Diagrams that fail to parse are reported and make it return an error once
+// the rest have been rendered.
 func RenderMarkdownFile(filename string) error {
 	// Read file
 	content, err := os.ReadFile(filename)
@@ -261,12 +247,22 @@ func RenderMarkdownFile(filename string) error {
 	}
 
 	// Render each diagram
+	failed := 0
 	for i, block := range blocks {
 		fmt.Printf("\n=== Diagram %d (%s) ===\n\n", i+1, block.Type)
 
-		if block.Diagram != nil {
-			fmt.Println(block.Diagram.Render())
-		} else {
+		switch {
+		case block.Diagram != nil:
+			if err := writeDiagram(os.Stdout, block.Diagram); err != nil {
+				return err
+			}
+			for _, w := range block.Warnings {
+				fmt.Printf("Warning: %s:%d: %s\n", filename, block.Line+w.Line-1, w.Message)
+			}
+		case block.Err != nil:
+			failed++
+			fmt.Printf("Error: %s\n", blockError(filename, block))
+		default:
 			fmt.Printf("Unable to parse %s diagram\n", block.Type)
 			fmt.Println("Content:")
 			fmt.Println(block.Content)
@@ -274,10 +270,23 @@ func RenderMarkdownFile(filename string) error {
 		fmt.Println()
 	}
 
+	if failed > 0 {
+		return fmt.Errorf("%d of %d diagrams failed to parse", failed, len(blocks))
+	}
 	return nil
 }
 
-// ParseMermaidFromFile is a convenience function to extract and parse diagrams from a file
+// blockError describes a block's parse error with its line in the Markdown file
+func blockError(filename string, block MermaidBlock) error {
+	var perr *ParseError
+	if errors.As(block.Err, &perr) {
+		return fmt.Errorf("%s:%d:%d: %s\n\t%s", filename, block.Line+perr.Line-1, perr.Column, perr.message(), perr.Snippet)
+	}
+	return fmt.Errorf("%s:%d: %w", filename, block.Line, block.Err)
+}
+
+// ParseMermaidFromFile is a convenience function to extract and parse diagrams
+// from a file. It fails on the first diagram with a parse error.
 func ParseMermaidFromFile(filename string) ([]Diagram, error) {
 	content, err := os.ReadFile(filename)
 	if err != nil {
@@ -291,6 +300,9 @@ func ParseMermaidFromFile(filename string) ([]Diagram, error) {
 
 	var diagrams []Diagram
 	for _, block := range blocks {
+		if block.Err != nil {
+			return nil, blockError(filename, block)
+		}
 		if block.Diagram != nil {
 			diagrams = append(diagrams, block.Diagram)
 		}
@@ -306,24 +318,11 @@ func ParseMmdFile(filename string) (Diagram, error) {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	mermaidText := string(content)
-	lines := strings.Split(mermaidText, "\n")
-	if len(lines) == 0 {
-		return nil, fmt.Errorf("empty file")
-	}
-
-	firstLine := strings.TrimSpace(lines[0])
-
-	// Detect diagram type from first line
-	if strings.HasPrefix(firstLine, "graph ") || strings.HasPrefix(firstLine, "flowchart ") {
-		return ParseMermaidFlowchart(mermaidText)
-	}
-
-	if strings.HasPrefix(firstLine, "sequenceDiagram") {
-		return ParseMermaidSequence(mermaidText)
+	result, err := ParseMermaid(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
 	}
-
-	return nil, fmt.Errorf("unsupported mermaid diagram type: %s", firstLine)
+	return result.Diagram, nil
 }
 
 // RenderMmdFile reads and renders a standalone .mmd file
@@ -333,6 +332,21 @@ func RenderMmdFile(filename string) error {
 		return err
 	}
 
-	fmt.Println(diagram.Render())
+	return writeDiagram(os.Stdout, diagram)
+}
+
+// writeDiagram streams a diagram to w in the colours w supports, ending it
+// with a newline
+func writeDiagram(w io.Writer, diagram Diagram) error {
+	d, ok := diagram.(SizedDiagram)
+	if !ok {
+		d = diagramCanvas(diagram, RenderOptions{})
+	}
+	if _, err := d.RenderTo(w, RenderOptions{ColorProfile: DetectColorProfile(w)}); err != nil {
+		return fmt.Errorf("failed to write diagram: %w", err)
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return fmt.Errorf("failed to write diagram: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,230 @@
+package diagrams
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// tokenKind identifies the kind of a Mermaid token
+type tokenKind int
+
+const (
+	tokEOF     tokenKind = iota
+	tokNewline           // End of a line, or ; between flowchart statements
+	tokWord              // Identifier or keyword
+	tokShape             // Node shape with its label, such as [Label] or ((Label))
+	tokLink              // Flowchart link, such as -->, -.-> or -- text -->
+	tokPipe              // Link label between pipes: |label|
+	tokAmp               // & between the nodes of a group
+	tokClass             // ::: before a class name
+	tokArrow             // Sequence message arrow, such as ->> or -->>
+	tokColon             // : before message text
+	tokComma             // , between participants
+	tokText              // Free text up to the end of the line
+	tokInvalid           // Anything else, including unterminated labels
+)
+
+// tokenNames describes each kind of token in parse errors
+var tokenNames = map[tokenKind]string{
+	tokEOF:     "end of input",
+	tokNewline: "end of line",
+	tokWord:    "identifier",
+	tokShape:   "node shape",
+	tokLink:    "link",
+	tokPipe:    "link label",
+	tokAmp:     `"&"`,
+	tokClass:   `":::"`,
+	tokArrow:   "arrow",
+	tokColon:   `":"`,
+	tokComma:   `","`,
+	tokText:    "text",
+}
+
+// token is a lexeme of Mermaid source with its position
+type token struct {
+	kind   tokenKind
+	text   string // Source text of the token
+	offset int    // Byte offset in the source
+	line   int    // 1-based line
+	col    int    // 1-based column, in runes
+}
+
+// lexMode selects the tokens recognised for each diagram type
+type lexMode int
+
+const (
+	lexFlowchart lexMode = iota
+	lexSequence
+)
+
+var (
+	wordRegex = regexp.MustCompile(`^[\pL\pN_]+`)
+
+	// A flowchart link: solid (---), dotted (-.-), thick (===) or invisible
+	// (~~~), with <, >, o or x markers and optional text in the middle
+	// (-- text -->). The parser rejects links too short to be complete, like --.
+	flowLinkRegex = regexp.MustCompile(`^([<ox])?(?:(--|==|-\.)[ \t]+([^\n]+?)[ \t]+(-{2,}|={2,}|\.+-)|(-{2,}|={2,}|-\.+-|~{3,}))([>ox])?`)
+
+	// A sequence message arrow: ->, -->, ->>, -->>, -x, --x, -), --) and the
+	// bidirectional <<->> and <<-->>, with a + or - that activates the target
+	// or deactivates the source
+	seqArrowRegex = regexp.MustCompile(`^(?:<<)?--?(?:>>|>|x|\))[+-]?`)
+)
+
+// lexer splits Mermaid source into tokens on demand. The parser drives it,
+// switching to free text where the grammar expects it (after a colon, for
+// example), because Mermaid's syntax depends on context.
+type lexer struct {
+	src       string
+	mode      lexMode
+	pos       int // Byte offset of the next token
+	line      int
+	lineStart int // Byte offset where the current line starts
+}
+
+func newLexer(src string, mode lexMode) *lexer {
+	return &lexer{src: src, mode: mode, line: 1}
+}
+
+// tokenAt builds a token from src[start:end] on the current line
+func (lx *lexer) tokenAt(kind tokenKind, start, end int) token {
+	return token{
+		kind:   kind,
+		text:   lx.src[start:end],
+		offset: start,
+		line:   lx.line,
+		col:    utf8.RuneCountInString(lx.src[lx.lineStart:start]) + 1,
+	}
+}
+
+// atLineStart reports whether only blanks precede pos on its line
+func (lx *lexer) atLineStart() bool {
+	return strings.TrimSpace(lx.src[lx.lineStart:lx.pos]) == ""
+}
+
+// next returns the next token. Comment lines (%%) are skipped.
+func (lx *lexer) next() token {
+	for {
+		for lx.pos < len(lx.src) && strings.IndexByte(" \t\r", lx.src[lx.pos]) >= 0 {
+			lx.pos++
+		}
+		if strings.HasPrefix(lx.src[lx.pos:], "%%") && lx.atLineStart() {
+			lx.skipToEOL()
+			continue
+		}
+		break
+	}
+
+	start := lx.pos
+	if start >= len(lx.src) {
+		return lx.tokenAt(tokEOF, start, start)
+	}
+	rest := lx.src[start:]
+
+	emit := func(kind tokenKind, n int) token {
+		t := lx.tokenAt(kind, start, start+n)
+		lx.pos = start + n
+		return t
+	}
+
+	switch c := rest[0]; {
+	case c == '\n':
+		t := emit(tokNewline, 1)
+		lx.line++
+		lx.lineStart = lx.pos
+		return t
+	case c == ',':
+		return emit(tokComma, 1)
+	case lx.mode == lexFlowchart:
+		return lx.nextFlowchart(rest, emit)
+	}
+
+	if m := seqArrowRegex.FindString(rest); m != "" {
+		return emit(tokArrow, len(m))
+	}
+	if w := wordRegex.FindString(rest); w != "" {
+		return emit(tokWord, len(w))
+	}
+	if rest[0] == ':' {
+		return emit(tokColon, 1)
+	}
+	_, size := utf8.DecodeRuneInString(rest)
+	return emit(tokInvalid, size)
+}
+
+// nextFlowchart lexes the tokens that only appear in flowcharts
+func (lx *lexer) nextFlowchart(rest string, emit func(tokenKind, int) token) token {
+	// A link may start with an o or x marker, so try it before a word
+	if m := flowLinkRegex.FindString(rest); m != "" {
+		return emit(tokLink, len(m))
+	}
+	if w := wordRegex.FindString(rest); w != "" {
+		return emit(tokWord, len(w))
+	}
+
+	switch rest[0] {
+	case ';':
+		return emit(tokNewline, 1)
+	case '&':
+		return emit(tokAmp, 1)
+	case '|':
+		// A quoted label may contain pipes: |"a | b"|
+		closing, from := "|", 1
+		if strings.HasPrefix(rest, `|"`) {
+			closing, from = `"|`, 2
+		}
+		if end := strings.Index(rest[from:], closing); end >= 0 && !strings.Contains(rest[from:from+end], "\n") {
+			return emit(tokPipe, from+end+len(closing))
+		}
+		return emit(tokInvalid, 1)
+	case ':':
+		if strings.HasPrefix(rest, ":::") {
+			return emit(tokClass, 3)
+		}
+	case '[', '(', '{', '>':
+		line := rest
+		if end := strings.IndexByte(line, '\n'); end >= 0 {
+			line = line[:end]
+		}
+		// Only the longest opening delimiter counts, so [(DB] is not a box
+		opened := ""
+		for _, ns := range nodeShapes {
+			if !strings.HasPrefix(line, ns.open) || len(ns.open) < len(opened) {
+				continue
+			}
+			opened = ns.open
+			if m := ns.pattern.FindString(line); m != "" {
+				return emit(tokShape, len(m))
+			}
+		}
+		if opened != "" {
+			return emit(tokInvalid, len(opened))
+		}
+	}
+	_, size := utf8.DecodeRuneInString(rest)
+	return emit(tokInvalid, size)
+}
+
+// textFrom returns the text from offset, which must be on the current line,
+// to the end of the line as a single trimmed tokText token, and continues
+// lexing from the end of the line
+func (lx *lexer) textFrom(offset int) token {
+	lx.pos = offset
+	lx.skipToEOL()
+	t := lx.tokenAt(tokText, offset, lx.pos)
+	trimmed := strings.TrimLeft(t.text, " \t")
+	t.col += len(t.text) - len(trimmed)
+	t.offset += len(t.text) - len(trimmed)
+	t.text = strings.TrimRight(trimmed, " \t\r")
+	return t
+}
+
+// skipToEOL moves to the newline ending the current line, or the end of input
+func (lx *lexer) skipToEOL() {
+	if end := strings.IndexByte(lx.src[lx.pos:], '\n'); end >= 0 {
+		lx.pos += end
+	} else {
+		lx.pos = len(lx.src)
+	}
+}
@@ -0,0 +1,69 @@
+package diagrams
+
+import "testing"
+
+func lexAll(src string, mode lexMode) []token {
+	lx := newLexer(src, mode)
+	var tokens []token
+	for {
+		t := lx.next()
+		if t.kind == tokEOF {
+			return tokens
+		}
+		tokens = append(tokens, t)
+	}
+}
+
+func TestLexer_Flowchart(t *testing.T) {
+	tokens := lexAll("graph LR\n  %% comment\n  A[Start] -- go --> B & C:::hot;\n  B -.->|x| oC", lexFlowchart)
+
+	expected := []struct {
+		kind tokenKind
+		text string
+	}{
+		{tokWord, "graph"}, {tokWord, "LR"}, {tokNewline, "\n"},
+		{tokNewline, "\n"},
+		{tokWord, "A"}, {tokShape, "[Start]"}, {tokLink, "-- go -->"}, {tokWord, "B"},
+		{tokAmp, "&"}, {tokWord, "C"}, {tokClass, ":::"}, {tokWord, "hot"}, {tokNewline, ";"},
+		{tokNewline, "\n"},
+		{tokWord, "B"}, {tokLink, "-.->"}, {tokPipe, "|x|"}, {tokWord, "oC"},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d: %v", len(expected), len(tokens), tokens)
+	}
+	for i, e := range expected {
+		if tokens[i].kind != e.kind || tokens[i].text != e.text {
+			t.Errorf("Token %d: expected %q (%d), got %q (%d)", i, e.text, e.kind, tokens[i].text, tokens[i].kind)
+		}
+	}
+}
+
+func TestLexer_Positions(t *testing.T) {
+	tokens := lexAll("graph TD\n  Ä --> B[(DB]", lexFlowchart)
+
+	// Columns count runes, so B follows the two-byte Ä at column 9
+	last := tokens[5]
+	if last.text != "B" || last.line != 2 || last.col != 9 {
+		t.Errorf("Expected B at 2:9, got %q at %d:%d", last.text, last.line, last.col)
+	}
+
+	// An unterminated shape is reported by its opening delimiter
+	open := tokens[6]
+	if open.kind != tokInvalid || open.text != "[(" || open.col != 10 {
+		t.Errorf("Expected an invalid [( at column 10, got %q (%d) at column %d", open.text, open.kind, open.col)
+	}
+}
+
+func TestLexer_Sequence(t *testing.T) {
+	tokens := lexAll("A->>B: Hi\nB--)A: ok\nA<<-->>B: sync", lexSequence)
+
+	var arrows []string
+	for _, tok := range tokens {
+		if tok.kind == tokArrow {
+			arrows = append(arrows, tok.text)
+		}
+	}
+	if len(arrows) != 3 || arrows[0] != "->>" || arrows[1] != "--)" || arrows[2] != "<<-->>" {
+		t.Errorf("Expected arrows ->>, --) and <<-->>, got %v", arrows)
+	}
+}
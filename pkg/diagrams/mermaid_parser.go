@@ -0,0 +1,1104 @@
+package diagrams
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ParseError describes a syntax error in Mermaid source
+type ParseError struct {
+	Line     int      // 1-based line of the error
+	Column   int      // 1-based column of the error, in characters
+	Snippet  string   // The source line containing the error
+	Expected []string // What the parser expected at this point
+	Found    string   // What it found instead
+	Message  string   // Description of the error, when more specific than Expected and Found
+}
+
+// Error formats the error as "line L, column C: message"
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.message())
+}
+
+// message describes the error without its position
+func (e *ParseError) message() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return fmt.Sprintf("expected %s, found %s", orList(e.Expected), e.Found)
+}
+
+// ParseWarning is a non-fatal problem found while parsing, such as an
+// unsupported statement that was skipped
+type ParseWarning struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+// String formats the warning as "line L, column C: message"
+func (w ParseWarning) String() string {
+	return fmt.Sprintf("line %d, column %d: %s", w.Line, w.Column, w.Message)
+}
+
+// orList joins alternatives as "a, b or c"
+func orList(items []string) string {
+	if len(items) < 2 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " or " + items[len(items)-1]
+}
+
+// nodeShapes maps Mermaid node delimiters to shapes. Longer delimiters come
+// first, so that ((( is not read as ((; a label runs to the first closing
+// delimiter and cannot contain its final bracket unless it is quoted.
+var nodeShapes = []struct {
+	shape       NodeShape
+	open, close string
+	pattern     *regexp.Regexp
+}{
+	{ShapeDoubleCircle, "(((", ")))", nil},
+	{ShapeCircle, "((", "))", nil},
+	{ShapeStadium, "([", "])", nil},
+	{ShapeSubroutine, "[[", "]]", nil},
+	{ShapeCylinder, "[(", ")]", nil},
+	{ShapeHexagon, "{{", "}}", nil},
+	{ShapeParallelogram, "[/", "/]", nil},
+	{ShapeParallelogramAlt, `[\`, `\]`, nil},
+	{ShapeTrapezoid, "[/", `\]`, nil},
+	{ShapeTrapezoidAlt, `[\`, "/]", nil},
+	{ShapeAsymmetric, ">", "]", nil},
+	{ShapeBox, "[", "]", nil},
+	{ShapeRounded, "(", ")", nil},
+	{ShapeDiamond, "{", "}", nil},
+}
+
+func init() {
+	for i := range nodeShapes {
+		ns := &nodeShapes[i]
+		bracket := regexp.QuoteMeta(ns.close[len(ns.close)-1:])
+		ns.pattern = regexp.MustCompile(`^` + regexp.QuoteMeta(ns.open) + `("[^"\n]*"|[^` + bracket + `\n]+?)` + regexp.QuoteMeta(ns.close))
+	}
+}
+
+// decodeShape returns the shape and raw label of a tokShape token
+func decodeShape(text string) (NodeShape, string) {
+	for _, ns := range nodeShapes {
+		if match := ns.pattern.FindStringSubmatch(text); match != nil {
+			return ns.shape, match[1]
+		}
+	}
+	return ShapeBox, text
+}
+
+var (
+	breakRegex  = regexp.MustCompile(`(?i)<br\s*/?>`)
+	entityRegex = regexp.MustCompile(`#([A-Za-z]+|[0-9]+);`)
+)
+
+// decodeLabel decodes a Mermaid label. Surrounding quotes are removed, <br>
+// becomes a newline and entity codes such as #quot; or #9829; become the
+// characters they name. A label quoted with backticks ("`...`") is a Markdown
+// string, which is reported by the second result.
+func decodeLabel(raw string) (string, bool) {
+	label, markdown := strings.TrimSpace(raw), false
+	if len(label) >= 2 && strings.HasPrefix(label, `"`) && strings.HasSuffix(label, `"`) {
+		label = label[1 : len(label)-1]
+		if len(label) >= 2 && strings.HasPrefix(label, "`") && strings.HasSuffix(label, "`") {
+			label, markdown = label[1:len(label)-1], true
+		}
+	}
+
+	label = breakRegex.ReplaceAllString(label, "\n")
+	label = entityRegex.ReplaceAllStringFunc(label, func(code string) string {
+		name := code[1 : len(code)-1]
+		if name[0] >= '0' && name[0] <= '9' {
+			name = "#" + name
+		}
+		if char := html.UnescapeString("&" + name + ";"); !strings.HasPrefix(char, "&") || name == "amp" {
+			return char
+		}
+		return code
+	})
+	return label, markdown
+}
+
+// decodeText decodes a label that is drawn on a single line without
+// formatting, such as an edge label or a message
+func decodeText(raw string) string {
+	return strings.ReplaceAll(decodePlain(raw), "\n", " ")
+}
+
+// decodePlain decodes a label that is drawn without formatting, such as a
+// note, keeping its line breaks
+func decodePlain(raw string) string {
+	label, markdown := decodeLabel(raw)
+	lines := strings.Split(label, "\n")
+	if markdown {
+		for i, line := range lines {
+			lines[i] = markdownRuns(line).Plain()
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parser is a recursive-descent parser over the tokens of a lexer
+type parser struct {
+	lx       *lexer
+	tok      token // Current token
+	lines    []string
+	warnings []ParseWarning
+}
+
+func newParser(src string, mode lexMode) *parser {
+	p := &parser{lx: newLexer(src, mode), lines: strings.Split(src, "\n")}
+	p.advance()
+	return p
+}
+
+func (p *parser) advance() {
+	p.tok = p.lx.next()
+}
+
+// describe names a token for the Found part of a ParseError
+func describe(t token) string {
+	switch t.kind {
+	case tokEOF, tokNewline:
+		return tokenNames[t.kind]
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// errorAt returns an error at token t listing what was expected instead
+func (p *parser) errorAt(t token, expected ...string) *ParseError {
+	return &ParseError{
+		Line:     t.line,
+		Column:   t.col,
+		Snippet:  strings.TrimRight(p.lines[t.line-1], "\r"),
+		Expected: expected,
+		Found:    describe(t),
+	}
+}
+
+func (p *parser) warn(t token, format string, args ...interface{}) {
+	p.warnings = append(p.warnings, warningAt(t, format, args...))
+}
+
+// warningAt returns a warning at token t
+func warningAt(t token, format string, args ...interface{}) ParseWarning {
+	return ParseWarning{Line: t.line, Column: t.col, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) skipNewlines() {
+	for p.tok.kind == tokNewline {
+		p.advance()
+	}
+}
+
+// restOfLine returns the text from the current token to the end of the line
+// and moves to the token after it
+func (p *parser) restOfLine() token {
+	if p.tok.kind == tokNewline || p.tok.kind == tokEOF {
+		t := p.tok
+		t.kind, t.text = tokText, ""
+		return t
+	}
+	t := p.lx.textFrom(p.tok.offset)
+	p.advance()
+	return t
+}
+
+// endStatement checks that the current statement ends here; expected lists
+// what else could have continued it
+func (p *parser) endStatement(expected ...string) error {
+	if p.tok.kind == tokNewline || p.tok.kind == tokEOF {
+		return nil
+	}
+	return p.errorAt(p.tok, append(expected, "end of line")...)
+}
+
+// parseHeader skips blank lines and reads the diagram type keyword, which
+// must be one of keywords
+func (p *parser) parseHeader(keywords ...string) error {
+	p.skipNewlines()
+	for _, k := range keywords {
+		if p.tok.kind == tokWord && p.tok.text == k {
+			p.advance()
+			return nil
+		}
+	}
+	quoted := make([]string, len(keywords))
+	for i, k := range keywords {
+		quoted[i] = fmt.Sprintf("%q", k)
+	}
+	return p.errorAt(p.tok, quoted...)
+}
+
+// expectDirection reads a direction keyword such as TD or LR
+func (p *parser) expectDirection() (Direction, error) {
+	if p.tok.kind == tokWord {
+		if d, ok := parseDirection(p.tok.text); ok {
+			p.advance()
+			return d, nil
+		}
+	}
+	return TopToBottom, p.errorAt(p.tok, `"TB"`, `"TD"`, `"BT"`, `"LR"`, `"RL"`)
+}
+
+// flowchartAST is a parsed Mermaid flowchart
+type flowchartAST struct {
+	direction Direction
+	stmts     []flowStmt
+}
+
+// flowStmt is a flowchart statement: a *chainStmt, *subgraphStmt or *directionStmt
+type flowStmt interface {
+	flowStmt()
+}
+
+// nodeRef is a node as it appears in a statement, with its shape if one is given
+type nodeRef struct {
+	at       token // The ID's token
+	id       string
+	label    string
+	markdown bool
+	shape    NodeShape
+	hasShape bool
+}
+
+// linkRef is a link between two groups of nodes
+type linkRef struct {
+	label    string
+	style    EdgeStyle
+	fromHead ArrowHead
+	toHead   ArrowHead
+}
+
+// chainStmt is a node statement: groups of nodes joined by & and separated by
+// links, such as A & B --> C --> D. A single group declares its nodes.
+type chainStmt struct {
+	groups [][]nodeRef
+	links  []linkRef // links[i] joins groups[i] to groups[i+1]
+}
+
+// subgraphStmt is a subgraph ... end block
+type subgraphStmt struct {
+	id, title string
+	stmts     []flowStmt
+}
+
+// directionStmt sets the direction of the flowchart or enclosing subgraph
+type directionStmt struct {
+	direction Direction
+}
+
+func (*chainStmt) flowStmt()     {}
+func (*subgraphStmt) flowStmt()  {}
+func (*directionStmt) flowStmt() {}
+
+// unsupportedFlowKeywords start flowchart statements that are skipped with a warning
+var unsupportedFlowKeywords = map[string]bool{
+	"classDef": true, "class": true, "style": true, "linkStyle": true,
+	"click": true, "callback": true, "accTitle": true, "accDescr": true,
+}
+
+// parseFlowchartAST parses Mermaid flowchart source
+func parseFlowchartAST(src string) (*flowchartAST, []ParseWarning, error) {
+	p := newParser(src, lexFlowchart)
+	if err := p.parseHeader("graph", "flowchart"); err != nil {
+		return nil, nil, err
+	}
+
+	ast := &flowchartAST{direction: TopToBottom}
+	if p.tok.kind == tokWord {
+		d, err := p.expectDirection()
+		if err != nil {
+			return nil, nil, err
+		}
+		ast.direction = d
+	}
+	if err := p.endStatement("direction"); err != nil {
+		return nil, nil, err
+	}
+
+	stmts, err := p.parseFlowStmts(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	ast.stmts = stmts
+	return ast, p.warnings, nil
+}
+
+// parseFlowStmts parses statements up to the end of input or, inside the
+// subgraph opened at open, up to its end keyword
+func (p *parser) parseFlowStmts(open *token) ([]flowStmt, error) {
+	var stmts []flowStmt
+	for {
+		p.skipNewlines()
+		switch {
+		case p.tok.kind == tokEOF && open != nil:
+			err := p.errorAt(*open)
+			err.Message = `subgraph is never closed, expected "end"`
+			return nil, err
+		case p.tok.kind == tokEOF:
+			return stmts, nil
+		case p.tok.kind == tokWord && p.tok.text == "end":
+			if open == nil {
+				err := p.errorAt(p.tok)
+				err.Message = `"end" without an open subgraph`
+				return nil, err
+			}
+			p.advance()
+			return stmts, p.endStatement()
+		}
+
+		stmt, err := p.parseFlowStmt()
+		if err != nil {
+			return nil, err
+		}
+		if stmt != nil {
+			stmts = append(stmts, stmt)
+		}
+	}
+}
+
+// parseFlowStmt parses one statement; skipped statements return nil
+func (p *parser) parseFlowStmt() (flowStmt, error) {
+	t := p.tok
+	if t.kind == tokWord {
+		switch {
+		case t.text == "subgraph":
+			return p.parseSubgraph()
+		case t.text == "direction":
+			p.advance()
+			d, err := p.expectDirection()
+			if err != nil {
+				return nil, err
+			}
+			return &directionStmt{direction: d}, p.endStatement()
+		case unsupportedFlowKeywords[t.text]:
+			p.warn(t, "unsupported statement %q ignored", t.text)
+			p.restOfLine()
+			return nil, nil
+		}
+	}
+	return p.parseChain()
+}
+
+// parseSubgraph parses a subgraph header, its statements and its end keyword
+func (p *parser) parseSubgraph() (flowStmt, error) {
+	open := p.tok
+	p.advance()
+	header := p.restOfLine()
+	if header.text == "" {
+		return nil, p.errorAt(header, "subgraph ID or title")
+	}
+	sub := parseSubgraphHeader(header.text)
+
+	stmts, err := p.parseFlowStmts(&open)
+	if err != nil {
+		return nil, err
+	}
+	return &subgraphStmt{id: sub.ID, title: sub.Title, stmts: stmts}, nil
+}
+
+// parseChain parses groups of nodes separated by links
+func (p *parser) parseChain() (flowStmt, error) {
+	chain := &chainStmt{}
+	for {
+		group, err := p.parseGroup()
+		if err != nil {
+			return nil, err
+		}
+		chain.groups = append(chain.groups, group)
+		if p.tok.kind != tokLink {
+			break
+		}
+		link, err := p.parseLinkRef()
+		if err != nil {
+			return nil, err
+		}
+		chain.links = append(chain.links, link)
+	}
+	return chain, p.endStatement("link", `"&"`)
+}
+
+// parseGroup parses nodes joined by &
+func (p *parser) parseGroup() ([]nodeRef, error) {
+	var group []nodeRef
+	for {
+		node, err := p.parseNodeRef()
+		if err != nil {
+			return nil, err
+		}
+		group = append(group, node)
+		if p.tok.kind != tokAmp {
+			return group, nil
+		}
+		p.advance()
+	}
+}
+
+// parseNodeRef parses a node ID with an optional shape and class shorthand
+func (p *parser) parseNodeRef() (nodeRef, error) {
+	t := p.tok
+	if t.kind != tokWord {
+		return nodeRef{}, p.errorAt(t, "node ID")
+	}
+	p.advance()
+	node := nodeRef{at: t, id: t.text, label: t.text, shape: ShapeBox}
+
+	switch p.tok.kind {
+	case tokShape:
+		shape, label := decodeShape(p.tok.text)
+		node.shape, node.hasShape = shape, true
+		node.label, node.markdown = decodeLabel(label)
+		p.advance()
+	case tokInvalid:
+		for _, ns := range nodeShapes {
+			if p.tok.text == ns.open {
+				err := p.errorAt(p.tok, fmt.Sprintf("%q", ns.close))
+				err.Message = fmt.Sprintf("node label opened with %q is never closed, expected %q", ns.open, ns.close)
+				return nodeRef{}, err
+			}
+		}
+	}
+
+	if p.tok.kind == tokClass {
+		p.warn(p.tok, "class shorthand on node %q ignored", node.id)
+		p.advance()
+		if p.tok.kind != tokWord {
+			return nodeRef{}, p.errorAt(p.tok, "class name")
+		}
+		p.advance()
+	}
+	return node, nil
+}
+
+// parseLinkRef parses a link and an optional |label| after it
+func (p *parser) parseLinkRef() (linkRef, error) {
+	t := p.tok
+	m := flowLinkRegex.FindStringSubmatch(t.text)
+	start, line, closing, head := m[1], m[5], m[5], m[6]
+	link := linkRef{label: decodeText(m[3])}
+	if m[2] != "" {
+		line, closing = m[2]+m[4], m[4]
+	}
+
+	// Without an arrowhead a solid or thick link needs a third character
+	if head == "" && (closing == "--" || closing == "==") {
+		err := p.errorAt(t, fmt.Sprintf("%q", closing+">"), fmt.Sprintf("%q", closing+closing[:1]))
+		err.Message = fmt.Sprintf("incomplete link %q, expected an arrowhead or a third %q", t.text, closing[:1])
+		return linkRef{}, err
+	}
+	link.style, link.fromHead, link.toHead = parseLink(start, line, head)
+	p.advance()
+
+	if p.tok.kind == tokPipe {
+		link.label = decodeText(p.tok.text[1 : len(p.tok.text)-1])
+		p.advance()
+	}
+	return link, nil
+}
+
+// flowchart converts the AST to a Flowchart. A node takes the label and shape
+// of its first definition and belongs to the first subgraph it appears in.
+// Subgraph IDs name the subgraph wherever they appear: listed on its own in
+// another subgraph, a subgraph is nested there, and links to it connect to
+// its frame. Links between a subgraph and its own members are dropped.
+func (ast *flowchartAST) flowchart() (*Flowchart, []ParseWarning) {
+	flow := NewFlowchart(ast.direction)
+	defined := make(map[string]bool)
+	linked := make(map[string]bool)
+	grouped := make(map[string]bool)
+	var bare []string // Nodes that appear on their own, without a shape
+	var warnings []ParseWarning
+
+	subgraphs := make(map[string]*Subgraph)
+	var collect func(stmts []flowStmt)
+	collect = func(stmts []flowStmt) {
+		for _, stmt := range stmts {
+			if s, ok := stmt.(*subgraphStmt); ok {
+				if subgraphs[s.id] == nil {
+					subgraphs[s.id] = &Subgraph{ID: s.id, Title: s.title}
+				}
+				collect(s.stmts)
+			}
+		}
+	}
+	collect(ast.stmts)
+
+	// Subgraphs listed inside later ones, nested once every subgraph exists
+	type nesting struct {
+		at     token
+		child  string
+		parent *Subgraph
+	}
+	var nested []nesting
+	var edgeAt []token // Where each edge's target appears
+	placed := make(map[*Subgraph]bool)
+
+	var walk func(stmts []flowStmt, sub *Subgraph)
+	walk = func(stmts []flowStmt, sub *Subgraph) {
+		group := func(id string) {
+			if sub != nil && !grouped[id] {
+				sub.Nodes = append(sub.Nodes, id)
+				grouped[id] = true
+			}
+		}
+
+		for _, stmt := range stmts {
+			switch s := stmt.(type) {
+			case *directionStmt:
+				if sub != nil {
+					sub.SetDirection(s.direction)
+				} else {
+					flow.Direction = s.direction
+				}
+			case *subgraphStmt:
+				// A repeated ID starts another subgraph, which links can't name
+				child := subgraphs[s.id]
+				if placed[child] {
+					child = &Subgraph{ID: s.id, Title: s.title}
+				}
+				placed[child] = true
+				if sub != nil {
+					sub.Subgraphs = append(sub.Subgraphs, child)
+				} else {
+					flow.Subgraphs = append(flow.Subgraphs, child)
+				}
+				grouped[s.id] = true
+				walk(s.stmts, child)
+			case *chainStmt:
+				for _, nodes := range s.groups {
+					for _, n := range nodes {
+						if subgraphs[n.id] != nil {
+							if n.hasShape {
+								warnings = append(warnings, warningAt(n.at, "node %q shares its ID with a subgraph and is drawn as the subgraph", n.id))
+							}
+							if sub != nil && len(s.links) == 0 {
+								nested = append(nested, nesting{n.at, n.id, sub})
+							}
+							continue
+						}
+						if n.hasShape && !defined[n.id] {
+							flow.Nodes = append(flow.Nodes, Node{ID: n.id, Label: n.label, Shape: n.shape, Markdown: n.markdown})
+							defined[n.id] = true
+						} else if !n.hasShape && len(s.links) == 0 {
+							bare = append(bare, n.id)
+						}
+						group(n.id)
+					}
+				}
+				for i, link := range s.links {
+					for _, from := range s.groups[i] {
+						for _, to := range s.groups[i+1] {
+							flow.AddStyledEdge(from.id, to.id, link.label, link.style, link.fromHead, link.toHead)
+							edgeAt = append(edgeAt, to.at)
+							linked[from.id], linked[to.id] = true, true
+						}
+					}
+				}
+			}
+		}
+	}
+	walk(ast.stmts, nil)
+
+	for _, n := range nested {
+		child := subgraphs[n.child]
+		if child == n.parent || subgraphMembers(child)[n.parent.ID] {
+			warnings = append(warnings, warningAt(n.at, "subgraph %q can't be nested inside itself", n.child))
+			continue
+		}
+		flow.Subgraphs = detachSubgraph(flow.Subgraphs, child)
+		n.parent.Subgraphs = append(n.parent.Subgraphs, child)
+	}
+
+	// A link between a subgraph and its own members would be drawn as a loop
+	contains := func(id, member string) bool {
+		sub := subgraphs[id]
+		return sub != nil && (id == member || subgraphMembers(sub)[member])
+	}
+	edges := flow.Edges[:0]
+	for i, e := range flow.Edges {
+		if contains(e.From, e.To) || contains(e.To, e.From) {
+			warnings = append(warnings, warningAt(edgeAt[i], "link between %q and %q ignored, one is inside the other", e.From, e.To))
+			continue
+		}
+		edges = append(edges, e)
+	}
+	flow.Edges = edges
+
+	// A node declared only by its ID is drawn as a box labelled with the ID
+	for _, id := range bare {
+		if !defined[id] && !linked[id] {
+			flow.AddNode(id, id, ShapeBox)
+			defined[id] = true
+		}
+	}
+	return flow, warnings
+}
+
+// detachSubgraph removes sub from subs and the subgraphs nested in them
+func detachSubgraph(subs []*Subgraph, sub *Subgraph) []*Subgraph {
+	kept := subs[:0]
+	for _, s := range subs {
+		if s != sub {
+			s.Subgraphs = detachSubgraph(s.Subgraphs, sub)
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// sequenceAST is a parsed Mermaid sequence diagram
+type sequenceAST struct {
+	stmts []seqStmt
+}
+
+// seqStmt is a sequence diagram statement: a *participantStmt, *messageStmt,
+// *noteStmt, *activationStmt, *autonumberStmt or *fragmentStmt
+type seqStmt interface {
+	seqStmt()
+}
+
+// participantStmt declares a participant, with an optional display name
+type participantStmt struct {
+	id, name string
+}
+
+// messageStmt is a message between two participants
+type messageStmt struct {
+	from, to   string
+	arrow      string
+	activation string // + to activate the target, - to deactivate the source
+	text       string
+}
+
+// noteStmt is a note beside or over participants
+type noteStmt struct {
+	position NotePosition
+	actors   []string
+	text     string
+}
+
+// activationStmt activates or deactivates a participant
+type activationStmt struct {
+	id     string
+	active bool
+}
+
+// autonumberStmt numbers the messages that follow, or stops numbering them if
+// off. A start or step of 0 keeps the previous one, as in Mermaid.
+type autonumberStmt struct {
+	off         bool
+	start, step int
+}
+
+// fragmentStmt is a block such as a loop, with the statements of each of its
+// sections
+type fragmentStmt struct {
+	typ      FragmentType
+	color    string // Background of a rect
+	sections []fragmentSectionStmt
+}
+
+// fragmentSectionStmt is a section of a fragment, up to the next else, and,
+// option or end
+type fragmentSectionStmt struct {
+	label string
+	stmts []seqStmt
+}
+
+func (*participantStmt) seqStmt() {}
+func (*messageStmt) seqStmt()     {}
+func (*noteStmt) seqStmt()        {}
+func (*activationStmt) seqStmt()  {}
+func (*autonumberStmt) seqStmt()  {}
+func (*fragmentStmt) seqStmt()    {}
+
+// unsupportedSeqKeywords start sequence statements that are skipped with a
+// warning, compared in lower case
+var unsupportedSeqKeywords = map[string]bool{
+	"title": true, "box": true,
+	"create": true, "destroy": true, "link": true, "links": true, "properties": true,
+	"details": true, "acctitle": true, "accdescr": true,
+}
+
+// parseSequenceAST parses Mermaid sequence diagram source
+func parseSequenceAST(src string) (*sequenceAST, []ParseWarning, error) {
+	p := newParser(src, lexSequence)
+	if err := p.parseHeader("sequenceDiagram"); err != nil {
+		return nil, nil, err
+	}
+	if err := p.endStatement(); err != nil {
+		return nil, nil, err
+	}
+
+	stmts, _, err := p.parseSeqStmts(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &sequenceAST{stmts: stmts}, p.warnings, nil
+}
+
+// parseSeqStmts parses statements up to the end of input or, inside the
+// fragment opened at open, up to the end or section keyword that closes the
+// section, which is returned
+func (p *parser) parseSeqStmts(open *token) ([]seqStmt, token, error) {
+	var stmts []seqStmt
+	for {
+		p.skipNewlines()
+		t := p.tok
+		switch {
+		case t.kind == tokEOF && open != nil:
+			err := p.errorAt(*open)
+			err.Message = fmt.Sprintf(`%s is never closed, expected "end"`, strings.ToLower(open.text))
+			return nil, t, err
+		case t.kind == tokEOF:
+			return stmts, t, nil
+		case t.kind != tokWord:
+			return nil, t, p.errorAt(t, "participant", "message")
+		}
+		p.advance()
+
+		// Keywords can also be participant IDs, which are followed by an arrow
+		if keyword := strings.ToLower(t.text); p.tok.kind != tokArrow && (keyword == "end" || isSectionKeyword(keyword)) {
+			if open == nil {
+				return nil, t, p.blockError(t)
+			}
+			return stmts, t, nil
+		}
+
+		stmt, err := p.parseSeqStmt(t)
+		if err != nil {
+			return nil, t, err
+		}
+		if stmt != nil {
+			stmts = append(stmts, stmt)
+		}
+	}
+}
+
+// parseAutonumber parses what follows autonumber: off, or an optional first
+// number and step. A first number without a step counts in ones.
+func (p *parser) parseAutonumber() (seqStmt, error) {
+	if p.tok.kind == tokWord && strings.EqualFold(p.tok.text, "off") {
+		p.advance()
+		return &autonumberStmt{off: true}, p.endStatement()
+	}
+
+	stmt := &autonumberStmt{}
+	for i, n := range []*int{&stmt.start, &stmt.step} {
+		if p.tok.kind != tokWord {
+			break
+		}
+		value, err := strconv.Atoi(p.tok.text)
+		if err != nil {
+			return nil, p.errorAt(p.tok, "number")
+		}
+		if i == 0 {
+			stmt.step = 1
+		}
+		*n = value
+		p.advance()
+	}
+	return stmt, p.endStatement("number")
+}
+
+// fragmentTypes maps the keywords that open fragments to their types
+var fragmentTypes = map[string]FragmentType{
+	"loop": FragmentLoop, "alt": FragmentAlt, "opt": FragmentOpt, "par": FragmentPar,
+	"critical": FragmentCritical, "break": FragmentBreak, "rect": FragmentRect,
+}
+
+// sectionFragments maps the keywords that start sections to the types of
+// fragment they belong to
+var sectionFragments = map[string]FragmentType{"else": FragmentAlt, "and": FragmentPar, "option": FragmentCritical}
+
+// isSectionKeyword reports whether keyword starts a section of a fragment
+func isSectionKeyword(keyword string) bool {
+	_, ok := sectionFragments[keyword]
+	return ok
+}
+
+// blockError returns the error for an end or section keyword outside a
+// fragment that it can close
+func (p *parser) blockError(t token) *ParseError {
+	err := p.errorAt(t)
+	if typ, ok := sectionFragments[strings.ToLower(t.text)]; ok {
+		err.Message = fmt.Sprintf("%q without an open %s", t.text, fragmentKeywords[typ])
+	} else {
+		err.Message = fmt.Sprintf("%q without an open block", t.text)
+	}
+	return err
+}
+
+// parseSeqStmt parses one statement that starts with the word t; skipped
+// statements return nil
+func (p *parser) parseSeqStmt(t token) (seqStmt, error) {
+	keyword := strings.ToLower(t.text)
+	typ, fragment := fragmentTypes[keyword]
+	switch {
+	case p.tok.kind == tokArrow:
+		return p.parseMessage(t)
+	case t.text == "participant" || t.text == "actor":
+		return p.parseParticipant()
+	case keyword == "note":
+		return p.parseNote()
+	case keyword == "activate" || keyword == "deactivate":
+		id := p.tok
+		if id.kind != tokWord {
+			return nil, p.errorAt(id, "participant ID")
+		}
+		p.advance()
+		return &activationStmt{id: id.text, active: keyword == "activate"}, p.endStatement()
+	case keyword == "autonumber":
+		return p.parseAutonumber()
+	case fragment:
+		return p.parseFragment(t, typ)
+	case unsupportedSeqKeywords[keyword]:
+		p.warn(t, "unsupported statement %q ignored", t.text)
+		p.restOfLine()
+		return nil, nil
+	}
+	return nil, p.errorAt(p.tok, "arrow")
+}
+
+// parseParticipant parses what follows participant or actor: an ID and an
+// optional "as" with a display name
+func (p *parser) parseParticipant() (seqStmt, error) {
+	id := p.tok
+	if id.kind != tokWord {
+		return nil, p.errorAt(id, "participant ID")
+	}
+	p.advance()
+	stmt := &participantStmt{id: id.text, name: id.text}
+
+	if p.tok.kind == tokWord && p.tok.text == "as" {
+		p.advance()
+		name := p.restOfLine()
+		if name.text == "" {
+			return nil, p.errorAt(name, "participant name")
+		}
+		stmt.name = decodeText(name.text)
+	}
+	return stmt, p.endStatement(`"as"`)
+}
+
+// parseNote parses what follows note: its position, the participants it is
+// placed against and its text
+func (p *parser) parseNote() (seqStmt, error) {
+	stmt := &noteStmt{}
+	switch pos := strings.ToLower(p.tok.text); {
+	case p.tok.kind == tokWord && pos == "over":
+		stmt.position = NoteOver
+		p.advance()
+	case p.tok.kind == tokWord && (pos == "left" || pos == "right"):
+		stmt.position = NoteRightOf
+		if pos == "left" {
+			stmt.position = NoteLeftOf
+		}
+		p.advance()
+		if p.tok.kind != tokWord || p.tok.text != "of" {
+			return nil, p.errorAt(p.tok, `"of"`)
+		}
+		p.advance()
+	default:
+		return nil, p.errorAt(p.tok, `"left of"`, `"right of"`, `"over"`)
+	}
+
+	for {
+		if p.tok.kind != tokWord {
+			return nil, p.errorAt(p.tok, "participant ID")
+		}
+		stmt.actors = append(stmt.actors, p.tok.text)
+		p.advance()
+		if p.tok.kind != tokComma {
+			break
+		}
+		p.advance()
+	}
+	if p.tok.kind != tokColon {
+		return nil, p.errorAt(p.tok, `","`, `":"`)
+	}
+	p.advance()
+	stmt.text = decodePlain(p.restOfLine().text)
+	return stmt, nil
+}
+
+// rectColorRegex matches the colours of rect blocks, such as rgb(200, 220, 255)
+// or rgba(0, 0, 255, 0.1), whose alpha is ignored
+var rectColorRegex = regexp.MustCompile(`^rgba?\(\s*(\d{1,3})\s*,\s*(\d{1,3})\s*,\s*(\d{1,3})\s*(,\s*[\d.]+\s*)?\)$`)
+
+// parseFragment parses a fragment opened by the keyword open: its label, or a
+// rect's colour, and its sections up to its end keyword
+func (p *parser) parseFragment(open token, typ FragmentType) (seqStmt, error) {
+	stmt := &fragmentStmt{typ: typ}
+	header := p.restOfLine()
+	label := decodeText(header.text)
+	if typ == FragmentRect {
+		stmt.color, label = p.rectColor(header), ""
+	}
+
+	for {
+		stmts, closing, err := p.parseSeqStmts(&open)
+		if err != nil {
+			return nil, err
+		}
+		stmt.sections = append(stmt.sections, fragmentSectionStmt{label: label, stmts: stmts})
+
+		keyword := strings.ToLower(closing.text)
+		if keyword == "end" {
+			return stmt, p.endStatement()
+		}
+		if keyword != sectionKeywords[typ] {
+			return nil, p.blockError(closing)
+		}
+		label = decodeText(p.restOfLine().text)
+	}
+}
+
+// rectColor returns the background escape for the colour of a rect, or none
+// with a warning if it isn't an rgb() or rgba() colour
+func (p *parser) rectColor(header token) string {
+	text := strings.TrimSpace(header.text)
+	m := rectColorRegex.FindStringSubmatch(text)
+	var rgb [3]uint8
+	for i := 0; m != nil && i < 3; i++ {
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil || n > 255 {
+			m = nil
+			break
+		}
+		rgb[i] = uint8(n)
+	}
+	if m == nil {
+		if text != "" {
+			p.warn(header, "unsupported rect colour %q ignored", text)
+		}
+		return ""
+	}
+	return RGB(rgb[0], rgb[1], rgb[2]).Bg()
+}
+
+// parseMessage parses the arrow, target and text of a message from the participant from
+func (p *parser) parseMessage(from token) (seqStmt, error) {
+	arrow := p.tok
+	p.advance()
+	activation := ""
+	if n := len(arrow.text); arrow.text[n-1] == '+' || arrow.text[n-1] == '-' {
+		activation, arrow.text = arrow.text[n-1:], arrow.text[:n-1]
+	}
+	switch {
+	case strings.HasPrefix(arrow.text, "<<"):
+		p.warn(arrow, "bidirectional arrow %q drawn in one direction", arrow.text)
+	case strings.HasSuffix(arrow.text, "x"):
+		p.warn(arrow, "cross arrowhead %q drawn as a plain arrow", arrow.text)
+	}
+
+	to := p.tok
+	if to.kind != tokWord {
+		return nil, p.errorAt(to, "participant ID")
+	}
+	p.advance()
+	if p.tok.kind != tokColon {
+		return nil, p.errorAt(p.tok, `":"`)
+	}
+	p.advance()
+	text := p.restOfLine()
+
+	return &messageStmt{from: from.text, to: to.text, arrow: arrow.text, activation: activation, text: decodeText(text.text)}, nil
+}
+
+// messageType maps a Mermaid arrow to a message type: solid arrows are
+// synchronous calls, dotted ones returns and open (-)) ones asynchronous
+func messageType(arrow string) MessageType {
+	arrow = strings.TrimPrefix(arrow, "<<")
+	switch {
+	case strings.HasSuffix(arrow, ")"):
+		return MessageAsync
+	case strings.HasPrefix(arrow, "--"):
+		return MessageReturn
+	}
+	return MessageSync
+}
+
+// sequence converts the AST to a SequenceDiagram. Participants are added in
+// order of their first appearance. As in Mermaid, every message counts
+// towards the numbering, even while it is off, so autonumber without a start
+// carries on from the count.
+func (ast *sequenceAST) sequence() *SequenceDiagram {
+	seq := NewSequenceDiagram()
+	number, step := 1, 1 // Number and step of the next message
+	actors := make(map[string]bool)
+	addActor := func(id, name string) {
+		if !actors[id] {
+			seq.AddActor(id, name)
+			actors[id] = true
+		}
+	}
+
+	var add func(stmts []seqStmt)
+	add = func(stmts []seqStmt) {
+		for _, stmt := range stmts {
+			switch s := stmt.(type) {
+			case *participantStmt:
+				addActor(s.id, s.name)
+			case *messageStmt:
+				addActor(s.from, s.from)
+				addActor(s.to, s.to)
+				seq.AddMessage(s.from, s.to, s.text, messageType(s.arrow))
+				number += step
+				switch s.activation {
+				case "+":
+					seq.Activate(s.to)
+				case "-":
+					seq.Deactivate(s.from)
+				}
+			case *autonumberStmt:
+				if s.start != 0 {
+					number = s.start
+				}
+				if s.step != 0 {
+					step = s.step
+				}
+				if s.off {
+					seq.StopAutonumber()
+				} else {
+					seq.SetAutonumber(number, step)
+				}
+			case *activationStmt:
+				addActor(s.id, s.id)
+				if s.active {
+					seq.Activate(s.id)
+				} else {
+					seq.Deactivate(s.id)
+				}
+			case *noteStmt:
+				for _, id := range s.actors {
+					addActor(id, id)
+				}
+				seq.AddNote(s.position, s.text, s.actors...)
+			case *fragmentStmt:
+				if s.typ == FragmentRect {
+					seq.StartRect(s.color)
+				} else {
+					seq.StartFragment(s.typ, s.sections[0].label)
+				}
+				for i, section := range s.sections {
+					if i > 0 {
+						seq.AddSection(section.label)
+					}
+					add(section.stmts)
+				}
+				seq.EndFragment()
+			}
+		}
+	}
+	add(ast.stmts)
+	return seq
+}
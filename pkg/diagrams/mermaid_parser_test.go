@@ -0,0 +1,245 @@
+package diagrams
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMermaid_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		line    int
+		column  int
+		message string
+	}{
+		{"missing header", "A --> B", 1, 1, `expected "graph", "flowchart" or "sequenceDiagram", found "A"`},
+		{"bad direction", "graph XY\nA --> B", 1, 7, `expected "TB", "TD", "BT", "LR" or "RL", found "XY"`},
+		{"missing target", "graph TD\n  A -->", 2, 8, "expected node ID, found end of input"},
+		{"incomplete link", "graph TD\n  A -- B", 2, 5, `incomplete link "--", expected an arrowhead or a third "-"`},
+		{"unterminated label", "graph TD\n  A[Start --> B", 2, 4, `node label opened with "[" is never closed, expected "]"`},
+		{"trailing garbage", "graph TD\n  A --> B C", 2, 11, `expected link, "&" or end of line, found "C"`},
+		{"stray end", "graph TD\n  A --> B\nend", 3, 1, `"end" without an open subgraph`},
+		{"unclosed subgraph", "graph TD\n  subgraph api\n    A --> B", 2, 3, `subgraph is never closed, expected "end"`},
+		{"missing colon", "sequenceDiagram\n  A->>B Hello", 2, 9, `expected ":", found "Hello"`},
+		{"missing arrow", "sequenceDiagram\n  A B: Hello", 2, 5, `expected arrow, found "B"`},
+		{"bad arrow", "sequenceDiagram\n  A=>>B: Hello", 2, 4, `expected arrow, found "="`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseMermaid(tt.input)
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("Expected a *ParseError, got %v", err)
+			}
+			if perr.Line != tt.line || perr.Column != tt.column {
+				t.Errorf("Expected the error at %d:%d, got %d:%d", tt.line, tt.column, perr.Line, perr.Column)
+			}
+			if !strings.HasSuffix(err.Error(), tt.message) {
+				t.Errorf("Expected message %q, got %q", tt.message, err.Error())
+			}
+			if perr.Snippet != strings.Split(tt.input, "\n")[tt.line-1] {
+				t.Errorf("Expected the snippet to be line %d, got %q", tt.line, perr.Snippet)
+			}
+		})
+	}
+}
+
+func TestParseError_Error(t *testing.T) {
+	err := &ParseError{Line: 3, Column: 7, Expected: []string{"node ID"}, Found: `"-->"`}
+	if got := err.Error(); got != `line 3, column 7: expected node ID, found "-->"` {
+		t.Errorf("Unexpected error text: %s", got)
+	}
+}
+
+func TestParseMermaid_Warnings(t *testing.T) {
+	result, err := ParseMermaid(`graph TD
+    A[Start] --> B:::hot
+    classDef hot fill:#f96
+    click A "https://example.com"`)
+	if err != nil {
+		t.Fatalf("ParseMermaid failed: %v", err)
+	}
+
+	flow := result.Diagram.(*Flowchart)
+	if len(flow.Nodes) != 1 || len(flow.Edges) != 1 {
+		t.Errorf("Expected 1 node and 1 edge, got %d and %d", len(flow.Nodes), len(flow.Edges))
+	}
+
+	expected := []ParseWarning{
+		{Line: 2, Column: 19, Message: `class shorthand on node "B" ignored`},
+		{Line: 3, Column: 5, Message: `unsupported statement "classDef" ignored`},
+		{Line: 4, Column: 5, Message: `unsupported statement "click" ignored`},
+	}
+	if len(result.Warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, got %v", len(expected), result.Warnings)
+	}
+	for i, w := range expected {
+		if result.Warnings[i] != w {
+			t.Errorf("Expected warning %v, got %v", w, result.Warnings[i])
+		}
+	}
+}
+
+func TestParseMermaid_SequenceArrows(t *testing.T) {
+	result, err := ParseMermaid(`sequenceDiagram
+    %% Every arrow style
+    A->>B: sync
+    B-->>A: return
+    A->B: open
+    B-->A: dotted open
+    A-)B: async
+    A--)B: async dotted
+    A-xB: lost
+    Note over A,B: drawn
+    loop Every minute
+      A->>B: ping
+    end`)
+	if err != nil {
+		t.Fatalf("ParseMermaid failed: %v", err)
+	}
+
+	seq := result.Diagram.(*SequenceDiagram)
+	expected := []MessageType{
+		MessageSync, MessageReturn, MessageSync, MessageReturn,
+		MessageAsync, MessageAsync, MessageSync, MessageSync,
+	}
+	if len(seq.Messages) != len(expected) {
+		t.Fatalf("Expected %d messages, got %d", len(expected), len(seq.Messages))
+	}
+	for i, typ := range expected {
+		if seq.Messages[i].Type != typ {
+			t.Errorf("Message %d (%s): expected type %v, got %v", i, seq.Messages[i].Label, typ, seq.Messages[i].Type)
+		}
+	}
+
+	// Only the cross arrowhead warns
+	if len(result.Warnings) != 1 {
+		t.Errorf("Expected 1 warning, got %v", result.Warnings)
+	}
+}
+
+func TestParseMermaidSequence_Autonumber(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []Autonumber
+	}{
+		{"autonumber", []Autonumber{{Start: 1, Step: 1}}},
+		{"autonumber 10", []Autonumber{{Start: 10, Step: 1}}},
+		{"autonumber 10 5", []Autonumber{{Start: 10, Step: 5}}},
+		{"autonumber\n    autonumber off", []Autonumber{{Start: 1, Step: 1}, {Off: true}}},
+	}
+
+	for _, tt := range tests {
+		seq, err := ParseMermaidSequence("sequenceDiagram\n    " + tt.src + "\n    A->>B: call")
+		if err != nil {
+			t.Errorf("%q: ParseMermaidSequence failed: %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(seq.Autonumbers, tt.want) {
+			t.Errorf("%q: expected %+v, got %+v", tt.src, tt.want, seq.Autonumbers)
+		}
+		if parsed, err := ParseMermaidSequence(seq.ToMermaid()); err != nil || !reflect.DeepEqual(parsed.Autonumbers, tt.want) {
+			t.Errorf("%q: round trip changed the numbering:\n%s", tt.src, seq.ToMermaid())
+		}
+	}
+
+	if _, err := ParseMermaidSequence("sequenceDiagram\n    autonumber first"); err == nil {
+		t.Error("Expected an error for a start that isn't a number")
+	}
+}
+
+func TestParseMermaidSequence_AutonumberOff(t *testing.T) {
+	mermaid := `sequenceDiagram
+    autonumber
+    A->>B: one
+    Note over A: wait
+    autonumber off
+    A->>B: two
+    autonumber
+    A->>B: three
+    autonumber 10 5
+    loop
+        A->>B: four
+        autonumber off
+    end
+    A->>B: five
+`
+	seq, err := ParseMermaidSequence(mermaid)
+	if err != nil {
+		t.Fatalf("ParseMermaidSequence failed: %v", err)
+	}
+
+	// Numbering applies from where it appears; the count goes on while it is off
+	want := []Autonumber{{Start: 1, Step: 1}, {At: 2, Off: true}, {Start: 3, Step: 1, At: 3}, {Start: 10, Step: 5, At: 4}, {At: 5, Off: true}}
+	if !reflect.DeepEqual(seq.Autonumbers, want) {
+		t.Errorf("Expected %+v, got %+v", want, seq.Autonumbers)
+	}
+	var labels []string
+	for _, msg := range seq.numbered().Messages {
+		labels = append(labels, msg.Label)
+	}
+	if expected := []string{"1. one", "two", "3. three", "10. four", "five"}; !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Expected labels %q, got %q", expected, labels)
+	}
+
+	parsed, err := ParseMermaidSequence(seq.ToMermaid())
+	if err != nil || !reflect.DeepEqual(parsed.Autonumbers, want) {
+		t.Errorf("Round trip changed the numbering:\n%s", seq.ToMermaid())
+	}
+}
+
+func TestParseMermaid_KeywordParticipant(t *testing.T) {
+	result, err := ParseMermaid("sequenceDiagram\n    Box->>Note: keywords as IDs")
+	if err != nil {
+		t.Fatalf("ParseMermaid failed: %v", err)
+	}
+	if seq := result.Diagram.(*SequenceDiagram); len(seq.Messages) != 1 || len(result.Warnings) != 0 {
+		t.Errorf("Expected a message between Box and Note, got %d messages and warnings %v", len(seq.Messages), result.Warnings)
+	}
+}
+
+func TestExtractMermaidFromMarkdown_ParseError(t *testing.T) {
+	markdown := "# Doc\n\n```mermaid\ngraph TD\n    A[Start --> B\n```\n"
+
+	blocks, err := ExtractMermaidFromMarkdown(markdown)
+	if err != nil {
+		t.Fatalf("ExtractMermaidFromMarkdown failed: %v", err)
+	}
+	if len(blocks) != 1 || blocks[0].Err == nil || blocks[0].Diagram != nil {
+		t.Fatalf("Expected one block with a parse error, got %+v", blocks)
+	}
+	if blocks[0].Line != 4 {
+		t.Errorf("Expected the block to start on line 4, got %d", blocks[0].Line)
+	}
+	if got := blockError("doc.md", blocks[0]).Error(); !strings.HasPrefix(got, "doc.md:5:6: ") {
+		t.Errorf("Expected the error at doc.md:5:6, got %q", got)
+	}
+}
+
+func TestDecodeLabel(t *testing.T) {
+	tests := []struct {
+		raw      string
+		label    string
+		markdown bool
+	}{
+		{"Plain", "Plain", false},
+		{`"Label with ] bracket"`, "Label with ] bracket", false},
+		{`"Line one<br/>line two"`, "Line one\nline two", false},
+		{"a<br>b<BR />c", "a\nb\nc", false},
+		{"Say #quot;hi#quot; #amp; #9829;", `Say "hi" & ♥`, false},
+		{"Issue #35;12 #nosuchentity;", "Issue #12 #nosuchentity;", false},
+		{"\"`**bold** text`\"", "**bold** text", true},
+	}
+
+	for _, tt := range tests {
+		label, markdown := decodeLabel(tt.raw)
+		if label != tt.label || markdown != tt.markdown {
+			t.Errorf("decodeLabel(%q): expected %q (markdown %v), got %q (markdown %v)",
+				tt.raw, tt.label, tt.markdown, label, markdown)
+		}
+	}
+}
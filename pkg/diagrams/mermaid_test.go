@@ -1,6 +1,7 @@
 package diagrams
 
 import (
+	"bytes"
 	"strings"
 	"testing"
 )
@@ -46,34 +47,60 @@ func TestParseMermaidFlowchart_NodeShapes(t *testing.T) {
     A[Box]
     B(Rounded)
     C{Diamond}
-    D((Circle))`
+    D((Circle))
+    E([Stadium])
+    F[[Subroutine]]
+    G[(Database)]
+    H>Flag]
+    I{{Hexagon}}
+    J[/Input/]
+    K[\Output\]
+    L[/Trapezoid\]
+    M[\Inverted/]
+    N(((Stop)))`
 
 	flow, err := ParseMermaidFlowchart(mermaid)
 	if err != nil {
 		t.Fatalf("ParseMermaidFlowchart failed: %v", err)
 	}
 
-	if len(flow.Nodes) != 4 {
-		t.Fatalf("Expected 4 nodes, got %d", len(flow.Nodes))
+	if len(flow.Nodes) != 14 {
+		t.Fatalf("Expected 14 nodes, got %d", len(flow.Nodes))
 	}
 
-	// Check shapes
-	shapes := map[string]NodeShape{
-		"A": ShapeBox,
-		"B": ShapeRounded,
-		"C": ShapeDiamond,
-		"D": ShapeCircle,
+	// Check shapes and labels
+	shapes := map[string]struct {
+		shape NodeShape
+		label string
+	}{
+		"A": {ShapeBox, "Box"},
+		"B": {ShapeRounded, "Rounded"},
+		"C": {ShapeDiamond, "Diamond"},
+		"D": {ShapeCircle, "Circle"},
+		"E": {ShapeStadium, "Stadium"},
+		"F": {ShapeSubroutine, "Subroutine"},
+		"G": {ShapeCylinder, "Database"},
+		"H": {ShapeAsymmetric, "Flag"},
+		"I": {ShapeHexagon, "Hexagon"},
+		"J": {ShapeParallelogram, "Input"},
+		"K": {ShapeParallelogramAlt, "Output"},
+		"L": {ShapeTrapezoid, "Trapezoid"},
+		"M": {ShapeTrapezoidAlt, "Inverted"},
+		"N": {ShapeDoubleCircle, "Stop"},
 	}
 
 	for _, node := range flow.Nodes {
-		expectedShape, ok := shapes[node.ID]
+		expected, ok := shapes[node.ID]
 		if !ok {
 			t.Errorf("Unexpected node ID: %s", node.ID)
 			continue
 		}
 
-		if node.Shape != expectedShape {
-			t.Errorf("Node %s: expected shape %v, got %v", node.ID, expectedShape, node.Shape)
+		if node.Shape != expected.shape {
+			t.Errorf("Node %s: expected shape %v, got %v", node.ID, expected.shape, node.Shape)
+		}
+		if node.Label != expected.label {
+			t.Errorf("Node %s: expected label %q, got %q", node.ID, expected.label, node.Label)
 		}
 	}
 }
@@ -103,6 +130,279 @@ func TestParseMermaidFlowchart_EdgeLabels(t *testing.T) {
 	}
 }
 
+func TestParseMermaidFlowchart_EdgeStyles(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected Edge
+	}{
+		{"A --> B", Edge{From: "A", To: "B", ToHead: HeadArrow}},
+		{"A --- B", Edge{From: "A", To: "B"}},
+		{"A -.-> B", Edge{From: "A", To: "B", Style: EdgeDotted, ToHead: HeadArrow}},
+		{"A -.- B", Edge{From: "A", To: "B", Style: EdgeDotted}},
+		{"A ==> B", Edge{From: "A", To: "B", Style: EdgeThick, ToHead: HeadArrow}},
+		{"A ~~~ B", Edge{From: "A", To: "B", Style: EdgeInvisible}},
+		{"A <--> B", Edge{From: "A", To: "B", FromHead: HeadArrow, ToHead: HeadArrow}},
+		{"A --o B", Edge{From: "A", To: "B", ToHead: HeadCircle}},
+		{"A x--x B", Edge{From: "A", To: "B", FromHead: HeadCross, ToHead: HeadCross}},
+		{"A ---->|long| B", Edge{From: "A", To: "B", Label: "long", ToHead: HeadArrow}},
+		{"A -- text --> B", Edge{From: "A", To: "B", Label: "text", ToHead: HeadArrow}},
+		{"A -. maybe .-> B", Edge{From: "A", To: "B", Label: "maybe", Style: EdgeDotted, ToHead: HeadArrow}},
+		{"A == two words ==> B", Edge{From: "A", To: "B", Label: "two words", Style: EdgeThick, ToHead: HeadArrow}},
+		{"box --- xray", Edge{From: "box", To: "xray"}},
+	}
+
+	for _, tt := range tests {
+		flow, err := ParseMermaidFlowchart("graph TD\n" + tt.line)
+		if err != nil {
+			t.Fatalf("ParseMermaidFlowchart failed: %v", err)
+		}
+		if len(flow.Edges) != 1 {
+			t.Errorf("%s: expected 1 edge, got %d", tt.line, len(flow.Edges))
+			continue
+		}
+		if flow.Edges[0] != tt.expected {
+			t.Errorf("%s: expected %+v, got %+v", tt.line, tt.expected, flow.Edges[0])
+		}
+	}
+}
+
+func TestParseMermaidFlowchart_ChainedEdges(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected []Edge
+	}{
+		{
+			"chain",
+			"A --> B --> C",
+			[]Edge{
+				{From: "A", To: "B", ToHead: HeadArrow},
+				{From: "B", To: "C", ToHead: HeadArrow},
+			},
+		},
+		{
+			"groups",
+			"A & B --> C & D",
+			[]Edge{
+				{From: "A", To: "C", ToHead: HeadArrow},
+				{From: "A", To: "D", ToHead: HeadArrow},
+				{From: "B", To: "C", ToHead: HeadArrow},
+				{From: "B", To: "D", ToHead: HeadArrow},
+			},
+		},
+		{
+			"mixed shapes and labels",
+			"A[Start] & B(Retry) -->|go| C{Check?} -. no .-> D((Stop)) --- E",
+			[]Edge{
+				{From: "A", To: "C", Label: "go", ToHead: HeadArrow},
+				{From: "B", To: "C", Label: "go", ToHead: HeadArrow},
+				{From: "C", To: "D", Label: "no", Style: EdgeDotted, ToHead: HeadArrow},
+				{From: "D", To: "E"},
+			},
+		},
+		{
+			"statements separated by semicolons",
+			"A --> B; B --> C & D;",
+			[]Edge{
+				{From: "A", To: "B", ToHead: HeadArrow},
+				{From: "B", To: "C", ToHead: HeadArrow},
+				{From: "B", To: "D", ToHead: HeadArrow},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow, err := ParseMermaidFlowchart("graph TD\n" + tt.line)
+			if err != nil {
+				t.Fatalf("ParseMermaidFlowchart failed: %v", err)
+			}
+			if len(flow.Edges) != len(tt.expected) {
+				t.Fatalf("Expected %d edges, got %+v", len(tt.expected), flow.Edges)
+			}
+			for i, want := range tt.expected {
+				if flow.Edges[i] != want {
+					t.Errorf("Edge %d: expected %+v, got %+v", i, want, flow.Edges[i])
+				}
+			}
+		})
+	}
+
+	// Shapes given anywhere in the chain define the nodes
+	flow, _ := ParseMermaidFlowchart("graph TD\nA[Start] & B(Retry) -->|go| C{Check?} -. no .-> D((Stop))")
+	shapes := map[string]NodeShape{"A": ShapeBox, "B": ShapeRounded, "C": ShapeDiamond, "D": ShapeCircle}
+	if len(flow.Nodes) != len(shapes) {
+		t.Fatalf("Expected %d nodes, got %+v", len(shapes), flow.Nodes)
+	}
+	for _, node := range flow.Nodes {
+		if node.Shape != shapes[node.ID] {
+			t.Errorf("Node %s: expected shape %v, got %v", node.ID, shapes[node.ID], node.Shape)
+		}
+	}
+}
+
+func TestParseMermaidFlowchart_QuotedLabels(t *testing.T) {
+	mermaid := "graph TD\n" +
+		`    A["Label with ] bracket"] -->|"a | b"| B["Line one<br/>line two"]` + "\n" +
+		"    B -- #quot;yes#quot; --> C[\"`**bold** text`\"]\n" +
+		`    subgraph s ["Group<br>one"]` + "\n" +
+		"        C\n" +
+		"    end"
+
+	flow, err := ParseMermaidFlowchart(mermaid)
+	if err != nil {
+		t.Fatalf("ParseMermaidFlowchart failed: %v", err)
+	}
+
+	expected := []Node{
+		{ID: "A", Label: "Label with ] bracket", Shape: ShapeBox},
+		{ID: "B", Label: "Line one\nline two", Shape: ShapeBox},
+		{ID: "C", Label: "**bold** text", Shape: ShapeBox, Markdown: true},
+	}
+	if len(flow.Nodes) != len(expected) {
+		t.Fatalf("Expected %d nodes, got %d", len(expected), len(flow.Nodes))
+	}
+	for i, node := range expected {
+		if flow.Nodes[i] != node {
+			t.Errorf("Expected node %+v, got %+v", node, flow.Nodes[i])
+		}
+	}
+
+	// Edge labels and subgraph titles are drawn on one line
+	if flow.Edges[0].Label != "a | b" || flow.Edges[1].Label != `"yes"` {
+		t.Errorf("Expected edge labels %q and %q, got %q and %q", "a | b", `"yes"`, flow.Edges[0].Label, flow.Edges[1].Label)
+	}
+	if title := flow.Subgraphs[0].Title; title != "Group one" {
+		t.Errorf("Expected subgraph title %q, got %q", "Group one", title)
+	}
+}
+
+func TestParseMermaidFlowchart_Subgraphs(t *testing.T) {
+	mermaid := `flowchart TB
+    c1-->a2
+    subgraph one
+      a1-->a2
+    end
+    subgraph two [Second Group]
+      direction LR
+      b1-->b2
+      subgraph inner["Inner"]
+        b3
+      end
+    end
+    one --> two`
+
+	flow, err := ParseMermaidFlowchart(mermaid)
+	if err != nil {
+		t.Fatalf("ParseMermaidFlowchart failed: %v", err)
+	}
+
+	if len(flow.Subgraphs) != 2 {
+		t.Fatalf("Expected 2 subgraphs, got %d", len(flow.Subgraphs))
+	}
+
+	one, two := flow.Subgraphs[0], flow.Subgraphs[1]
+	if one.ID != "one" || one.Title != "one" || strings.Join(one.Nodes, ",") != "a1,a2" {
+		t.Errorf("Unexpected subgraph one: %+v", one)
+	}
+
+	if two.ID != "two" || two.Title != "Second Group" || strings.Join(two.Nodes, ",") != "b1,b2" {
+		t.Errorf("Unexpected subgraph two: %+v", two)
+	}
+	if two.Direction == nil || *two.Direction != LeftToRight {
+		t.Error("Expected subgraph two to override the direction")
+	}
+
+	if len(two.Subgraphs) != 1 || two.Subgraphs[0].Title != "Inner" || strings.Join(two.Subgraphs[0].Nodes, ",") != "b3" {
+		t.Errorf("Unexpected nested subgraph: %+v", two.Subgraphs)
+	}
+
+	// Edges between subgraphs are kept; the flowchart's direction is untouched
+	if len(flow.Edges) != 4 || flow.Direction != TopToBottom {
+		t.Errorf("Expected 4 edges and TopToBottom, got %d and %v", len(flow.Edges), flow.Direction)
+	}
+}
+
+func TestParseMermaid_LinkToEnclosingSubgraph(t *testing.T) {
+	result, err := ParseMermaid(`graph TD
+    subgraph s [Backend]
+      A --> s
+    end`)
+	if err != nil {
+		t.Fatalf("ParseMermaid failed: %v", err)
+	}
+
+	// The link would be drawn as a loop on A, so it's dropped
+	flow := result.Diagram.(*Flowchart)
+	if len(flow.Edges) != 0 {
+		t.Errorf("Expected no edges, got %+v", flow.Edges)
+	}
+	expected := ParseWarning{Line: 3, Column: 13, Message: `link between "A" and "s" ignored, one is inside the other`}
+	if len(result.Warnings) != 1 || result.Warnings[0] != expected {
+		t.Errorf("Expected warning %v, got %v", expected, result.Warnings)
+	}
+}
+
+func TestParseMermaid_NodeSharingSubgraphID(t *testing.T) {
+	result, err := ParseMermaid(`graph TD
+    X[Gateway] --> Z
+    subgraph X
+      Y
+    end`)
+	if err != nil {
+		t.Fatalf("ParseMermaid failed: %v", err)
+	}
+
+	// X names the subgraph, which keeps its frame and the link to Z
+	flow := result.Diagram.(*Flowchart)
+	if len(flow.Nodes) != 1 || flow.Nodes[0].ID != "Y" || len(flow.Subgraphs) != 1 {
+		t.Errorf("Expected node Y in subgraph X, got %+v and %+v", flow.Nodes, flow.Subgraphs)
+	}
+	if len(flow.Edges) != 1 || flow.Edges[0].From != "X" || flow.Edges[0].To != "Z" {
+		t.Errorf("Expected the edge X --> Z, got %+v", flow.Edges)
+	}
+	expected := ParseWarning{Line: 2, Column: 5, Message: `node "X" shares its ID with a subgraph and is drawn as the subgraph`}
+	if len(result.Warnings) != 1 || result.Warnings[0] != expected {
+		t.Errorf("Expected warning %v, got %v", expected, result.Warnings)
+	}
+
+	// The Mermaid written back parses to the same flowchart
+	again, err := ParseMermaidFlowchart(flow.ToMermaid())
+	if err != nil {
+		t.Fatalf("ParseMermaidFlowchart failed: %v", err)
+	}
+	if again.ToMermaid() != flow.ToMermaid() {
+		t.Errorf("Expected a round trip, got:\n%s\nthen:\n%s", flow.ToMermaid(), again.ToMermaid())
+	}
+}
+
+func TestParseMermaid_SubgraphListedInLaterSubgraph(t *testing.T) {
+	flow, err := ParseMermaidFlowchart(`graph TD
+    subgraph a
+      A
+    end
+    subgraph b
+      a
+      B
+    end`)
+	if err != nil {
+		t.Fatalf("ParseMermaidFlowchart failed: %v", err)
+	}
+
+	if len(flow.Subgraphs) != 1 || flow.Subgraphs[0].ID != "b" {
+		t.Fatalf("Expected only b at the top level, got %+v", flow.Subgraphs)
+	}
+	b := flow.Subgraphs[0]
+	if strings.Join(b.Nodes, ",") != "B" || len(b.Subgraphs) != 1 || b.Subgraphs[0].ID != "a" {
+		t.Errorf("Expected B and subgraph a in b, got %+v", b)
+	}
+	for _, n := range flow.Nodes {
+		if n.ID == "a" {
+			t.Error("Expected no node a")
+		}
+	}
+}
+
 func TestParseMermaidSequence(t *testing.T) {
 	mermaid := `sequenceDiagram
     Alice->>Bob: Hello
@@ -284,3 +584,24 @@ func TestParseMermaidSequence_Render(t *testing.T) {
 		t.Error("Expected output to contain 'Hello'")
 	}
 }
+
+func TestWriteDiagram_NotTerminal(t *testing.T) {
+	t.Setenv("FORCE_COLOR", "")
+	t.Setenv("CLICOLOR_FORCE", "")
+	seq, err := ParseMermaidSequence(`sequenceDiagram
+    rect rgb(200, 220, 255)
+    A->>B: Hello
+    end`)
+	if err != nil {
+		t.Fatalf("ParseMermaidSequence failed: %v", err)
+	}
+
+	// Files are rendered in the colours of their output, with none for a pipe
+	var b bytes.Buffer
+	if err := writeDiagram(&b, seq); err != nil {
+		t.Fatalf("writeDiagram failed: %v", err)
+	}
+	if strings.Contains(b.String(), "\x1b") || !strings.Contains(b.String(), "Hello") {
+		t.Errorf("Expected the diagram without escape sequences, got %q", b.String())
+	}
+}
@@ -0,0 +1,381 @@
+package diagrams
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// mermaidIndent indents the statements of serialized Mermaid diagrams
+const mermaidIndent = "    "
+
+// ToMermaid returns the flowchart as Mermaid text that ParseMermaidFlowchart
+// reads back into the same flowchart. Every node is declared with its shape
+// and label, in order, followed by the subgraphs listing their nodes and then
+// the edges. Labels are quoted when they need to be, with quotes, entity codes
+// and line breaks escaped. IDs Mermaid can't read, and keywords such as end,
+// are renamed.
+func (f *Flowchart) ToMermaid() string {
+	ids := newMermaidIDs(flowKeywords)
+	for _, n := range f.Nodes {
+		ids.reserve(n.ID, wordRegex)
+	}
+	for _, e := range f.Edges {
+		ids.reserve(e.From, wordRegex)
+		ids.reserve(e.To, wordRegex)
+	}
+	var reserveSubgraphs func(subs []*Subgraph)
+	reserveSubgraphs = func(subs []*Subgraph) {
+		for _, sub := range subs {
+			ids.reserve(sub.ID, subgraphIDRegex)
+			reserveSubgraphs(sub.Subgraphs)
+		}
+	}
+	reserveSubgraphs(f.Subgraphs)
+
+	// Subgraphs are named before the edges, which may link them
+	var subgraphs strings.Builder
+	for _, sub := range f.Subgraphs {
+		writeMermaidSubgraph(&subgraphs, sub, mermaidIndent, ids)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "graph %s\n", mermaidDirection(f.Direction))
+	for _, n := range f.Nodes {
+		open, close := mermaidShape(n.Shape)
+		fmt.Fprintf(&b, "%s%s%s%s%s\n", mermaidIndent, ids.id(n.ID, wordRegex), open, mermaidLabel(n.Label, n.Markdown), close)
+	}
+	b.WriteString(subgraphs.String())
+	for _, e := range f.Edges {
+		label := ""
+		if e.Label != "" {
+			label = "|" + mermaidLabel(e.Label, false) + "|"
+		}
+		fmt.Fprintf(&b, "%s%s %s%s %s\n", mermaidIndent, ids.id(e.From, wordRegex), mermaidLink(e), label, ids.id(e.To, wordRegex))
+	}
+	return b.String()
+}
+
+// writeMermaidSubgraph writes a subgraph block listing its nodes and nested
+// subgraphs
+func writeMermaidSubgraph(b *strings.Builder, sub *Subgraph, indent string, ids *mermaidIDs) {
+	id := ids.id(sub.ID, subgraphIDRegex)
+	if sub.Title == id {
+		fmt.Fprintf(b, "%ssubgraph %s\n", indent, id)
+	} else {
+		fmt.Fprintf(b, "%ssubgraph %s [%s]\n", indent, id, mermaidLabel(sub.Title, false))
+	}
+
+	inner := indent + mermaidIndent
+	if sub.Direction != nil {
+		fmt.Fprintf(b, "%sdirection %s\n", inner, mermaidDirection(*sub.Direction))
+	}
+	for _, node := range sub.Nodes {
+		fmt.Fprintf(b, "%s%s\n", inner, ids.id(node, wordRegex))
+	}
+	for _, child := range sub.Subgraphs {
+		writeMermaidSubgraph(b, child, inner, ids)
+	}
+	fmt.Fprintf(b, "%send\n", indent)
+}
+
+// mermaidDirection returns the Mermaid keyword for a direction
+func mermaidDirection(d Direction) string {
+	if d == LeftToRight {
+		return "LR"
+	}
+	return "TD"
+}
+
+// mermaidShape returns the delimiters of a node shape
+func mermaidShape(shape NodeShape) (string, string) {
+	for _, ns := range nodeShapes {
+		if ns.shape == shape {
+			return ns.open, ns.close
+		}
+	}
+	return "[", "]"
+}
+
+// mermaidLink returns the Mermaid link for an edge's style and markers
+func mermaidLink(e Edge) string {
+	if e.Style == EdgeInvisible {
+		return "~~~"
+	}
+	markers := map[ArrowHead][2]string{HeadArrow: {"<", ">"}, HeadCircle: {"o", "o"}, HeadCross: {"x", "x"}}
+	start, end := markers[e.FromHead][0], markers[e.ToHead][1]
+
+	// The line is one character longer when no marker ends it
+	line := map[EdgeStyle][2]string{
+		EdgeSolid:  {"---", "--"},
+		EdgeDotted: {"-.-", "-.-"},
+		EdgeThick:  {"===", "=="},
+	}[e.Style]
+	if end == "" {
+		return start + line[0]
+	}
+	return start + line[1] + end
+}
+
+// plainLabelRegex matches labels that need no quotes: words and punctuation
+// that can't be mistaken for a delimiter
+var plainLabelRegex = regexp.MustCompile(`^[\pL\pN_]([\pL\pN_ .,:!?'-]*[\pL\pN_.!?'])?$`)
+
+// mermaidLabel returns a label as it is written between delimiters, quoted
+// unless it is plain text
+func mermaidLabel(label string, markdown bool) string {
+	if markdown {
+		return "\"`" + escapeMermaid(label) + "`\""
+	}
+	if plainLabelRegex.MatchString(label) {
+		return label
+	}
+	return `"` + escapeMermaid(label) + `"`
+}
+
+// escapeMermaid escapes text so that decodeLabel reads it back unchanged:
+// quotes, and text that would be read as an entity code, <br> or a Markdown
+// label, are written as entity codes, and newlines as <br>
+func escapeMermaid(text string) string {
+	text = entityRegex.ReplaceAllStringFunc(text, func(code string) string { return "#35;" + code[1:] })
+	if strings.HasPrefix(text, "`") {
+		text = "#96;" + text[1:]
+	}
+	text = strings.ReplaceAll(text, `"`, "#quot;")
+	text = breakRegex.ReplaceAllStringFunc(text, func(br string) string { return "#lt;" + br[1:] })
+	return strings.ReplaceAll(text, "\n", "<br>")
+}
+
+// ToMermaid returns the sequence diagram as Mermaid text that
+// ParseMermaidSequence reads back into the same diagram: a participant
+// declaration for each actor, in order, then the messages and notes, with
+// fragments as indented blocks around them. Synchronous messages are written
+// ->>, asynchronous ones -) and returns -->>. An activation of a message's
+// target, or deactivation of its source, right after it is written as the
+// arrow's + or -, and other activations as statements. Autonumber statements
+// are written where they take effect. IDs Mermaid can't read, and keywords
+// such as end in any case, are renamed as for flowcharts.
+func (s *SequenceDiagram) ToMermaid() string {
+	ids := newMermaidIDs(seqKeywords)
+	ids.foldCase = true
+	for _, a := range s.Actors {
+		ids.reserve(a.ID, wordRegex)
+	}
+	for _, msg := range s.Messages {
+		ids.reserve(msg.From, wordRegex)
+		ids.reserve(msg.To, wordRegex)
+	}
+	for _, note := range s.Notes {
+		for _, id := range note.Actors {
+			ids.reserve(id, wordRegex)
+		}
+	}
+	for _, a := range s.Activations {
+		ids.reserve(a.Actor, wordRegex)
+	}
+
+	var b strings.Builder
+	b.WriteString("sequenceDiagram\n")
+	for _, a := range s.Actors {
+		id := ids.id(a.ID, wordRegex)
+		if a.Name == id || a.Name == "" {
+			fmt.Fprintf(&b, "%sparticipant %s\n", mermaidIndent, id)
+		} else {
+			fmt.Fprintf(&b, "%sparticipant %s as %s\n", mermaidIndent, id, mermaidText(a.Name))
+		}
+	}
+
+	arrows := map[MessageType]string{MessageSync: "->>", MessageAsync: "-)", MessageReturn: "-->>"}
+	positions := map[NotePosition]string{NoteRightOf: "right of", NoteLeftOf: "left of", NoteOver: "over"}
+	// Activations are written after the message or note before them
+	activations := s.activations()
+	writeActivations := func(at int, indent string) {
+		for ; len(activations) > 0 && activations[0].At <= at; activations = activations[1:] {
+			keyword := "deactivate"
+			if activations[0].Active {
+				keyword = "activate"
+			}
+			fmt.Fprintf(&b, "%s%s %s\n", indent, keyword, ids.id(activations[0].Actor, wordRegex))
+		}
+	}
+
+	// Mermaid counts every message, even while numbering is off, so an
+	// autonumber that carries on from the count is written without numbers
+	autonumbers := s.autonumbers()
+	number, step := 1, 1
+	writeAutonumbers := func(at int, indent string) {
+		for ; len(autonumbers) > 0 && autonumbers[0].At <= at; autonumbers = autonumbers[1:] {
+			n := autonumbers[0]
+			switch {
+			case n.Off:
+				fmt.Fprintf(&b, "%sautonumber off\n", indent)
+			case n.Start == number && n.Step == step:
+				fmt.Fprintf(&b, "%sautonumber\n", indent)
+			case n.Step == 1:
+				fmt.Fprintf(&b, "%sautonumber %d\n", indent, n.Start)
+			default:
+				fmt.Fprintf(&b, "%sautonumber %d %d\n", indent, n.Start, n.Step)
+			}
+			if !n.Off {
+				number, step = n.Start, n.Step
+			}
+		}
+	}
+
+	depth, at := 1, 0
+	writeAutonumbers(0, mermaidIndent)
+	writeActivations(0, mermaidIndent)
+	for _, st := range s.steps() {
+		indent := strings.Repeat(mermaidIndent, depth)
+		if f := st.fragment; f != nil {
+			switch st.section {
+			case 0:
+				fmt.Fprintf(&b, "%s%s\n", indent, mermaidFragment(f))
+				depth++
+			case -1:
+				depth--
+				fmt.Fprintf(&b, "%send\n", strings.Repeat(mermaidIndent, depth))
+			default:
+				// Sections of types without a keyword have no Mermaid form and are merged
+				if keyword, ok := sectionKeywords[f.Type]; ok {
+					fmt.Fprintf(&b, "%s%s\n", strings.Repeat(mermaidIndent, depth-1), strings.TrimSpace(keyword+" "+mermaidText(f.Sections[st.section].Label)))
+				}
+			}
+			continue
+		}
+
+		if note := st.item.note; note != nil {
+			actors := make([]string, len(note.Actors))
+			for i, id := range note.Actors {
+				actors[i] = ids.id(id, wordRegex)
+			}
+			fmt.Fprintf(&b, "%sNote %s %s: %s\n", indent, positions[note.Position], strings.Join(actors, ","), mermaidText(note.Text))
+		} else {
+			msg := st.item.message
+			shorthand := ""
+			if len(activations) > 0 && activations[0].At == at+1 {
+				switch a := activations[0]; {
+				case a.Active && a.Actor == msg.To:
+					shorthand, activations = "+", activations[1:]
+				case !a.Active && a.Actor == msg.From:
+					shorthand, activations = "-", activations[1:]
+				}
+			}
+			fmt.Fprintf(&b, "%s%s%s%s%s: %s\n", indent, ids.id(msg.From, wordRegex), arrows[msg.Type], shorthand, ids.id(msg.To, wordRegex), mermaidText(msg.Label))
+			number += step
+		}
+		at++
+		writeAutonumbers(at, indent)
+		writeActivations(at, indent)
+	}
+	writeAutonumbers(at, mermaidIndent)
+	writeActivations(at, mermaidIndent)
+	return b.String()
+}
+
+// mermaidFragment returns the line that opens a fragment: its keyword and
+// label, or a rect's colour as rgb()
+func mermaidFragment(f *Fragment) string {
+	if f.Type == FragmentRect {
+		if color, ok := parseColor(f.Color); ok {
+			r, g, b := color.RGB()
+			return fmt.Sprintf("rect rgb(%d, %d, %d)", r, g, b)
+		}
+		return "rect"
+	}
+	return strings.TrimSpace(fragmentKeywords[f.Type] + " " + mermaidText(f.Sections[0].Label))
+}
+
+// mermaidText returns text that runs to the end of a line, such as a message,
+// quoted if it starts or ends with blanks that would otherwise be trimmed
+func mermaidText(text string) string {
+	escaped := escapeMermaid(text)
+	if strings.TrimSpace(text) != text {
+		return `"` + escaped + `"`
+	}
+	return escaped
+}
+
+// flowKeywords can't be used as flowchart node or subgraph IDs
+var flowKeywords = map[string]bool{
+	"end": true, "subgraph": true, "direction": true, "graph": true, "flowchart": true,
+	"classDef": true, "class": true, "style": true, "linkStyle": true,
+	"click": true, "callback": true, "accTitle": true, "accDescr": true,
+}
+
+// seqKeywords can't be used as sequence diagram participant IDs. Like the
+// parser, they're compared in lower case.
+var seqKeywords = map[string]bool{
+	"participant": true, "actor": true, "note": true, "activate": true, "deactivate": true,
+	"autonumber": true, "end": true, "loop": true, "alt": true, "else": true, "opt": true,
+	"par": true, "and": true, "critical": true, "option": true, "break": true, "rect": true,
+	"title": true, "box": true, "create": true, "destroy": true, "link": true, "links": true,
+	"properties": true, "details": true, "acctitle": true, "accdescr": true,
+}
+
+// subgraphIDRegex matches the IDs subgraph headers accept
+var subgraphIDRegex = regexp.MustCompile(`^[A-Za-z0-9_]+`)
+
+// mermaidIDs renames IDs that Mermaid can't read, consistently across the
+// statements of a diagram. Valid IDs are reserved first, so that renamed ones
+// never take them.
+type mermaidIDs struct {
+	keywords map[string]bool
+	foldCase bool // Keywords match in any case
+	renamed  map[string]string
+	used     map[string]bool
+}
+
+func newMermaidIDs(keywords map[string]bool) *mermaidIDs {
+	return &mermaidIDs{keywords: keywords, renamed: map[string]string{}, used: map[string]bool{}}
+}
+
+// ok reports whether id can be written as it is where valid matches IDs
+func (m *mermaidIDs) ok(id string, valid *regexp.Regexp) bool {
+	return id != "" && valid.FindString(id) == id && !m.keyword(id)
+}
+
+// keyword reports whether id is one of the diagram's keywords
+func (m *mermaidIDs) keyword(id string) bool {
+	if m.foldCase {
+		id = strings.ToLower(id)
+	}
+	return m.keywords[id]
+}
+
+// reserve keeps a valid ID for itself
+func (m *mermaidIDs) reserve(id string, valid *regexp.Regexp) {
+	if m.ok(id, valid) {
+		m.used[id] = true
+	}
+}
+
+// id returns the ID to write for id where valid matches IDs. Characters it
+// doesn't match become _, keywords get a _ suffix and clashes a number.
+func (m *mermaidIDs) id(id string, valid *regexp.Regexp) string {
+	if renamed, ok := m.renamed[id]; ok {
+		return renamed
+	}
+	if m.ok(id, valid) {
+		return id
+	}
+
+	var b strings.Builder
+	for _, r := range id {
+		if valid.MatchString(string(r)) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	base := b.String()
+	if base == "" || m.keyword(base) {
+		base += "_"
+	}
+	renamed := base
+	for n := 2; m.used[renamed]; n++ {
+		renamed = fmt.Sprintf("%s_%d", base, n)
+	}
+	m.renamed[id], m.used[renamed] = renamed, true
+	return renamed
+}
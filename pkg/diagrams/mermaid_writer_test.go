@@ -0,0 +1,302 @@
+package diagrams
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFlowchart_ToMermaid(t *testing.T) {
+	flow := NewFlowchart(LeftToRight).
+		AddNode("start", "Start", ShapeRounded).
+		AddNode("check", "Valid?", ShapeDiamond).
+		AddNode("db", "Orders DB", ShapeCylinder).
+		AddNode("fail", "Error: \"bad\" input", ShapeBox)
+	flow.AddEdge("start", "check", "").
+		AddEdge("check", "db", "yes").
+		AddStyledEdge("check", "fail", "no | retry", EdgeDotted, HeadNone, HeadArrow).
+		AddStyledEdge("db", "fail", "", EdgeThick, HeadCircle, HeadCross)
+	flow.AddSubgraph("store", "Storage").SetDirection(TopToBottom).Add("db")
+
+	expected := `graph LR
+    start(Start)
+    check{Valid?}
+    db[(Orders DB)]
+    fail["Error: #quot;bad#quot; input"]
+    subgraph store [Storage]
+        direction TD
+        db
+    end
+    start --> check
+    check -->|yes| db
+    check -.->|"no | retry"| fail
+    db o==x fail
+`
+	if got := flow.ToMermaid(); got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestFlowchart_ToMermaid_RoundTrip(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	for shape := ShapeBox; shape <= ShapeDoubleCircle; shape++ {
+		flow.AddNode(string(rune('a'+shape)), "Node "+string(rune('A'+shape)), shape)
+	}
+	flow.AddNode("quotes", `say "hi"`, ShapeBox).
+		AddNode("lines", "Two\nlines", ShapeRounded).
+		AddNode("entities", "#quot; and #35; and <br> stay literal", ShapeBox).
+		AddNode("brackets", "a] b) c} /d\\", ShapeParallelogram).
+		AddNode("ticks", "`code`", ShapeBox).
+		AddNode("padded", "  padded  ", ShapeStadium).
+		AddNode("unicode", "Größe → 大", ShapeHexagon).
+		AddNode("md", "**bold** and *italic*", ShapeBox)
+	flow.Nodes[len(flow.Nodes)-1].Markdown = true
+
+	styles := []EdgeStyle{EdgeSolid, EdgeDotted, EdgeThick}
+	heads := []ArrowHead{HeadNone, HeadArrow, HeadCircle, HeadCross}
+	for _, style := range styles {
+		for _, from := range heads {
+			for _, to := range heads {
+				flow.AddStyledEdge("a", "b", "", style, from, to)
+			}
+		}
+	}
+	flow.AddStyledEdge("b", "c", "", EdgeInvisible, HeadNone, HeadNone).
+		AddEdge("c", "quotes", `"quoted" | piped`).
+		AddEdge("quotes", "lines", "#35; -->").
+		AddEdge("lines", "one", "")
+
+	one := flow.AddSubgraph("one", "one").Add("a", "b")
+	one.AddSubgraph("inner", "Inner [group]").SetDirection(LeftToRight).Add("c")
+	flow.AddSubgraph("two", "Second Group").Add("quotes", "lines")
+
+	mermaid := flow.ToMermaid()
+	parsed, err := ParseMermaidFlowchart(mermaid)
+	if err != nil {
+		t.Fatalf("ParseMermaidFlowchart failed: %v\n%s", err, mermaid)
+	}
+	if !reflect.DeepEqual(parsed, flow) {
+		t.Errorf("Round trip changed the flowchart:\n%s\nwant %+v\ngot  %+v", mermaid, flow, parsed)
+	}
+	if again := parsed.ToMermaid(); again != mermaid {
+		t.Errorf("Expected stable output:\n%s\nGot:\n%s", mermaid, again)
+	}
+}
+
+func TestFlowchart_ToMermaid_IDs(t *testing.T) {
+	flow := NewFlowchart(TopToBottom).
+		AddNode("end", "End", ShapeBox).
+		AddNode("my-node", "Mine", ShapeBox).
+		AddNode("my_node", "Other", ShapeBox).
+		AddNode("ノード", "Unicode", ShapeBox)
+	flow.AddEdge("my-node", "end", "").AddEdge("ノード", "grüppe", "")
+	flow.AddSubgraph("grüppe", "Group").Add("ノード")
+
+	mermaid := flow.ToMermaid()
+	for _, want := range []string{
+		"    end_[End]\n",
+		"    my_node_2[Mine]\n",
+		"    my_node[Other]\n",
+		"    ノード[Unicode]\n",
+		"    subgraph gr_ppe [Group]\n",
+		"    my_node_2 --> end_\n",
+		"    ノード --> gr_ppe\n",
+	} {
+		if !strings.Contains(mermaid, want) {
+			t.Errorf("Expected %q in:\n%s", want, mermaid)
+		}
+	}
+	if _, err := ParseMermaidFlowchart(mermaid); err != nil {
+		t.Errorf("ParseMermaidFlowchart failed: %v\n%s", err, mermaid)
+	}
+}
+
+func TestSequenceDiagram_ToMermaid(t *testing.T) {
+	seq := NewSequenceDiagram().
+		AddActor("C", "Client").
+		AddActor("API", "API").
+		AddMessage("C", "API", "GET /orders", MessageSync).
+		AddMessage("API", "API", "check cache", MessageSync).
+		AddMessage("API", "C", "200 OK", MessageReturn).
+		AddMessage("API", "C", "orders.updated", MessageAsync)
+
+	expected := `sequenceDiagram
+    participant C as Client
+    participant API
+    C->>API: GET /orders
+    API->>API: check cache
+    API-->>C: 200 OK
+    API-)C: orders.updated
+`
+	if got := seq.ToMermaid(); got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestSequenceDiagram_ToMermaid_RoundTrip(t *testing.T) {
+	seq := NewSequenceDiagram().
+		AddActor("A", "Alice \"Al\" Smith").
+		AddActor("B", "  Bob  ").
+		AddActor("svc-1", "Service #1").
+		AddActor("D", "").
+		AddMessage("A", "B", `say "hi"`, MessageSync).
+		AddMessage("B", "A", "#quot; <br> literal", MessageReturn).
+		AddMessage("A", "svc-1", "`tick`: a -> b", MessageAsync).
+		AddMessage("svc-1", "svc-1", " padded ", MessageSync).
+		AddNote(NoteOver, "two\nlines", "A", "svc-1").
+		AddNote(NoteLeftOf, "left", "A")
+
+	mermaid := seq.ToMermaid()
+	parsed, err := ParseMermaidSequence(mermaid)
+	if err != nil {
+		t.Fatalf("ParseMermaidSequence failed: %v\n%s", err, mermaid)
+	}
+
+	// The unreadable ID is renamed throughout
+	seq.Actors[2].ID = "svc_1"
+	for i := range seq.Messages {
+		for _, id := range []*string{&seq.Messages[i].From, &seq.Messages[i].To} {
+			if *id == "svc-1" {
+				*id = "svc_1"
+			}
+		}
+	}
+	seq.Notes[0].Actors[1] = "svc_1"
+	// An actor without a name is written by its ID, which Mermaid shows instead
+	seq.Actors[3].Name = "D"
+	if !reflect.DeepEqual(parsed, seq) {
+		t.Errorf("Round trip changed the diagram:\n%s\nwant %+v\ngot  %+v", mermaid, seq, parsed)
+	}
+	if again := parsed.ToMermaid(); again != mermaid {
+		t.Errorf("Expected stable output:\n%s\nGot:\n%s", mermaid, again)
+	}
+}
+
+func TestSequenceDiagram_ToMermaid_Keywords(t *testing.T) {
+	seq := NewSequenceDiagram().
+		AddActor("end", "Finish").
+		AddActor("Note", "").
+		AddMessage("end", "Note", "done", MessageSync).
+		AddNote(NoteRightOf, "after", "end")
+
+	mermaid := seq.ToMermaid()
+	for _, want := range []string{
+		"    participant end_ as Finish\n",
+		"    participant Note_\n",
+		"    end_->>Note_: done\n",
+		"    Note right of end_: after\n",
+	} {
+		if !strings.Contains(mermaid, want) {
+			t.Errorf("Expected %q in:\n%s", want, mermaid)
+		}
+	}
+
+	parsed, err := ParseMermaidSequence(mermaid)
+	if err != nil {
+		t.Fatalf("ParseMermaidSequence failed: %v\n%s", err, mermaid)
+	}
+	if again := parsed.ToMermaid(); again != mermaid {
+		t.Errorf("Expected stable output:\n%s\nGot:\n%s", mermaid, again)
+	}
+}
+
+func TestSequenceDiagram_ToMermaid_Fragments(t *testing.T) {
+	seq := NewSequenceDiagram().AddActor("A", "Alice").AddActor("B", "Bob").
+		StartFragment(FragmentAlt, "cached").
+		AddMessage("A", "A", "read", MessageSync).
+		AddSection("miss").
+		StartFragment(FragmentLoop, "").
+		AddMessage("A", "B", "fetch", MessageSync).
+		AddSection("ignored"). // Loops have no sections
+		AddMessage("B", "A", "page", MessageReturn).
+		EndFragment().
+		EndFragment().
+		StartRect(RGB(200, 220, 255).Bg()).
+		AddNote(NoteOver, "done", "A", "B").
+		EndFragment()
+
+	expected := `sequenceDiagram
+    participant A as Alice
+    participant B as Bob
+    alt cached
+        A->>A: read
+    else miss
+        loop
+            A->>B: fetch
+            B-->>A: page
+        end
+    end
+    rect rgb(200, 220, 255)
+        Note over A,B: done
+    end
+`
+	mermaid := seq.ToMermaid()
+	if mermaid != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, mermaid)
+	}
+	parsed, err := ParseMermaidSequence(mermaid)
+	if err != nil {
+		t.Fatalf("ParseMermaidSequence failed: %v\n%s", err, mermaid)
+	}
+	if !reflect.DeepEqual(parsed, seq) {
+		t.Errorf("Round trip changed the diagram:\nwant %+v\ngot  %+v", seq, parsed)
+	}
+}
+
+func TestSequenceDiagram_ToMermaid_Activations(t *testing.T) {
+	seq := NewSequenceDiagram().AddActor("A", "A").AddActor("B", "B").
+		Activate("A").
+		AddMessage("A", "B", "call", MessageSync).Activate("B").Activate("A").
+		StartFragment(FragmentOpt, "slow").
+		AddMessage("B", "A", "reply", MessageReturn).Deactivate("B").Deactivate("A").
+		EndFragment().
+		Deactivate("A")
+
+	expected := `sequenceDiagram
+    participant A
+    participant B
+    activate A
+    A->>+B: call
+    activate A
+    opt slow
+        B-->>-A: reply
+        deactivate A
+        deactivate A
+    end
+`
+	mermaid := seq.ToMermaid()
+	if mermaid != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, mermaid)
+	}
+	parsed, err := ParseMermaidSequence(mermaid)
+	if err != nil {
+		t.Fatalf("ParseMermaidSequence failed: %v\n%s", err, mermaid)
+	}
+	if !reflect.DeepEqual(parsed, seq) {
+		t.Errorf("Round trip changed the diagram:\nwant %+v\ngot  %+v", seq, parsed)
+	}
+}
+
+func TestEscapeMermaid(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"plain", "plain"},
+		{`"quoted"`, "#quot;quoted#quot;"},
+		{"#quot;", "#35;quot;"},
+		{"C# and F#;", "C# and F#;"},
+		{"a<br>b", "a#lt;br>b"},
+		{"a\nb", "a<br>b"},
+		{"`code`", "#96;code`"},
+	}
+
+	for _, tt := range tests {
+		if got := escapeMermaid(tt.text); got != tt.want {
+			t.Errorf("escapeMermaid(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+		if got, _ := decodeLabel(`"` + escapeMermaid(tt.text) + `"`); got != tt.text {
+			t.Errorf("decodeLabel(escapeMermaid(%q)) = %q", tt.text, got)
+		}
+	}
+}
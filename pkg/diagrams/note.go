@@ -0,0 +1,269 @@
+package diagrams
+
+import "strings"
+
+// NotePosition places a sequence diagram note relative to lifelines
+type NotePosition int
+
+const (
+	// NoteRightOf places the note to the right of one lifeline
+	NoteRightOf NotePosition = iota
+	// NoteLeftOf places the note to the left of one lifeline
+	NoteLeftOf
+	// NoteOver places the note over one lifeline, or across several
+	NoteOver
+)
+
+// Note is a boxed annotation between the messages of a sequence diagram
+type Note struct {
+	Position NotePosition
+	Actors   []string // The actor the note is beside, or the first and last actors it spans
+	Text     string   // A newline starts a new line
+	At       int      // Number of messages and notes drawn before the note
+}
+
+// AddNote adds a note after the messages and notes added so far. A note
+// beside a lifeline takes one actor; a note over lifelines spans from the
+// first to the last of its actors.
+func (s *SequenceDiagram) AddNote(position NotePosition, text string, actors ...string) *SequenceDiagram {
+	s.Notes = append(s.Notes, Note{
+		Position: position,
+		Actors:   actors,
+		Text:     text,
+		At:       len(s.Messages) + len(s.Notes),
+	})
+	return s
+}
+
+// lines returns the lines of the note's text
+func (n Note) lines() []string {
+	return strings.Split(n.Text, "\n")
+}
+
+// size returns the columns and rows of the note's box: a border and a space
+// around the text
+func (n Note) size() (int, int) {
+	width := 0
+	for _, line := range n.lines() {
+		width = max(width, StringWidth(line))
+	}
+	return width + 4, len(n.lines()) + 2
+}
+
+// span returns the columns of the first and last actors the note is placed
+// against, false if one of them isn't in the diagram
+func (n Note) span(index map[string]int) (int, int, bool) {
+	if len(n.Actors) == 0 {
+		return 0, 0, false
+	}
+	first, last := len(index), -1
+	for _, id := range n.Actors {
+		i, ok := index[id]
+		if !ok {
+			return 0, 0, false
+		}
+		first, last = min(first, i), max(last, i)
+	}
+	return first, last, true
+}
+
+// spans returns the room the note needs between lifelines: a box beside a
+// lifeline keeps a space from the next one, and a box over several lifelines
+// reaches two columns past the outer ones
+func (n Note) spans(index map[string]int, actors int) []seqSpan {
+	first, last, ok := n.span(index)
+	if !ok {
+		return nil
+	}
+	width, _ := n.size()
+	var spans []seqSpan
+	switch {
+	case n.Position == NoteRightOf && first+1 < actors:
+		spans = append(spans, seqSpan{first, first + 1, width + 3})
+	case n.Position == NoteLeftOf && first > 0:
+		spans = append(spans, seqSpan{first - 1, first, width + 3})
+	case n.Position == NoteOver && first < last:
+		spans = append(spans, seqSpan{first, last, width - 4})
+	case n.Position == NoteOver:
+		if first > 0 {
+			spans = append(spans, seqSpan{first - 1, first, width/2 + 2})
+		}
+		if first+1 < actors {
+			spans = append(spans, seqSpan{first, first + 1, width - width/2 + 1})
+		}
+	}
+	return spans
+}
+
+// box returns the left column and width of the note's box in a layout. It
+// may start left of the diagram, except within MaxWidth, where it is moved
+// and narrowed to fit.
+func (n Note) box(l *seqLayout, index map[string]int) (int, int, bool) {
+	first, last, ok := n.span(index)
+	if !ok {
+		return 0, 0, false
+	}
+	width, _ := n.size()
+	var x int
+	switch n.Position {
+	case NoteRightOf:
+		x = l.center(first) + 2
+	case NoteLeftOf:
+		x = l.center(first) - 1 - width
+	default:
+		from, to := l.center(first), l.center(last)
+		if first < last {
+			// Reach past the outer lifelines, or centre the box across them
+			width = max(width, to-from+5)
+		}
+		x = (from+to+1)/2 - width/2
+	}
+
+	if l.maxWidth > 0 {
+		width = min(width, l.maxWidth)
+		x = max(min(x, l.maxWidth-width), 0)
+	}
+	return x, width, true
+}
+
+// room returns the widest box the note can take in a layout without reaching
+// the next lifeline or, beside an outer lifeline, the frame of a fragment
+// around it at the edge of maxWidth
+func (n Note) room(l *seqLayout, index map[string]int) (int, bool) {
+	first, _, ok := n.span(index)
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case n.Position == NoteRightOf && first+1 < len(l.columns):
+		return l.center(first+1) - l.center(first) - 3, true
+	case n.Position == NoteRightOf:
+		return l.maxWidth - l.center(first) - 4, true
+	case n.Position == NoteLeftOf && first > 0:
+		return l.center(first) - l.center(first-1) - 3, true
+	case n.Position == NoteLeftOf:
+		return l.center(first) - 3, true
+	}
+	return l.maxWidth, true
+}
+
+// besideGap returns the gap a note beside a lifeline sits in, false for a
+// note over lifelines or beside an outer one
+func (n Note) besideGap(index map[string]int, actors int) (int, bool) {
+	first, _, ok := n.span(index)
+	switch {
+	case !ok:
+		return 0, false
+	case n.Position == NoteRightOf && first+1 < actors:
+		return first, true
+	case n.Position == NoteLeftOf && first > 0:
+		return first - 1, true
+	}
+	return 0, false
+}
+
+// keepNoteRoom widens narrowed gaps until the notes in them have room to
+// wrap in, taking the columns from gaps that can spare them first. It
+// reports whether the gaps grew in all.
+func (l *seqLayout) keepNoteRoom(notes []Note, index map[string]int) bool {
+	floors := make([]int, len(l.gaps))
+	for i := range floors {
+		floors[i] = seqMinSpacing
+	}
+	for _, n := range notes {
+		if i, ok := n.besideGap(index, len(l.columns)); ok {
+			width, _ := n.size()
+			between := l.center(i+1) - l.center(i) - l.gaps[i]
+			floors[i] = max(floors[i], min(width, minWrapWidth+4)+3-between)
+		}
+	}
+	for i := range l.gaps {
+		for j := range l.gaps {
+			if take := min(floors[i]-l.gaps[i], l.gaps[j]-floors[j]); take > 0 {
+				l.gaps[i] += take
+				l.gaps[j] -= take
+			}
+		}
+	}
+	grew := false
+	for i, floor := range floors {
+		if l.gaps[i] < floor {
+			l.gaps[i], grew = floor, true
+		}
+	}
+	return grew
+}
+
+// outerNoteRoom returns the columns that notes left of the first lifeline
+// and right of the last one take past it, up to the narrowest box they can
+// be wrapped to and with space for a fragment's frame
+func (s *SequenceDiagram) outerNoteRoom(index map[string]int) (int, int) {
+	before, after := 0, 0
+	for _, n := range s.Notes {
+		first, _, ok := n.span(index)
+		if !ok {
+			continue
+		}
+		width, _ := n.size()
+		width = min(width, minWrapWidth+4)
+		switch {
+		case n.Position == NoteLeftOf && first == 0:
+			before = max(before, width+3)
+		case n.Position == NoteRightOf && first == len(s.Actors)-1:
+			after = max(after, width+3)
+		}
+	}
+	return before, after
+}
+
+// fitNotes returns the diagram with the text of each note wrapped at spaces
+// to the room it has within maxWidth, 0 meaning no limit, so that a note
+// stays beside its lifeline when the columns narrow. A note between two
+// lifelines is wrapped no narrower than minWrapWidth, and one beside an outer
+// lifeline with too little room to wrap in is left to be shortened instead.
+func (s *SequenceDiagram) fitNotes(maxWidth int) *SequenceDiagram {
+	if maxWidth <= 0 || len(s.Notes) == 0 {
+		return s
+	}
+	l := s.layout(maxWidth)
+	index := s.actorIndex()
+	fitted := *s
+	fitted.Notes = make([]Note, len(s.Notes))
+	for i, n := range s.Notes {
+		fitted.Notes[i] = n
+		room, ok := n.room(l, index)
+		if !ok {
+			continue
+		}
+		if _, between := n.besideGap(index, len(s.Actors)); between || room-4 >= minWrapWidth {
+			fitted.Notes[i].Text = wrapText(n.Text, max(room-4, minWrapWidth))
+		}
+	}
+	return &fitted
+}
+
+// wrapText wraps the lines of text to width columns at spaces
+func wrapText(text string, width int) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if StringWidth(line) <= width {
+			lines = append(lines, line)
+			continue
+		}
+		for _, part := range wrapStyled(StyledText{{Text: line}}, width) {
+			lines = append(lines, part.String())
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// drawNote draws a note's box from column x on rows y onwards, hiding the
+// lifelines behind it
+func drawNote(c *Canvas, x, y, width int, n Note, ns NodeStyle) {
+	textWidth, height := n.size()
+	c.Fill(x, y, width, height, " ", ns.Fill)
+	c.Box(x, y, width, height, ns.Border)
+	for i, line := range n.lines() {
+		c.Text(x+2+max(width-textWidth, 0)/2, y+1+i, Truncate(line, width-4), ns.text())
+	}
+}
@@ -0,0 +1,134 @@
+package diagrams
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSequenceDiagram_AddNote(t *testing.T) {
+	seq := NewSequenceDiagram().AddActor("A", "Alice").AddActor("B", "Bob").
+		AddNote(NoteOver, "first", "A", "B").
+		AddMessage("A", "B", "call", MessageSync).
+		AddNote(NoteRightOf, "second", "B").
+		AddNote(NoteLeftOf, "third", "A").
+		AddMessage("B", "A", "reply", MessageReturn)
+
+	var order []string
+	for _, it := range seq.items() {
+		if it.note != nil {
+			order = append(order, it.note.Text)
+		} else {
+			order = append(order, it.message.Label)
+		}
+	}
+	if got := strings.Join(order, ","); got != "first,call,second,third,reply" {
+		t.Errorf("Expected notes between the messages, got %s", got)
+	}
+}
+
+func TestSequenceDiagram_Render_Notes(t *testing.T) {
+	seq := NewSequenceDiagram().AddActor("A", "Alice").AddActor("B", "Bob").
+		AddNote(NoteLeftOf, "start", "A").
+		AddMessage("A", "B", "call", MessageSync).
+		AddNote(NoteRightOf, "retry\ntwice", "B").
+		AddNote(NoteOver, "timeout", "A", "B")
+
+	expected := strings.Join([]string{
+		"       Alice              Bob",
+		"    ┌─────┬────┐      ┌─────┬────┐",
+		"          │                 │",
+		"┌───────┐ │                 │",
+		"│ start │ │                 │",
+		"└───────┘ │                 │",
+		"          │                 │",
+		"          ├───── call ─────→│",
+		"          │                 │",
+		"          │                 │ ┌───────┐",
+		"          │                 │ │ retry │",
+		"          │                 │ │ twice │",
+		"          │                 │ └───────┘",
+		"          │                 │",
+		"        ┌─────────────────────┐",
+		"        │       timeout       │",
+		"        └─────────────────────┘",
+		"          │                 │",
+		"    └─────┴────┘      └─────┴────┘",
+	}, "\n")
+	if got := seq.Render(); got != expected {
+		t.Errorf("Expected:\n%s\n\nGot:\n%s", expected, got)
+	}
+}
+
+func TestSequenceDiagram_RenderWithOptions_NotesMaxWidth(t *testing.T) {
+	seq := NewSequenceDiagram().AddActor("A", "Alice").AddActor("B", "Bob").
+		AddNote(NoteLeftOf, "a note wider than the whole diagram", "A").
+		AddMessage("A", "B", "call", MessageSync)
+
+	opts := RenderOptions{MaxWidth: 30}
+	if width, _ := seq.Size(opts); width > 30 {
+		t.Errorf("Expected at most 30 columns, got %d", width)
+	}
+	output := seq.RenderWithOptions(opts)
+	if !strings.Contains(output, "│ a note wider than the who… │") {
+		t.Errorf("Expected the note shortened to fit:\n%s", output)
+	}
+}
+
+func TestSequenceDiagram_RenderWithOptions_NoteInFragment(t *testing.T) {
+	seq := NewSequenceDiagram().AddActor("A", "Alice").AddActor("B", "Bob").
+		AddActor("C", "Carol").AddActor("D", "Dave").
+		StartFragment(FragmentLoop, "every minute").
+		AddMessage("A", "B", "ping", MessageSync).
+		AddNote(NoteRightOf, "checks the health of everything", "B").
+		AddMessage("B", "C", "forward", MessageSync).
+		EndFragment()
+
+	opts := RenderOptions{MaxWidth: 40}
+	output := seq.RenderWithOptions(opts)
+
+	// The note wraps to stay between Bob and Carol, clear of the frame
+	fitted := seq.fitNotes(opts.MaxWidth)
+	l := fitted.layout(opts.MaxWidth)
+	x, width, _ := fitted.Notes[0].box(l, fitted.actorIndex())
+	if x <= l.center(1) || x+width > l.center(2) {
+		t.Errorf("Expected the note between the lifelines at %d and %d, got columns %d to %d:\n%s",
+			l.center(1), l.center(2), x, x+width-1, output)
+	}
+	if !strings.Contains(output, "│ checks │") {
+		t.Errorf("Expected the note's text wrapped:\n%s", output)
+	}
+	if strings.Contains(output, "┤\n") {
+		t.Errorf("Expected the note's border apart from the frame:\n%s", output)
+	}
+}
+
+func TestParseMermaidSequence_Notes(t *testing.T) {
+	seq, err := ParseMermaidSequence(`sequenceDiagram
+    participant A
+    note left of A: starts<br>here
+    A->>B: call
+    Note right of B: retries
+    Note over A,B: "timeout: 30s"`)
+	if err != nil {
+		t.Fatalf("ParseMermaidSequence failed: %v", err)
+	}
+
+	expected := []Note{
+		{NoteLeftOf, []string{"A"}, "starts\nhere", 0},
+		{NoteRightOf, []string{"B"}, "retries", 2},
+		{NoteOver, []string{"A", "B"}, "timeout: 30s", 3},
+	}
+	if len(seq.Notes) != len(expected) {
+		t.Fatalf("Expected %d notes, got %+v", len(expected), seq.Notes)
+	}
+	for i, want := range expected {
+		got := seq.Notes[i]
+		if got.Position != want.Position || strings.Join(got.Actors, ",") != strings.Join(want.Actors, ",") || got.Text != want.Text || got.At != want.At {
+			t.Errorf("Note %d: expected %+v, got %+v", i, want, got)
+		}
+	}
+
+	if _, err := ParseMermaidSequence("sequenceDiagram\n    Note above A: text"); err == nil {
+		t.Error("Expected an error for a note without a position")
+	}
+}
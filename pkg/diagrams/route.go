@@ -0,0 +1,646 @@
+package diagrams
+
+import (
+	"sort"
+	"strings"
+)
+
+// routeHop is the part of an edge between two adjacent ranks.
+//
+// A hop leaves its source, runs along the flow direction to a track in the
+// gap between the ranks, crosses to the target's position on that track and
+// continues to the target. Hops whose source and target are aligned skip the
+// track and run straight.
+type routeHop struct {
+	from  *layoutNode
+	to    *layoutNode
+	s, t  int    // Source and target positions on the minor axis
+	track int    // Track in the gap between the ranks, -1 for straight hops
+	span  [2]int // Part of the track used by this hop alone
+}
+
+// routeGap describes the space between two adjacent ranks
+type routeGap struct {
+	tracks     int  // Parallel tracks needed so that unrelated edges never share a segment
+	labelRow   bool // Whether a row is reserved for labels above the arrows (TopToBottom)
+	labelWidth int  // Widest label on a final segment in this gap (LeftToRight)
+}
+
+// trackGroup collects the hops of one source that share a track
+type trackGroup struct {
+	hops    []*routeHop
+	source  int
+	lo, hi  int
+	targets map[int]bool
+	above   []*trackGroup // Groups that must use an earlier track
+	track   int
+}
+
+// minorCenter returns the position of a node's centre across the flow direction
+func (l *flowLayout) minorCenter(n *layoutNode) int {
+	if l.direction == LeftToRight {
+		return n.y + n.h/2
+	}
+	return n.x + n.w/2
+}
+
+// routeEdges splits every edge into hops, assigns tracks in each gap
+// between ranks and decides where each edge label goes
+func (l *flowLayout) routeEdges() {
+	if l.direction == TopToBottom {
+		l.assignPorts()
+		l.fitFrameTitles()
+	}
+	l.gaps = make([]routeGap, max(len(l.layers)-1, 0))
+	byGap := make([][]*routeHop, len(l.gaps))
+	for _, e := range l.edges {
+		last := len(e.path) - 2
+		for i := 0; i+1 < len(e.path); i++ {
+			from, to := l.nodes[e.path[i]], l.nodes[e.path[i+1]]
+			h := &routeHop{from: from, to: to, s: l.minorCenter(from), t: l.minorCenter(to), track: -1}
+			if i == 0 {
+				h.s += e.ports[0]
+			}
+			if i == last {
+				h.t += e.ports[1]
+			}
+			e.hops = append(e.hops, h)
+			byGap[from.rank] = append(byGap[from.rank], h)
+		}
+	}
+
+	for r, hops := range byGap {
+		l.gaps[r].tracks = assignTracks(hops)
+	}
+	for _, e := range l.edges {
+		l.placeLabel(e)
+	}
+}
+
+// assignPorts moves the ends of back edges off the centre of their nodes, so
+// that they never share a segment with the forward edges that leave and
+// arrive there. Both ends move towards the side the edge travels to, which
+// keeps a back edge from crossing the forward edge it runs alongside;
+// successive back edges on the same side of a node move further out.
+func (l *flowLayout) assignPorts() {
+	type side struct {
+		n     *layoutNode
+		end   int
+		after bool
+	}
+	used := make(map[side]int)
+	for _, e := range l.edges {
+		if !e.reversed {
+			continue
+		}
+		first, last := l.nodes[e.path[0]], l.nodes[e.path[len(e.path)-1]]
+		after := l.minorCenter(last) >= l.minorCenter(first)
+		for end, n := range []*layoutNode{first, last} {
+			key := side{n, end, after}
+			e.ports[end] = l.portOffset(n, used[key], after)
+			used[key]++
+		}
+	}
+}
+
+// portOffset returns the offset from a node's centre of its k-th back edge
+// port, right of the centre (after) or left of it
+func (l *flowLayout) portOffset(n *layoutNode, k int, after bool) int {
+	size := l.minorSize(n)
+	if after {
+		return min(2*(k+1), size-size/2-2)
+	}
+	return max(-2*(k+1), -(size/2)+1)
+}
+
+// assignBackPorts places the ends of the back edges of a LeftToRight layout
+// below their nodes, where they never meet the forward edges on the sides.
+// Each end drops from its own column to its own row under the node, and the
+// node keeps those rows free. Ends leaving a node, which turn towards the
+// later ranks, sit right of those arriving; the outermost ends take the
+// nearest rows so that no two of them cross.
+func (l *flowLayout) assignBackPorts() {
+	ends := make(map[*layoutNode][2][]*layoutEdge)
+	for _, e := range l.edges {
+		if !e.reversed {
+			continue
+		}
+		for end, i := range []int{e.path[0], e.path[len(e.path)-1]} {
+			n := l.nodes[i]
+			lists := ends[n]
+			lists[end] = append(lists[end], e)
+			ends[n] = lists
+		}
+	}
+
+	for n, lists := range ends {
+		leaving, arriving := lists[0], lists[1]
+		centre, shift := n.w/2, 0
+		if len(leaving) > 0 && len(arriving) > 0 {
+			shift = 1
+		}
+		if len(n.loops) > 0 {
+			// Clear of the self-loop drawn under the middle of the node
+			shift = min(2, n.w/2-1) + 1
+		}
+		below := n.h - n.h/2 + l.loopSize(n)
+		for k, e := range leaving {
+			e.columns[0] = min(centre+shift+len(leaving)-1-k, n.w-1)
+			e.ports[0] = below + 1 + k
+		}
+		for k, e := range arriving {
+			e.columns[1] = max(centre-shift-(len(arriving)-1-k), 0)
+			e.ports[1] = below + 1 + k
+		}
+		n.back = 1 + max(len(leaving), len(arriving))
+	}
+
+	// Long back edges stay on the rows they turn onto
+	for _, e := range l.edges {
+		if e.reversed && len(e.path) > 2 {
+			l.nodes[e.path[1]].align[0] = e.ports[0]
+			l.nodes[e.path[len(e.path)-2]].align[1] = e.ports[1]
+		}
+	}
+}
+
+// assignTracks gives every bending hop in a gap a track. Hops leaving the same
+// point of a source share a track and fan out along it; groups whose spans
+// overlap get different tracks, and a group that leaves from a column another
+// group arrives at is placed on an earlier track so their vertical segments never overlap.
+// It returns the number of tracks used.
+func assignTracks(hops []*routeHop) int {
+	type source struct {
+		n *layoutNode
+		s int
+	}
+	var groups []*trackGroup
+	bySource := make(map[source]*trackGroup)
+	for _, h := range hops {
+		if h.s == h.t {
+			continue
+		}
+		g, ok := bySource[source{h.from, h.s}]
+		if !ok {
+			g = &trackGroup{source: h.s, lo: h.s, hi: h.s, targets: make(map[int]bool)}
+			bySource[source{h.from, h.s}] = g
+			groups = append(groups, g)
+		}
+		g.hops = append(g.hops, h)
+		g.lo, g.hi = min(g.lo, h.t), max(g.hi, h.t)
+		g.targets[h.t] = true
+	}
+
+	for _, a := range groups {
+		for _, b := range groups {
+			if a != b && b.targets[a.source] {
+				b.above = append(b.above, a)
+			}
+		}
+		a.track = -1
+		setSpans(a)
+	}
+
+	// Place groups left to right, but never before a group that must sit above them
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].lo < groups[j].lo })
+	tracks := 0
+	placed := make(map[*trackGroup]bool)
+	for len(placed) < len(groups) {
+		var next *trackGroup
+		for _, g := range groups {
+			if placed[g] {
+				continue
+			}
+			if next == nil {
+				next = g // Used when the constraints form a cycle
+			}
+			if allPlaced(g.above, placed) {
+				next = g
+				break
+			}
+		}
+
+		track := 0
+		for _, a := range next.above {
+			track = max(track, a.track+1)
+		}
+		for conflicts(next, groups, track) {
+			track++
+		}
+		next.track = track
+		placed[next] = true
+		tracks = max(tracks, track+1)
+	}
+
+	for _, g := range groups {
+		for _, h := range g.hops {
+			h.track = g.track
+		}
+	}
+	return tracks
+}
+
+func allPlaced(groups []*trackGroup, placed map[*trackGroup]bool) bool {
+	for _, g := range groups {
+		if !placed[g] {
+			return false
+		}
+	}
+	return true
+}
+
+// conflicts reports whether g would share a segment with a group already on the track
+func conflicts(g *trackGroup, groups []*trackGroup, track int) bool {
+	for _, other := range groups {
+		if other == g || other.track != track {
+			continue
+		}
+		overlap := min(g.hi, other.hi) - max(g.lo, other.lo)
+		if overlap > 0 {
+			return true
+		}
+		// Two groups may only touch where they arrive at the same target
+		if overlap == 0 {
+			point := max(g.lo, other.lo)
+			if !g.targets[point] || !other.targets[point] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setSpans records, for each hop of a group, the part of the track between its
+// target and the previous target on the same side of the source
+func setSpans(g *trackGroup) {
+	hops := append([]*routeHop(nil), g.hops...)
+	sort.SliceStable(hops, func(i, j int) bool { return hops[i].t < hops[j].t })
+
+	prev := g.source
+	for _, h := range hops {
+		if h.t > g.source {
+			h.span = [2]int{prev, h.t}
+			prev = h.t
+		}
+	}
+	prev = g.source
+	for i := len(hops) - 1; i >= 0; i-- {
+		if h := hops[i]; h.t < g.source {
+			h.span = [2]int{h.t, prev}
+			prev = h.t
+		}
+	}
+}
+
+// placeLabel puts an edge label on the first track segment long enough to hold
+// it; otherwise the label goes on the final segment before the arrow
+func (l *flowLayout) placeLabel(e *layoutEdge) {
+	e.labelHop = -1
+	if e.edge.Label == "" || e.edge.Style == EdgeInvisible || len(e.hops) == 0 {
+		return
+	}
+
+	if l.direction == TopToBottom {
+		need := StringWidth(e.edge.Label) + 2
+		for i, h := range e.hops {
+			if h.track >= 0 && h.span[1]-h.span[0]-1 >= need {
+				e.labelHop = i
+				return
+			}
+		}
+	}
+
+	gap := &l.gaps[e.hops[len(e.hops)-1].from.rank]
+	gap.labelRow = true
+	gap.labelWidth = max(gap.labelWidth, StringWidth(e.edge.Label))
+}
+
+// gapSize returns the space needed between rank r and rank r+1: a stub leaving
+// the source, the borders of frames closing after rank r, the tracks, room for
+// labels, the borders of frames opening before rank r+1 and the arrow
+func (l *flowLayout) gapSize(r int) int {
+	gap := l.gaps[r]
+	size := 2 + gap.tracks + l.closing[r] + l.opening[r+1]
+	if l.direction == LeftToRight {
+		size++
+		if gap.labelWidth > 0 {
+			size += gap.labelWidth + 1
+		}
+	} else if gap.labelRow {
+		size++
+	}
+	return size
+}
+
+// trackPos returns the row (TopToBottom) or column (LeftToRight) of a track in gap r
+func (l *flowLayout) trackPos(r, track int) int {
+	return l.rankStart[r] + l.rankSize[r] + 1 + l.closing[r] + track
+}
+
+// draw renders the layout onto a canvas: edges and self-loops first, then
+// nodes on top so that edges leaving a node join its border with a tee
+func (l *flowLayout) draw(theme *Theme) *Canvas {
+	c := NewCanvas(l.width, l.height)
+	labels := make(map[Point][]string)
+
+	for _, e := range l.edges {
+		switch {
+		case e.edge.Style == EdgeInvisible:
+			// Invisible edges only shape the layout
+		case l.direction == LeftToRight:
+			l.drawEdgeLR(c, e, labels, theme)
+		default:
+			l.drawEdgeTB(c, e, labels, theme)
+		}
+	}
+
+	// Frames merge with the edges crossing them
+	for _, cl := range l.clusters {
+		x, y, w, h := l.frame(cl)
+		drawFrame(c, x, y, w, h, cl.sub.Title, theme)
+	}
+
+	// Labels of edges sharing a final segment are written together
+	points := make([]Point, 0, len(labels))
+	for p := range labels {
+		points = append(points, p)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].Y != points[j].Y {
+			return points[i].Y < points[j].Y
+		}
+		return points[i].X < points[j].X
+	})
+	for _, p := range points {
+		text := strings.Join(labels[p], ", ")
+		if l.direction == TopToBottom {
+			c.Text(p.X-StringWidth(text)/2, p.Y, text, theme.EdgeLabel)
+		} else {
+			c.Text(p.X, p.Y, text, theme.EdgeLabel)
+		}
+	}
+
+	for _, n := range l.nodes {
+		if len(n.loops) > 0 {
+			l.drawLoop(c, n, theme)
+		}
+	}
+	for _, n := range l.nodes {
+		switch {
+		case n.nested != nil:
+			drawFrame(c, n.x, n.y, n.w, n.h, n.node.Label, theme)
+			c.DrawCanvas(n.x+2, n.y+2, n.nested.draw(theme))
+		case !n.dummy:
+			drawNode(c, n.x, n.y, n.node, theme.NodeStyle(n.node.Shape))
+		}
+	}
+
+	return c
+}
+
+// attach returns the cell where an edge meets a node, offset from its centre
+// across the flow direction, on the side facing the next rank (after) or the
+// previous one. Off-centre edges meet a diamond on its middle row or column,
+// and edges meet the slanted sides of parallelograms and trapezoids.
+func (l *flowLayout) attach(n *layoutNode, offset int, after bool) Point {
+	c := l.minorCenter(n) + offset
+	diamond := n.node.Shape == ShapeDiamond && offset != 0
+	if l.direction == LeftToRight {
+		inset := 0
+		switch n.node.Shape {
+		case ShapeParallelogram, ShapeParallelogramAlt, ShapeTrapezoid, ShapeTrapezoidAlt:
+			inset = 1
+		}
+		switch {
+		case diamond:
+			return Point{n.x + n.w/2, c}
+		case after:
+			return Point{n.x + n.w - 1 - inset, c}
+		}
+		return Point{n.x + inset, c}
+	}
+	switch {
+	case diamond:
+		return Point{c, n.y + n.h/2}
+	case after:
+		return Point{c, n.y + n.h - 1}
+	}
+	return Point{c, n.y}
+}
+
+// drawEdgeTB draws an edge of a TopToBottom layout from the bottom of its
+// source, along its tracks, down to the top of its target, with the edge's
+// markers just outside the nodes. Back edges run the same way from their
+// target down to their source, so an arrow at the target points up.
+func (l *flowLayout) drawEdgeTB(c *Canvas, e *layoutEdge, labels map[Point][]string, theme *Theme) {
+	first := l.nodes[e.path[0]]
+	last := l.nodes[e.path[len(e.path)-1]]
+	tail, head := e.heads()
+
+	start := l.attach(first, e.ports[0], true)
+	if tail != HeadNone {
+		start.Y++
+	}
+	points := []Point{start}
+	for _, h := range e.hops {
+		if h.track >= 0 {
+			row := l.trackPos(h.from.rank, h.track)
+			points = append(points, Point{h.s, row}, Point{h.t, row})
+		}
+	}
+	end := l.attach(last, e.ports[1], false)
+	if head != HeadNone {
+		end.Y--
+	}
+	points = append(points, end)
+
+	c.LinePath(e.edge.Style.line(), theme.Edge, points...)
+	if tail != HeadNone {
+		c.Set(start.X, start.Y, headChar(tail, ArrowUp), theme.Edge)
+	}
+	if head != HeadNone {
+		c.Set(end.X, end.Y, headChar(head, ArrowDown), theme.Edge)
+	}
+
+	if e.edge.Label == "" {
+		return
+	}
+	if e.labelHop >= 0 {
+		h := e.hops[e.labelHop]
+		text := " " + e.edge.Label + " "
+		x := h.span[0] + 1 + (h.span[1]-h.span[0]-1-StringWidth(text))/2
+		c.Text(x, l.trackPos(h.from.rank, h.track), text, theme.EdgeLabel)
+		return
+	}
+	// Above the borders of the frames opening before the target
+	p := Point{end.X, l.rankStart[last.rank] - 2 - l.opening[last.rank]}
+	if e.reversed {
+		// Beside the back edge rather than across it, clear of the forward arrows at the centre
+		x := p.X + 2
+		if e.ports[1] < 0 {
+			x = p.X - 1 - StringWidth(e.edge.Label)
+		}
+		c.Text(x, p.Y, e.edge.Label, theme.EdgeLabel)
+		return
+	}
+	labels[p] = append(labels[p], e.edge.Label)
+}
+
+// drawEdgeLR draws an edge of a LeftToRight layout from the right of its
+// source, along its tracks, to the left of its target, with the edge's
+// markers just outside the nodes. Back edges run below the nodes from their
+// target to their source, so their markers sit under the nodes and point up.
+func (l *flowLayout) drawEdgeLR(c *Canvas, e *layoutEdge, labels map[Point][]string, theme *Theme) {
+	first := l.nodes[e.path[0]]
+	last := l.nodes[e.path[len(e.path)-1]]
+	tail, head := e.heads()
+
+	var start, end Point
+	var points []Point
+	if e.reversed {
+		start = Point{first.x + e.columns[0], first.y + first.h}
+		points = []Point{start, {start.X, l.minorCenter(first) + e.ports[0]}}
+	} else {
+		start = l.attach(first, e.ports[0], true)
+		if tail != HeadNone {
+			start.X++
+		}
+		points = []Point{start}
+	}
+	for _, h := range e.hops {
+		if h.track >= 0 {
+			col := l.trackPos(h.from.rank, h.track)
+			points = append(points, Point{col, h.s}, Point{col, h.t})
+		}
+	}
+	if e.reversed {
+		end = Point{last.x + e.columns[1], last.y + last.h}
+		points = append(points, Point{end.X, l.minorCenter(last) + e.ports[1]}, end)
+	} else {
+		end = l.attach(last, e.ports[1], false)
+		if head != HeadNone {
+			end.X--
+		}
+		points = append(points, end)
+	}
+
+	line := e.edge.Style.line()
+	c.LinePath(line, theme.Edge, points...)
+	if e.reversed {
+		// The path ends in half lines, so an end without a marker gets a full
+		// line reaching up to the node
+		vertical := BoxVertical
+		if char, ok := styledLines[line][lineUp|lineDown]; ok {
+			vertical = char
+		}
+		for i, p := range []Point{start, end} {
+			marker := []ArrowHead{tail, head}[i]
+			if marker != HeadNone {
+				c.Set(p.X, p.Y, headChar(marker, ArrowUp), theme.Edge)
+			} else {
+				c.Set(p.X, p.Y, vertical, theme.Edge)
+			}
+		}
+	} else {
+		if tail != HeadNone {
+			c.Set(start.X, start.Y, headChar(tail, ArrowLeft), theme.Edge)
+		}
+		if head != HeadNone {
+			c.Set(end.X, end.Y, headChar(head, ArrowRight), theme.Edge)
+		}
+	}
+
+	if e.edge.Label != "" {
+		y := end.Y
+		if e.reversed {
+			y = l.minorCenter(last) + e.ports[1]
+		}
+		r := e.hops[len(e.hops)-1].from.rank
+		p := Point{l.trackPos(r, l.gaps[r].tracks) + 1, y}
+		labels[p] = append(labels[p], e.edge.Label)
+	}
+}
+
+// drawLoop draws a node's self-loops as a single small loop, right of the
+// node in TopToBottom layouts and below it in LeftToRight ones. The loop takes
+// the line style and target marker of the first self-loop.
+func (l *flowLayout) drawLoop(c *Canvas, n *layoutNode, theme *Theme) {
+	label := loopLabel(n)
+	line, head := n.loops[0].Style.line(), n.loops[0].ToHead
+
+	// points runs from the node around to the cell beside the node where the
+	// marker goes; without a marker the loop continues onto the border
+	draw := func(arrow string, back Point, points ...Point) {
+		if head == HeadNone {
+			points = append(points, back)
+		}
+		c.LinePath(line, theme.Edge, points...)
+		if end := points[len(points)-1]; head != HeadNone {
+			c.Set(end.X, end.Y, headChar(head, arrow), theme.Edge)
+		}
+	}
+
+	if l.direction == LeftToRight {
+		centre, bottom := n.x+n.w/2, n.y+n.h
+		reach := min(2, n.w/2-1)
+		draw(ArrowUp, Point{centre - reach, bottom - 1},
+			Point{centre + reach, bottom - 1}, Point{centre + reach, bottom + 1},
+			Point{centre - reach, bottom + 1}, Point{centre - reach, bottom})
+		c.Text(n.x, bottom+2, label, theme.EdgeLabel)
+		return
+	}
+
+	right, middle := n.x+n.w, n.y+n.h/2
+	if n.h > 1 {
+		draw(ArrowLeft, Point{right - 1, middle + 1},
+			Point{right - 1, middle}, Point{right + 1, middle}, Point{right + 1, middle + 1}, Point{right, middle + 1})
+	} else {
+		// Single-row nodes have no side to return to, so the loop comes back from below
+		draw(ArrowUp, Point{right - 1, middle},
+			Point{right - 1, middle}, Point{right + 1, middle}, Point{right + 1, middle + 1}, Point{right - 1, middle + 1})
+	}
+	c.Text(right+3, middle, label, theme.EdgeLabel)
+}
+
+// heads returns the markers at the start and end of the edge's path, which
+// runs from the edge's target to its source for back edges
+func (e *layoutEdge) heads() (ArrowHead, ArrowHead) {
+	if e.reversed {
+		return e.edge.ToHead, e.edge.FromHead
+	}
+	return e.edge.FromHead, e.edge.ToHead
+}
+
+// headChar returns the character drawn for a marker; arrow is the arrow
+// pointing into the node
+func headChar(head ArrowHead, arrow string) string {
+	switch head {
+	case HeadCircle:
+		return "◯"
+	case HeadCross:
+		return "✕"
+	}
+	return arrow
+}
+
+// line returns the canvas line style an edge is drawn with
+func (s EdgeStyle) line() LineStyle {
+	switch s {
+	case EdgeDotted:
+		return LineDashed
+	case EdgeThick:
+		return LineHeavy
+	}
+	return LineLight
+}
+
+// loopLabel joins the labels of a node's self-loops
+func loopLabel(n *layoutNode) string {
+	var labels []string
+	for _, e := range n.loops {
+		if e.Label != "" {
+			labels = append(labels, e.Label)
+		}
+	}
+	return strings.Join(labels, ", ")
+}
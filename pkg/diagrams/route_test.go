@@ -0,0 +1,276 @@
+package diagrams
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAssignTracks_SharedSource(t *testing.T) {
+	source := &layoutNode{}
+	hops := []*routeHop{
+		{from: source, to: &layoutNode{}, s: 10, t: 2},
+		{from: source, to: &layoutNode{}, s: 10, t: 20},
+	}
+
+	if tracks := assignTracks(hops); tracks != 1 {
+		t.Errorf("Expected 1 track, got %d", tracks)
+	}
+
+	if hops[0].track != 0 || hops[1].track != 0 {
+		t.Errorf("Expected both hops on track 0, got %d and %d", hops[0].track, hops[1].track)
+	}
+
+	// Each hop owns the part of the track on its side of the source
+	if hops[0].span != [2]int{2, 10} || hops[1].span != [2]int{10, 20} {
+		t.Errorf("Unexpected spans %v and %v", hops[0].span, hops[1].span)
+	}
+}
+
+func TestAssignTracks_Overlap(t *testing.T) {
+	hops := []*routeHop{
+		{from: &layoutNode{}, to: &layoutNode{}, s: 0, t: 10},
+		{from: &layoutNode{}, to: &layoutNode{}, s: 5, t: 15},
+		{from: &layoutNode{}, to: &layoutNode{}, s: 20, t: 30},
+	}
+
+	if tracks := assignTracks(hops); tracks != 2 {
+		t.Errorf("Expected 2 tracks, got %d", tracks)
+	}
+
+	if hops[0].track == hops[1].track {
+		t.Error("Expected overlapping hops on different tracks")
+	}
+
+	if hops[2].track != 0 {
+		t.Errorf("Expected disjoint hop to reuse track 0, got %d", hops[2].track)
+	}
+}
+
+func TestAssignTracks_SharedTarget(t *testing.T) {
+	hops := []*routeHop{
+		{from: &layoutNode{}, to: &layoutNode{}, s: 0, t: 10},
+		{from: &layoutNode{}, to: &layoutNode{}, s: 20, t: 10},
+	}
+
+	if tracks := assignTracks(hops); tracks != 1 {
+		t.Errorf("Expected hops meeting at their target to share a track, got %d tracks", tracks)
+	}
+}
+
+func TestAssignTracks_DepartureAboveArrival(t *testing.T) {
+	// The first hop arrives at column 10, where the second one leaves
+	hops := []*routeHop{
+		{from: &layoutNode{}, to: &layoutNode{}, s: 0, t: 10},
+		{from: &layoutNode{}, to: &layoutNode{}, s: 10, t: 20},
+	}
+
+	assignTracks(hops)
+
+	if hops[1].track >= hops[0].track {
+		t.Errorf("Expected departing hop above arriving hop, got tracks %d and %d", hops[1].track, hops[0].track)
+	}
+}
+
+func TestFlowchart_LabelsOnTrack(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddNode("q", "Deploy to production?", ShapeDiamond).
+		AddNode("yes", "Release", ShapeBox).
+		AddNode("no", "Rollback", ShapeBox).
+		AddEdge("q", "yes", "yes").
+		AddEdge("q", "no", "no")
+
+	output := flow.Render()
+
+	// Both branch labels sit on the track where the edges split
+	found := false
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, BoxTeeUp) {
+			found = strings.Contains(line, " yes ") && strings.Contains(line, " no ")
+		}
+	}
+	if !found {
+		t.Errorf("Expected labels on the branching track, got:\n%s", output)
+	}
+}
+
+func TestFlowchart_StraightEdgeLabel(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddNode("a", "Build", ShapeBox).
+		AddNode("b", "Test", ShapeBox).
+		AddEdge("a", "b", "ok")
+
+	lines := strings.Split(flow.Render(), "\n")
+
+	// The label interrupts the vertical segment right above the arrow
+	if strings.TrimSpace(lines[4]) != "ok" || strings.TrimSpace(lines[5]) != ArrowDown {
+		t.Errorf("Expected label above arrow, got:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestFlowchart_RenderHorizontalLabel(t *testing.T) {
+	flow := NewFlowchart(LeftToRight)
+	flow.AddNode("a", "Build", ShapeBox).
+		AddNode("b", "Test", ShapeBox).
+		AddEdge("a", "b", "ok")
+
+	output := flow.Render()
+
+	if !strings.Contains(output, "─ok─"+ArrowRight) {
+		t.Errorf("Expected label on the edge, got:\n%s", output)
+	}
+}
+
+func TestFlowchart_BackEdge(t *testing.T) {
+	tests := []struct {
+		direction Direction
+		arrow     string
+	}{
+		{TopToBottom, ArrowUp},
+		{LeftToRight, ArrowUp},
+	}
+
+	for _, tt := range tests {
+		flow := NewFlowchart(tt.direction)
+		flow.AddNode("build", "Build", ShapeBox).
+			AddNode("test", "Test", ShapeBox).
+			AddEdge("build", "test", "").
+			AddEdge("test", "build", "retry")
+
+		output := flow.Render()
+
+		if strings.Count(output, tt.arrow) != 1 || !strings.Contains(output, "retry") {
+			t.Errorf("Expected one labelled back edge pointing %s, got:\n%s", tt.arrow, output)
+		}
+	}
+}
+
+func TestFlowchart_BackEdgeBesideForwardEdge(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddNode("a", "Build", ShapeBox).
+		AddNode("b", "Test", ShapeBox).
+		AddEdge("a", "b", "").
+		AddEdge("b", "a", "")
+
+	lines := strings.Split(flow.Render(), "\n")
+
+	// The back edge leaves the source next to the forward edge and enters the target beside its arrow
+	if strings.TrimSpace(lines[3]) != BoxVertical+" "+ArrowUp {
+		t.Errorf("Expected the back edge arrow beside the forward edge, got %q", lines[3])
+	}
+	if !strings.Contains(lines[5], BoxTeeUp) {
+		t.Errorf("Expected the back edge to leave the top of its source, got %q", lines[5])
+	}
+}
+
+func TestFlowchart_BackEdgeKeepsBordersLR(t *testing.T) {
+	flow := NewFlowchart(LeftToRight)
+	flow.AddEdge("a", "b", "").
+		AddEdge("b", "c", "").
+		AddEdge("b", "d", "").
+		AddEdge("d", "a", "")
+
+	l := newFlowLayout(flow)
+	c := l.draw(&Theme{})
+	row := func(n *layoutNode, y int) string {
+		var b strings.Builder
+		for x := n.x; x < n.x+n.w; x++ {
+			b.WriteString(c.cells[y][x].Char)
+		}
+		return b.String()
+	}
+
+	// The back edge runs below both nodes, leaving their corners and bottom borders alone
+	for _, id := range []string{"a", "d"} {
+		n := layoutNodeByID(l, id)
+		if top, bottom := row(n, n.y), row(n, n.y+n.h-1); top != "┌───┐" || bottom != "└───┘" {
+			t.Errorf("Node %s: expected untouched borders, got %q and %q in:\n%s", id, top, bottom, c.String())
+		}
+	}
+	a := layoutNodeByID(l, "a")
+	if below := strings.TrimSpace(row(a, a.y+a.h)); below != ArrowUp {
+		t.Errorf("Expected the arrow one row below the target, got %q in:\n%s", below, c.String())
+	}
+}
+
+func TestFlowchart_SelfLoop(t *testing.T) {
+	tests := []struct {
+		direction Direction
+		expected  []string
+	}{
+		{TopToBottom, []string{"│ Poll ├─┐ wait", "└──────┘←┘"}},
+		{LeftToRight, []string{"└─────┬┘", "  ↑   │", "  └───┘", "wait"}},
+	}
+
+	for _, tt := range tests {
+		flow := NewFlowchart(tt.direction)
+		flow.AddNode("a", "Poll", ShapeBox).AddEdge("a", "a", "wait")
+
+		lines := strings.Split(flow.Render(), "\n")
+		if len(lines) < len(tt.expected)+1 {
+			t.Fatalf("Expected at least %d lines, got:\n%s", len(tt.expected)+1, strings.Join(lines, "\n"))
+		}
+		for i, want := range tt.expected {
+			if got := lines[len(lines)-len(tt.expected)+i]; got != want {
+				t.Errorf("Line %d: expected %q, got %q", i, want, got)
+			}
+		}
+	}
+}
+
+func TestFlowchart_EdgeStyles(t *testing.T) {
+	tests := []struct {
+		direction Direction
+		expected  []string
+	}{
+		{TopToBottom, []string{"┆", "┃", ArrowUp, ArrowDown, "◯", "✕"}},
+		{LeftToRight, []string{"┄", "━", ArrowLeft, ArrowRight, "◯", "✕"}},
+	}
+
+	for _, tt := range tests {
+		flow := NewFlowchart(tt.direction)
+		flow.AddStyledEdge("a", "b", "", EdgeDotted, HeadNone, HeadArrow).
+			AddStyledEdge("b", "c", "", EdgeThick, HeadNone, HeadNone).
+			AddStyledEdge("c", "d", "", EdgeSolid, HeadArrow, HeadArrow).
+			AddStyledEdge("d", "e", "", EdgeSolid, HeadCircle, HeadCross)
+
+		output := flow.Render()
+		for _, want := range tt.expected {
+			if !strings.Contains(output, want) {
+				t.Errorf("Expected %q in:\n%s", want, output)
+			}
+		}
+	}
+}
+
+func TestFlowchart_InvisibleEdge(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddNode("a", "Build", ShapeBox).
+		AddNode("b", "Test", ShapeBox).
+		AddStyledEdge("a", "b", "", EdgeInvisible, HeadNone, HeadArrow)
+
+	l := newFlowLayout(flow)
+	if layoutNodeByID(l, "b").rank != 1 {
+		t.Error("Expected the invisible edge to rank its target below the source")
+	}
+
+	lines := strings.Split(l.draw(&Theme{}).String(), "\n")
+	for _, line := range lines[3:5] {
+		if strings.TrimSpace(line) != "" {
+			t.Errorf("Expected nothing drawn between the nodes, got:\n%s", strings.Join(lines, "\n"))
+		}
+	}
+}
+
+func TestFlowchart_OpenEdge(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddNode("a", "Build", ShapeBox).
+		AddNode("b", "Test", ShapeBox).
+		AddStyledEdge("a", "b", "", EdgeSolid, HeadNone, HeadNone)
+
+	lines := strings.Split(flow.Render(), "\n")
+
+	// Without a marker the line runs into the target's border
+	if !strings.Contains(lines[5], BoxTeeUp) || strings.Contains(flow.Render(), ArrowDown) {
+		t.Errorf("Expected the line to join the target's border, got:\n%s", strings.Join(lines, "\n"))
+	}
+}
@@ -1,7 +1,9 @@
 package diagrams
 
 import (
-	"fmt"
+	"io"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -32,10 +34,26 @@ type Message struct {
 	IsSelf bool // Self-call (actor sends message to itself)
 }
 
+// Autonumber numbers the messages of a sequence diagram from a place in it
+// on, in the order they are drawn, through any fragments around them, until
+// the next Autonumber
+type Autonumber struct {
+	Start int  // Number of the first message it numbers
+	Step  int  // Difference between the numbers of consecutive messages
+	At    int  // Number of messages and notes drawn before it
+	Off   bool // True to stop numbering the messages instead
+}
+
 // SequenceDiagram represents a sequence diagram
 type SequenceDiagram struct {
-	Actors   []Actor
-	Messages []Message
+	Actors      []Actor
+	Messages    []Message
+	Notes       []Note       // Drawn between the messages, in order of their At
+	Fragments   []*Fragment  // Frames around messages and notes, such as loops
+	Activations []Activation // Activation bars along the lifelines, in order of their At
+	Autonumbers []Autonumber // Numbers drawn before the message labels, in order of their At
+
+	open []*Fragment // Fragments started and not yet ended, innermost last
 }
 
 // NewSequenceDiagram creates a new sequence diagram
@@ -68,209 +86,459 @@ func (s *SequenceDiagram) AddMessage(from, to, label string, msgType MessageType
 	return s
 }
 
+// SetAutonumber numbers the messages added from now on, like Mermaid's
+// autonumber, from start in steps of step, drawing each number before the
+// message's label
+func (s *SequenceDiagram) SetAutonumber(start, step int) *SequenceDiagram {
+	s.Autonumbers = append(s.Autonumbers, Autonumber{Start: start, Step: step, At: s.position()})
+	return s
+}
+
+// StopAutonumber stops numbering the messages added from now on, like
+// Mermaid's autonumber off
+func (s *SequenceDiagram) StopAutonumber() *SequenceDiagram {
+	s.Autonumbers = append(s.Autonumbers, Autonumber{At: s.position(), Off: true})
+	return s
+}
+
+// autonumbers returns the autonumbers in order of their At
+func (s *SequenceDiagram) autonumbers() []Autonumber {
+	autonumbers := append([]Autonumber(nil), s.Autonumbers...)
+	sort.SliceStable(autonumbers, func(i, j int) bool { return autonumbers[i].At < autonumbers[j].At })
+	return autonumbers
+}
+
+// numbered returns the diagram as it is drawn: with Autonumbers set, a copy
+// whose message labels start with their numbers
+func (s *SequenceDiagram) numbered() *SequenceDiagram {
+	if len(s.Autonumbers) == 0 {
+		return s
+	}
+	numbered := *s
+	numbered.Messages = append([]Message(nil), s.Messages...)
+	autonumbers := s.autonumbers()
+	var current *Autonumber
+	number, m := 0, 0
+	for at, it := range s.items() {
+		for ; len(autonumbers) > 0 && autonumbers[0].At <= at; autonumbers = autonumbers[1:] {
+			current, number = &autonumbers[0], autonumbers[0].Start
+			if current.Off {
+				current = nil
+			}
+		}
+		if it.message == nil {
+			continue
+		}
+		if msg := &numbered.Messages[m]; current != nil {
+			prefix := strconv.Itoa(number) + "."
+			if msg.Label != "" {
+				prefix += " "
+			}
+			msg.Label = prefix + msg.Label
+			number += current.Step
+		}
+		m++
+	}
+	return &numbered
+}
+
 // Render converts the sequence diagram to ASCII art
 func (s *SequenceDiagram) Render() string {
+	return s.RenderWithOptions(RenderOptions{})
+}
+
+// Widths of sequence diagram columns, in terminal cells
+const (
+	seqActorWidth    = 12 // Narrowest column for an actor's name
+	seqSpacing       = 6  // Narrowest space between columns
+	seqMinActorWidth = 3  // Narrowest column when squeezed into MaxWidth
+	seqMinSpacing    = 2  // Narrowest space between columns when squeezed
+)
+
+// RenderWithOptions renders the sequence diagram like Render, in the options'
+// theme, charset and colour profile. Each column is as wide as its actor's
+// name, and the space between two lifelines as wide as the longest message
+// between them. When the diagram is wider than MaxWidth, the space between
+// columns and then the columns themselves are narrowed, shortening names and
+// messages.
+func (s *SequenceDiagram) RenderWithOptions(opts RenderOptions) string {
+	return s.canvas(opts).render(opts)
+}
+
+// RenderTo writes the sequence diagram to w as RenderWithOptions renders it,
+// one row at a time, and returns the number of bytes written
+func (s *SequenceDiagram) RenderTo(w io.Writer, opts RenderOptions) (int64, error) {
+	return s.canvas(opts).writeTo(w, opts)
+}
+
+// WriteTo writes the sequence diagram to w as Render renders it, implementing
+// io.WriterTo
+func (s *SequenceDiagram) WriteTo(w io.Writer) (int64, error) {
+	return s.RenderTo(w, RenderOptions{})
+}
+
+// Size returns the columns and rows the sequence diagram takes when rendered
+// with opts
+func (s *SequenceDiagram) Size(opts RenderOptions) (int, int) {
 	if len(s.Actors) == 0 {
-		return ""
+		return 0, 0
 	}
+	s = s.numbered().fitNotes(opts.MaxWidth)
+	return opts.clamp(s.layout(opts.MaxWidth).width, s.height())
+}
 
-	var output strings.Builder
-	actorWidth := 12 // Fixed width for actor names
-	spacing := 6     // Space between actors
+// seqItem is a message or a note, in the order they are drawn
+type seqItem struct {
+	message *Message
+	note    *Note
+}
 
-	// Build actor index map
-	actorIndex := make(map[string]int)
-	for i, actor := range s.Actors {
-		actorIndex[actor.ID] = i
+// items returns the messages and notes in order. Notes are placed by their
+// At, keeping their order when several are at the same place, and messages
+// fill the places in between.
+func (s *SequenceDiagram) items() []seqItem {
+	notes := make([]*Note, len(s.Notes))
+	for i := range s.Notes {
+		notes[i] = &s.Notes[i]
 	}
+	sort.SliceStable(notes, func(i, j int) bool { return notes[i].At < notes[j].At })
 
-	// Render actor headers
-	for i, actor := range s.Actors {
-		if i > 0 {
-			output.WriteString(strings.Repeat(" ", spacing))
+	items := make([]seqItem, 0, len(s.Messages)+len(notes))
+	for m := 0; m < len(s.Messages) || len(notes) > 0; {
+		if len(notes) > 0 && (notes[0].At <= len(items) || m == len(s.Messages)) {
+			items = append(items, seqItem{note: notes[0]})
+			notes = notes[1:]
+		} else {
+			items = append(items, seqItem{message: &s.Messages[m]})
+			m++
 		}
-		output.WriteString(padCenter(actor.Name, actorWidth))
 	}
-	output.WriteString("\n")
+	return items
+}
 
-	// Render actor boxes
-	for i := range s.Actors {
-		if i > 0 {
-			output.WriteString(strings.Repeat(" ", spacing))
-		}
-		output.WriteString(BoxTopLeft)
-		output.WriteString(strings.Repeat(BoxHorizontal, actorWidth-2))
-		output.WriteString(BoxTopRight)
+// actors returns the IDs of the actors an item is drawn against
+func (it seqItem) actors() []string {
+	if it.note != nil {
+		return it.note.Actors
 	}
-	output.WriteString("\n")
+	return []string{it.message.From, it.message.To}
+}
 
-	// Render lifelines and messages
-	for _, msg := range s.Messages {
-		fromIdx := actorIndex[msg.From]
-		toIdx := actorIndex[msg.To]
+// rows returns the rows an item takes: a lifeline row above a message, or
+// above a note's box
+func (it seqItem) rows() int {
+	if it.note != nil {
+		_, height := it.note.size()
+		return height + 1
+	}
+	return 2
+}
 
-		// Render lifelines
-		for i := range s.Actors {
-			if i > 0 {
-				output.WriteString(strings.Repeat(" ", spacing))
+// seqSpan asks for at least width columns between the lifelines of two actors
+type seqSpan struct{ from, to, width int }
+
+// seqLayout holds the widths of a sequence diagram's columns
+type seqLayout struct {
+	columns  []int // Width of each actor's column
+	gaps     []int // Space after each column but the last
+	margin   int   // Space left of the first column, for notes and fragments
+	maxWidth int   // Width notes are fitted in, 0 meaning no limit
+	width    int   // Width of the diagram, including self-call labels, notes and fragments
+}
+
+// left returns the first x of column i
+func (l *seqLayout) left(i int) int {
+	x := l.margin
+	for j := 0; j < i; j++ {
+		x += l.columns[j] + l.gaps[j]
+	}
+	return x
+}
+
+// center returns the x of column i's lifeline
+func (l *seqLayout) center(i int) int {
+	return l.left(i) + l.columns[i]/2
+}
+
+// layout sizes each column to its actor's name and widens the space between
+// columns until every message label and note fits between its lifelines,
+// narrowing both again to fit in maxWidth, 0 meaning no limit
+func (s *SequenceDiagram) layout(maxWidth int) *seqLayout {
+	n := len(s.Actors)
+	l := &seqLayout{columns: make([]int, n), gaps: make([]int, max(n-1, 0))}
+	for i, actor := range s.Actors {
+		l.columns[i] = max(seqActorWidth, StringWidth(actor.Name))
+	}
+	for i := range l.gaps {
+		l.gaps[i] = seqSpacing
+	}
+
+	// A message label needs a space and two lines on each side and an
+	// arrowhead, and a self-call label its arrow, brackets and a space. Labels
+	// spanning fewer gaps are fitted first, so that longer spans only widen
+	// what they still need.
+	var spans []seqSpan
+	index := s.actorIndex()
+	for _, msg := range s.Messages {
+		from, okFrom := index[msg.From]
+		to, okTo := index[msg.To]
+		if !okFrom || !okTo {
+			continue
+		}
+		width := StringWidth(msg.Label) + 7
+		if msg.IsSelf {
+			// Self-calls are labelled towards the next lifeline
+			if to = from + 1; to == n {
+				continue
 			}
-			center := actorWidth / 2
-			output.WriteString(strings.Repeat(" ", center))
-			output.WriteString(BoxVertical)
-			output.WriteString(strings.Repeat(" ", actorWidth-center-1))
+			width = StringWidth(msg.Label) + 5
 		}
-		output.WriteString("\n")
+		spans = append(spans, seqSpan{min(from, to), max(from, to), width})
+	}
+	for _, note := range s.Notes {
+		spans = append(spans, note.spans(index, n)...)
+	}
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].to-spans[i].from < spans[j].to-spans[j].from })
+	for _, sp := range spans {
+		need := sp.width - (l.center(sp.to) - l.center(sp.from))
+		count := sp.to - sp.from
+		for k := 0; need > 0 && k < count; k++ {
+			// Later gaps take the remainder
+			l.gaps[sp.from+k] += need / count
+			if k >= count-need%count {
+				l.gaps[sp.from+k]++
+			}
+		}
+	}
 
-		// Render message line
-		if msg.IsSelf {
-			renderSelfMessage(&output, fromIdx, actorWidth, spacing, len(s.Actors), msg)
-		} else {
-			renderMessage(&output, fromIdx, toIdx, actorWidth, spacing, len(s.Actors), msg)
+	// Notes beside the outer lifelines keep room past them to wrap in
+	before, after := s.outerNoteRoom(index)
+	past := func() (int, int) {
+		if n == 0 {
+			return 0, 0
+		}
+		return max(before-l.columns[0]/2, 0), max(after-(l.columns[n-1]-1-l.columns[n-1]/2), 0)
+	}
+	if left, right := past(); maxWidth > 0 && left+sum(l.columns)+sum(l.gaps)+right > maxWidth {
+		fit := maxWidth - left - right
+		l.gaps = fitTracks(l.gaps, 0, max(fit-sum(l.columns), seqMinSpacing*len(l.gaps)))
+		l.columns = fitTracks(l.columns, 0, max(fit-sum(l.gaps), seqMinActorWidth*n))
+
+		// Narrower columns make room for notes between their lifelines
+		if l.keepNoteRoom(s.Notes, index) {
+			l.columns = fitTracks(l.columns, 0, max(fit-sum(l.gaps), seqMinActorWidth*n))
 		}
-		output.WriteString("\n")
 	}
 
-	// Render closing lifelines
-	for i := range s.Actors {
-		if i > 0 {
-			output.WriteString(strings.Repeat(" ", spacing))
+	// Notes and fragments left of the first lifeline move the columns right,
+	// as far as maxWidth allows
+	for _, note := range s.Notes {
+		if x, _, ok := note.box(l, index); ok {
+			l.margin = max(l.margin, -x)
 		}
-		center := actorWidth / 2
-		output.WriteString(strings.Repeat(" ", center))
-		output.WriteString(BoxVertical)
-		output.WriteString(strings.Repeat(" ", actorWidth-center-1))
 	}
-	output.WriteString("\n")
+	items := s.items()
+	for _, box := range s.fragmentBoxes(l, items, index) {
+		l.margin = max(l.margin, -box[0])
+	}
+	if maxWidth > 0 {
+		_, right := past()
+		l.margin = min(l.margin, max(maxWidth-sum(l.columns)-sum(l.gaps)-right, 0))
+		l.maxWidth = maxWidth
+	}
 
-	// Render actor bottom boxes
-	for i := range s.Actors {
-		if i > 0 {
-			output.WriteString(strings.Repeat(" ", spacing))
+	l.width = l.margin + sum(l.columns) + sum(l.gaps)
+	for _, msg := range s.Messages {
+		if i, ok := index[msg.From]; ok && msg.IsSelf {
+			l.width = max(l.width, l.center(i)+StringWidth(msg.Label)+4)
 		}
-		output.WriteString(BoxBottomLeft)
-		output.WriteString(strings.Repeat(BoxHorizontal, actorWidth-2))
-		output.WriteString(BoxBottomRight)
 	}
+	for _, note := range s.Notes {
+		if x, width, ok := note.box(l, index); ok {
+			l.width = max(l.width, x+width)
+		}
+	}
+	for _, box := range s.fragmentBoxes(l, items, index) {
+		l.width = max(l.width, box[1]+1)
+	}
+	return l
+}
 
-	return output.String()
+// sum returns the total of values
+func sum(values []int) int {
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+	return total
 }
 
-func renderMessage(output *strings.Builder, fromIdx, toIdx, actorWidth, spacing, numActors int, msg Message) {
-	minIdx := min(fromIdx, toIdx)
-	maxIdx := max(fromIdx, toIdx)
+// height returns the rows of the diagram: names, top edges, the rows of each
+// message, note and fragment edge, a closing lifeline row and the bottom edges
+func (s *SequenceDiagram) height() int {
+	height := 4
+	for _, st := range s.steps() {
+		height += st.rows()
+	}
+	return height
+}
 
-	arrow := ArrowRight
-	lineChar := BoxHorizontal
-	if msg.Type == MessageAsync || msg.Type == MessageReturn {
-		lineChar = "-"
+// actorIndex maps actor IDs to their column
+func (s *SequenceDiagram) actorIndex() map[string]int {
+	index := make(map[string]int)
+	for i, actor := range s.Actors {
+		index[actor.ID] = i
+	}
+	return index
+}
+
+// canvas draws the diagram on a canvas no wider than MaxWidth, an empty one
+// when it has no actors
+func (s *SequenceDiagram) canvas(opts RenderOptions) *Canvas {
+	if len(s.Actors) == 0 {
+		return NewCanvas(0, 0)
 	}
-	if fromIdx > toIdx {
-		arrow = ArrowLeft
+	s = s.numbered().fitNotes(opts.MaxWidth)
+	l := s.layout(opts.MaxWidth)
+	actorIndex := s.actorIndex()
+	left, center := l.left, l.center
+
+	width := l.width
+	if opts.MaxWidth > 0 {
+		width = min(width, opts.MaxWidth)
 	}
+	bottom := s.height() - 1
+	c := NewCanvas(width, s.height())
+	theme := opts.theme()
+	border := theme.Node.Border
 
-	// Leading spaces before first actor
-	for i := 0; i < minIdx; i++ {
-		if i > 0 {
-			output.WriteString(strings.Repeat(" ", spacing))
+	for i, actor := range s.Actors {
+		right := left(i) + l.columns[i] - 1
+		c.Text(left(i), 0, padCenter(actor.Name, l.columns[i]), theme.Actor)
+		c.Path(theme.Lifeline, Point{center(i), 1}, Point{center(i), bottom})
+
+		// The box edges merge with the lifeline into tees
+		c.Set(left(i), 1, BoxTopLeft, border)
+		c.HLine(left(i)+1, right-1, 1, border)
+		c.Set(right, 1, BoxTopRight, border)
+
+		c.Set(left(i), bottom, BoxBottomLeft, border)
+		c.HLine(left(i)+1, right-1, bottom, border)
+		c.Set(right, bottom, BoxBottomRight, border)
+	}
+
+	// Activation bars are drawn over the lifelines, from and to the last row
+	// of a message or note, and again over the messages
+	var anchors []int
+	row := 2
+	for _, st := range s.steps() {
+		row += st.rows()
+		if st.fragment == nil {
+			anchors = append(anchors, row-1)
+		}
+	}
+	bars := s.activationBars(anchors, 2, bottom-1, actorIndex)
+	for _, bar := range bars {
+		drawActivation(c, center(bar.actor), bar, theme.Activation, false)
+	}
+
+	// Fragment edges are found on the way down and framed after the messages,
+	// and notes are drawn last so that their borders don't join the frames
+	tops, bottoms := make(map[*Fragment]int), make(map[*Fragment]int)
+	separators := make(map[*Fragment][]int)
+	var fragments []*Fragment
+	notes := make(map[*Note]int)
+	row = 2
+	for _, st := range s.steps() {
+		y := row + 1
+		row += st.rows()
+		if f := st.fragment; f != nil {
+			switch st.section {
+			case 0:
+				tops[f] = y
+				fragments = append(fragments, f)
+			case -1:
+				bottoms[f] = y
+			default:
+				separators[f] = append(separators[f], y)
+			}
+			continue
+		}
+
+		it := st.item
+		if it.note != nil {
+			notes[it.note] = y
+			continue
 		}
-		center := actorWidth / 2
-		output.WriteString(strings.Repeat(" ", center))
-		output.WriteString(BoxVertical)
-		output.WriteString(strings.Repeat(" ", actorWidth-center-1))
-	}
-
-	// From actor to message start
-	if minIdx > 0 {
-		output.WriteString(strings.Repeat(" ", spacing))
-	}
-	center := actorWidth / 2
-	output.WriteString(strings.Repeat(" ", center))
-
-	// Message line
-	labelLen := len(msg.Label)
-	// Calculate total width from center of fromIdx to center of toIdx
-	totalWidth := (maxIdx - minIdx) * (actorWidth + spacing)
-
-	// Available width for line = totalWidth - label - spaces - arrow
-	availableForLine := totalWidth - labelLen - 4 // 2 spaces around label + arrow width
-
-	// Ensure minimum line length
-	if availableForLine < 2 {
-		availableForLine = 2
-	}
-
-	if fromIdx < toIdx {
-		// Left to right: draw line, label, line, arrow
-		leftLine := availableForLine / 2
-		rightLine := availableForLine - leftLine
-
-		output.WriteString(strings.Repeat(lineChar, leftLine))
-		output.WriteString(" ")
-		output.WriteString(msg.Label)
-		output.WriteString(" ")
-		output.WriteString(strings.Repeat(lineChar, rightLine))
-		output.WriteString(arrow)
-	} else {
-		// Right to left: arrow, line, label, line
-		leftLine := availableForLine / 2
-		rightLine := availableForLine - leftLine
-
-		output.WriteString(arrow)
-		output.WriteString(strings.Repeat(lineChar, leftLine))
-		output.WriteString(" ")
-		output.WriteString(msg.Label)
-		output.WriteString(" ")
-		output.WriteString(strings.Repeat(lineChar, rightLine))
-	}
-
-	// Trailing spaces after last actor
-	for i := maxIdx + 1; i < numActors; i++ {
-		output.WriteString(strings.Repeat(" ", spacing))
-		center := actorWidth / 2
-		output.WriteString(strings.Repeat(" ", center))
-		output.WriteString(BoxVertical)
-		output.WriteString(strings.Repeat(" ", actorWidth-center-1))
-	}
-}
-
-func renderSelfMessage(output *strings.Builder, actorIdx, actorWidth, spacing, numActors int, msg Message) {
-	// Leading actors
-	for i := 0; i < actorIdx; i++ {
-		if i > 0 {
-			output.WriteString(strings.Repeat(" ", spacing))
+
+		msg := *it.message
+		if msg.IsSelf {
+			i := actorIndex[msg.From]
+			limit := width
+			if i+1 < len(s.Actors) {
+				limit = center(i+1) - 1
+			}
+			drawSelfMessage(c, center(i), limit, y, msg, theme)
+		} else {
+			drawMessage(c, center(actorIndex[msg.From]), center(actorIndex[msg.To]), y, msg, theme)
 		}
-		center := actorWidth / 2
-		output.WriteString(strings.Repeat(" ", center))
-		output.WriteString(BoxVertical)
-		output.WriteString(strings.Repeat(" ", actorWidth-center-1))
 	}
 
-	// Self-message actor
-	if actorIdx > 0 {
-		output.WriteString(strings.Repeat(" ", spacing))
+	for _, bar := range bars {
+		drawActivation(c, center(bar.actor), bar, theme.Activation, true)
 	}
-	center := actorWidth / 2
-	output.WriteString(strings.Repeat(" ", center))
-	output.WriteString(BoxVertical)
-	output.WriteString(ArrowRight)
-	output.WriteString(fmt.Sprintf("[%s]", msg.Label))
 
-	// Trailing actors (if any)
-	for i := actorIdx + 1; i < numActors; i++ {
-		output.WriteString(strings.Repeat(" ", spacing))
-		center := actorWidth / 2
-		output.WriteString(strings.Repeat(" ", center))
-		output.WriteString(BoxVertical)
-		output.WriteString(strings.Repeat(" ", actorWidth-center-1))
+	boxes := s.fragmentBoxes(l, s.items(), actorIndex)
+	for _, f := range fragments {
+		box := boxes[f]
+		drawFragment(c, f, box[0], box[1], tops[f], bottoms[f], separators[f], theme)
 	}
+	for i := range s.Notes {
+		note := &s.Notes[i]
+		if x, width, ok := note.box(l, actorIndex); ok {
+			drawNote(c, x, notes[note], width, *note, theme.Note)
+		}
+	}
+
+	return c
 }
 
-func padCenter(s string, width int) string {
-	if len(s) >= width {
-		return s[:width]
+// drawMessage draws a message arrow on row y between the lifelines at fromX and toX
+func drawMessage(c *Canvas, fromX, toX, y int, msg Message, theme *Theme) {
+	arrow, arrowX := ArrowRight, toX-1
+	if fromX > toX {
+		arrow, arrowX = ArrowLeft, toX+1
+	}
+
+	line := LineLight
+	if msg.Type == MessageAsync || msg.Type == MessageReturn {
+		line = LineDashed
 	}
-	leftPad := (width - len(s)) / 2
-	rightPad := width - len(s) - leftPad
+	c.LinePath(line, theme.Edge, Point{fromX, y}, Point{arrowX, y})
+	c.Set(arrowX, y, arrow, theme.Edge)
+
+	// Centre the label on the line between the two lifelines, shortened to fit
+	lo, hi := min(fromX, toX)+1, max(fromX, toX)-1
+	label := " " + Truncate(msg.Label, hi-lo-3) + " "
+	if StringWidth(label) > hi-lo-1 {
+		return
+	}
+	start := lo + (hi-lo+1-StringWidth(label))/2
+	c.Text(max(start, lo+1), y, label, theme.EdgeLabel)
+}
+
+// drawSelfMessage draws a self-call next to the lifeline at x on row y,
+// shortened to end before the column limit
+func drawSelfMessage(c *Canvas, x, limit, y int, msg Message, theme *Theme) {
+	c.Set(x+1, y, ArrowRight, theme.Edge)
+	c.Text(x+2, y, "["+Truncate(msg.Label, limit-x-4)+"]", theme.EdgeLabel)
+}
+
+// padCenter centres s in width columns, truncating it if it is too wide
+func padCenter(s string, width int) string {
+	s = Truncate(s, width)
+	leftPad := (width - StringWidth(s)) / 2
+	rightPad := width - StringWidth(s) - leftPad
 	return strings.Repeat(" ", leftPad) + s + strings.Repeat(" ", rightPad)
 }
 
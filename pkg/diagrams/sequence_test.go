@@ -140,15 +140,111 @@ func TestSequenceDiagram_Render_Empty(t *testing.T) {
 	}
 }
 
+func TestSequenceDiagram_Render_ContentSized(t *testing.T) {
+	seq := NewSequenceDiagram().
+		AddActor("c", "Checkout").
+		AddActor("p", "PaymentGateway").
+		AddActor("b", "Bank").
+		AddMessage("c", "p", "authorize(card, amount, currency)", MessageSync).
+		AddMessage("p", "b", "charge", MessageSync).
+		AddMessage("p", "p", "record attempt in ledger", MessageSync).
+		AddMessage("b", "c", "webhook: payment settled", MessageAsync).
+		AddMessage("p", "c", "ok", MessageReturn)
+
+	output := seq.Render()
+	for _, want := range []string{"PaymentGateway", "authorize(card, amount, currency)", "[record attempt in ledger]", "webhook: payment settled"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected %q in:\n%s", want, output)
+		}
+	}
+
+	// Every arrowhead lands next to its target's lifeline
+	lines := strings.Split(output, "\n")
+	var lifelines []int
+	for x, r := range []rune(lines[2]) {
+		if string(r) == BoxVertical {
+			lifelines = append(lifelines, x)
+		}
+	}
+	index := seq.actorIndex()
+	for i, msg := range seq.Messages {
+		if msg.IsSelf {
+			continue
+		}
+		row := []rune(lines[2*i+3])
+		target := lifelines[index[msg.To]]
+		arrow, at := ArrowRight, target-1
+		if index[msg.To] < index[msg.From] {
+			arrow, at = ArrowLeft, target+1
+		}
+		if string(row[at]) != arrow || string(row[target]) != BoxVertical {
+			t.Errorf("Expected message %q to end at column %d:\n%s", msg.Label, target, string(row))
+		}
+	}
+}
+
+func TestSequenceDiagram_Render_DashedCrossing(t *testing.T) {
+	seq := NewSequenceDiagram().AddActor("A", "Alice").AddActor("B", "Bob").AddActor("C", "Carol").
+		AddMessage("B", "C", "a much longer request", MessageSync).
+		AddMessage("C", "A", "done", MessageAsync)
+
+	// The dashed line crosses Bob's lifeline rather than hiding it
+	expected := strings.Join([]string{
+		"   Alice              Bob                        Carol",
+		"┌─────┬────┐      ┌─────┬────┐                ┌─────┬────┐",
+		"      │                 │                           │",
+		"      │                 ├── a much longer request ─→│",
+		"      │                 │                           │",
+		"      │←┄┄┄┄┄┄┄┄┄┄┄┄┄┄┄┄┼┄ done ┄┄┄┄┄┄┄┄┄┄┄┄┄┄┄┄┄┄┄┄┤",
+		"      │                 │                           │",
+		"└─────┴────┘      └─────┴────┘                └─────┴────┘",
+	}, "\n")
+	if got := seq.Render(); got != expected {
+		t.Errorf("Expected:\n%s\n\nGot:\n%s", expected, got)
+	}
+}
+
+func TestSequenceDiagram_SetAutonumber(t *testing.T) {
+	seq := NewSequenceDiagram().AddActor("A", "Alice").AddActor("B", "Bob").
+		SetAutonumber(10, 5).
+		AddMessage("A", "B", "call", MessageSync).
+		StartFragment(FragmentLoop, "retry").
+		AddNote(NoteOver, "wait", "B").
+		AddMessage("B", "B", "work", MessageSync).
+		EndFragment().
+		AddMessage("B", "A", "", MessageReturn).
+		StopAutonumber().
+		AddMessage("A", "B", "done", MessageSync)
+
+	output := seq.Render()
+	for _, want := range []string{"─ 10. call ─", "→[15. work]", "┄ 20. ┄", "─ done ─"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected %q in:\n%s", want, output)
+		}
+	}
+	if seq.Messages[0].Label != "call" {
+		t.Errorf("Expected the labels unchanged, got %q", seq.Messages[0].Label)
+	}
+	widest := 0
+	for _, line := range strings.Split(output, "\n") {
+		widest = max(widest, StringWidth(line))
+	}
+	if width, _ := seq.Size(RenderOptions{}); width != widest {
+		t.Errorf("Expected Size to make room for the numbers, got width %d for:\n%s", width, output)
+	}
+}
+
 func TestPadCenter(t *testing.T) {
 	tests := []struct {
 		input  string
 		width  int
 		verify func(string) bool
 	}{
-		{"Hello", 10, func(s string) bool { return len(s) == 10 && strings.Contains(s, "Hello") }},
-		{"Test", 8, func(s string) bool { return len(s) == 8 }},
-		{"LongString", 5, func(s string) bool { return len(s) == 5 }}, // Truncated
+		{"Hello", 10, func(s string) bool { return StringWidth(s) == 10 && strings.Contains(s, "Hello") }},
+		{"Test", 8, func(s string) bool { return StringWidth(s) == 8 }},
+		{"LongString", 5, func(s string) bool { return s == "Long…" }}, // Truncated
+		{"café", 6, func(s string) bool { return s == " café " }},
+		{"日本語", 5, func(s string) bool { return s == "日本…" }}, // Truncated between wide characters
 	}
 
 	for _, tt := range tests {
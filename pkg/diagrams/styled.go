@@ -0,0 +1,209 @@
+package diagrams
+
+import (
+	"strconv"
+	"strings"
+)
+
+// TextRun is a piece of text drawn in a single style
+type TextRun struct {
+	Text  string
+	Style Style
+}
+
+// StyledText is text made of runs in different styles, for labels, names and
+// titles that mix styles.
+//
+// Diagrams take labels as strings, so a StyledText is passed by its String
+// method, which encodes the styles as ANSI escape sequences. Renderers decode
+// them again: escape sequences take no columns when text is measured, and
+// every cell is drawn in its own style with a reset at the end of each row,
+// so colours never bleed into borders, lifelines or other rows. Escape codes
+// written into labels by hand are handled the same way.
+type StyledText []TextRun
+
+// Styled returns text in a single style
+func Styled(text string, style Style) StyledText {
+	return StyledText{{Text: text, Style: style}}
+}
+
+// Append adds a run of text in the given style
+func (t StyledText) Append(text string, style Style) StyledText {
+	return append(t, TextRun{Text: text, Style: style})
+}
+
+// String encodes the text with ANSI escape sequences, resetting the style
+// after each styled run
+func (t StyledText) String() string {
+	var b strings.Builder
+	for _, r := range t {
+		if r.Style.IsZero() {
+			b.WriteString(r.Text)
+			continue
+		}
+		b.WriteString(r.Style.sequence())
+		b.WriteString(r.Text)
+		b.WriteString(sgrReset)
+	}
+	return b.String()
+}
+
+// Plain returns the text without styles
+func (t StyledText) Plain() string {
+	var b strings.Builder
+	for _, r := range t {
+		b.WriteString(r.Text)
+	}
+	return b.String()
+}
+
+// Width returns the number of terminal columns the text takes up
+func (t StyledText) Width() int {
+	width := 0
+	for _, r := range t {
+		width += StringWidth(r.Text)
+	}
+	return width
+}
+
+// ParseStyledText decodes text containing ANSI escape sequences into runs.
+// SGR sequences (colours, bold, italic and underline) set the style of the
+// text after them, starting from base; other escape sequences, such as OSC
+// hyperlinks and cursor movement, are dropped.
+func ParseStyledText(s string, base Style) StyledText {
+	var t StyledText
+	style := base
+	for len(s) > 0 {
+		next := strings.IndexByte(s, '\x1b')
+		if next < 0 {
+			next = len(s)
+		}
+		if next > 0 {
+			if n := len(t); n > 0 && t[n-1].Style == style {
+				t[n-1].Text += s[:next]
+			} else {
+				t = append(t, TextRun{Text: s[:next], Style: style})
+			}
+			s = s[next:]
+			continue
+		}
+
+		n := escapeLen(s)
+		if params, ok := sgrParams(s[:n]); ok {
+			style = applySGR(style, base, params)
+		}
+		s = s[n:]
+	}
+	return t
+}
+
+// sgrReset returns the terminal to its default style
+const sgrReset = "\x1b[0m"
+
+// escapeLen returns the length of the escape sequence at the start of s,
+// which must begin with ESC: a CSI sequence (ESC [ ... final byte), an OSC
+// string (ESC ] ... ended by BEL or ESC \) or a two-byte escape
+func escapeLen(s string) int {
+	if len(s) < 2 {
+		return len(s)
+	}
+	switch s[1] {
+	case '[':
+		for i := 2; i < len(s); i++ {
+			if s[i] >= 0x40 && s[i] <= 0x7e {
+				return i + 1
+			}
+		}
+		return len(s)
+	case ']', 'P', '_', '^':
+		for i := 2; i < len(s); i++ {
+			switch {
+			case s[i] == '\a':
+				return i + 1
+			case s[i] == '\x1b' && i+1 < len(s) && s[i+1] == '\\':
+				return i + 2
+			}
+		}
+		return len(s)
+	}
+	return 2
+}
+
+// sgrParams returns the parameters of an SGR sequence such as ESC [ 1 ; 31 m
+func sgrParams(seq string) ([]int, bool) {
+	if len(seq) < 3 || seq[1] != '[' || seq[len(seq)-1] != 'm' {
+		return nil, false
+	}
+	body := seq[2 : len(seq)-1]
+	if body == "" {
+		return []int{0}, true
+	}
+	var params []int
+	for _, field := range strings.FieldsFunc(body, func(r rune) bool { return r == ';' || r == ':' }) {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, false
+		}
+		params = append(params, n)
+	}
+	return params, true
+}
+
+// applySGR returns style changed by SGR parameters; a reset returns to base.
+// Attributes that Style can't represent, such as blink, are ignored.
+func applySGR(style, base Style, params []int) Style {
+	for i := 0; i < len(params); i++ {
+		switch p := params[i]; {
+		case p == 0:
+			style = base
+		case p == 1:
+			style.Bold = true
+		case p == 3:
+			style.Italic = true
+		case p == 4:
+			style.Underline = true
+		case p == 22:
+			style.Bold = false
+		case p == 23:
+			style.Italic = false
+		case p == 24:
+			style.Underline = false
+		case p >= 30 && p <= 37, p >= 90 && p <= 97:
+			style.Fg = sgr(p)
+		case p == 39:
+			style.Fg = ""
+		case p >= 40 && p <= 47, p >= 100 && p <= 107:
+			style.Bg = sgr(p)
+		case p == 49:
+			style.Bg = ""
+		case p == 38 || p == 48:
+			// Extended colour: 5;n for the 256-colour palette, 2;r;g;b for true colour
+			n := 0
+			if i+1 < len(params) && params[i+1] == 5 {
+				n = 2
+			} else if i+1 < len(params) && params[i+1] == 2 {
+				n = 4
+			}
+			if n == 0 || i+n >= len(params) {
+				return style
+			}
+			seq := sgr(params[i : i+n+1]...)
+			if p == 38 {
+				style.Fg = seq
+			} else {
+				style.Bg = seq
+			}
+			i += n
+		}
+	}
+	return style
+}
+
+// sgr builds an SGR sequence from its parameters
+func sgr(params ...int) string {
+	fields := make([]string, len(params))
+	for i, p := range params {
+		fields[i] = strconv.Itoa(p)
+	}
+	return "\x1b[" + strings.Join(fields, ";") + "m"
+}
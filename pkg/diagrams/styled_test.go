@@ -0,0 +1,146 @@
+package diagrams
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseStyledText(t *testing.T) {
+	red := Style{Fg: "\x1b[31m"}
+	tests := []struct {
+		name     string
+		input    string
+		expected StyledText
+	}{
+		{"plain", "plain", StyledText{{Text: "plain"}}},
+		{"colour and reset", "a\x1b[31mred\x1b[0mb", StyledText{{Text: "a"}, {"red", red}, {Text: "b"}}},
+		{"combined", "\x1b[1;4;44mx", StyledText{{"x", Style{Bold: true, Underline: true, Bg: "\x1b[44m"}}}},
+		{"256 colour", "\x1b[38;5;208mx", StyledText{{"x", Style{Fg: "\x1b[38;5;208m"}}}},
+		{"true colour", "\x1b[48;2;10;20;30mx", StyledText{{"x", Style{Bg: "\x1b[48;2;10;20;30m"}}}},
+		{"attributes off", "\x1b[1;3mx\x1b[22my", StyledText{{"x", Style{Bold: true, Italic: true}}, {"y", Style{Italic: true}}}},
+		{"default colour", "\x1b[31mx\x1b[39my", StyledText{{"x", red}, {Text: "y"}}},
+		{"hyperlink dropped", "\x1b]8;;https://example.com\x1b\\link\x1b]8;;\x07", StyledText{{Text: "link"}}},
+		{"cursor movement dropped", "a\x1b[2Kb", StyledText{{Text: "ab"}}},
+	}
+
+	for _, tt := range tests {
+		got := ParseStyledText(tt.input, Style{})
+		if len(got) != len(tt.expected) {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.expected[i] {
+				t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, got)
+				break
+			}
+		}
+	}
+}
+
+func TestParseStyledText_ResetToBase(t *testing.T) {
+	base := Style{Fg: "\x1b[32m"}
+	got := ParseStyledText("\x1b[1mx\x1b[0my", base)
+
+	if len(got) != 2 || got[0].Style != (Style{Fg: "\x1b[32m", Bold: true}) || got[1].Style != base {
+		t.Errorf("Expected a reset to return to the base style, got %q", got)
+	}
+}
+
+func TestStyledText_String(t *testing.T) {
+	text := Styled("Error", Style{Fg: "\x1b[31m", Bold: true}).Append(": disk full", Style{})
+
+	expected := "\x1b[1m\x1b[31mError\x1b[0m: disk full"
+	if text.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, text.String())
+	}
+	if text.Width() != 16 || text.Plain() != "Error: disk full" {
+		t.Errorf("Expected 16 columns of %q, got %d of %q", "Error: disk full", text.Width(), text.Plain())
+	}
+
+	// Encoding and decoding gives back the same runs
+	decoded := ParseStyledText(text.String(), Style{})
+	if len(decoded) != 2 || decoded[0] != text[0] || decoded[1] != text[1] {
+		t.Errorf("Expected %q, got %q", text, decoded)
+	}
+}
+
+func TestStringWidth_EscapeSequences(t *testing.T) {
+	tests := []struct {
+		input string
+		width int
+	}{
+		{"\x1b[31mred\x1b[0m", 3},
+		{"\x1b[1;38;2;255;0;0m日本\x1b[0m", 4},
+		{"\x1b]8;;https://example.com\x07link\x1b]8;;\x07", 4},
+	}
+
+	for _, tt := range tests {
+		if got := StringWidth(tt.input); got != tt.width {
+			t.Errorf("StringWidth(%q) = %d, expected %d", tt.input, got, tt.width)
+		}
+	}
+}
+
+func TestTruncate_EscapeSequences(t *testing.T) {
+	got := Truncate("\x1b[31mPaymentGateway\x1b[0m", 8)
+
+	// The reset after the removed text is kept
+	if got != "\x1b[31mPayment…\x1b[0m" {
+		t.Errorf("Expected the colour kept and reset, got %q", got)
+	}
+}
+
+func TestCanvas_TextStyled(t *testing.T) {
+	c := NewCanvas(6, 1)
+	c.Text(0, 0, "a\x1b[31mbc\x1b[0md", Style{})
+
+	if c.Cell(1, 0).Style.Fg != "\x1b[31m" || c.Cell(3, 0).Style.Fg != "" {
+		t.Errorf("Expected only b and c in red, got %+v", c.cells[0])
+	}
+	if output := c.String(); output != "a\x1b[31mbc\x1b[0md" {
+		t.Errorf("Expected %q, got %q", "a\x1b[31mbc\x1b[0md", output)
+	}
+}
+
+func TestFlowchart_RenderColouredLabels(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddNode("a", "\x1b[31mFailed\x1b[0m", ShapeBox).
+		AddNode("b", Styled("Retry", Style{Fg: "\x1b[33m"}).Append(" 3x", Style{}).String(), ShapeBox).
+		AddEdge("a", "b", "\x1b[2mafter 5s\x1b[0m")
+
+	lines := strings.Split(stripANSI(flow.Render()), "\n")
+	if strings.TrimSpace(lines[0]) != "┌────────┐" || strings.TrimSpace(lines[1]) != "│ Failed │" {
+		t.Errorf("Expected the box sized to the visible label, got:\n%s", strings.Join(lines, "\n"))
+	}
+
+	// The colour stops at the label, before the right border
+	if output := flow.Render(); !strings.Contains(output, "\x1b[31mFailed\x1b[0m │") {
+		t.Errorf("Expected the colour reset before the border, got %q", output)
+	}
+}
+
+func TestSequenceDiagram_RenderColouredNames(t *testing.T) {
+	seq := NewSequenceDiagram()
+	seq.AddActor("a", "\x1b[36mClient\x1b[0m").
+		AddActor("b", "Server").
+		AddMessage("a", "b", "\x1b[1mGET\x1b[0m /", MessageSync)
+
+	plain := NewSequenceDiagram()
+	plain.AddActor("a", "Client").
+		AddActor("b", "Server").
+		AddMessage("a", "b", "GET /", MessageSync)
+
+	if got, expected := stripANSI(seq.Render()), plain.Render(); got != expected {
+		t.Errorf("Expected the same layout as without colours:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+// stripANSI removes escape sequences from rendered output
+func stripANSI(s string) string {
+	var b strings.Builder
+	for _, run := range ParseStyledText(s, Style{}) {
+		b.WriteString(run.Text)
+	}
+	return b.String()
+}
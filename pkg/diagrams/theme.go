@@ -0,0 +1,178 @@
+package diagrams
+
+import "strings"
+
+// NodeStyle sets how a flowchart node is drawn
+type NodeStyle struct {
+	Border Style // Outline of the shape
+	Fill   Style // Cells inside the shape; only its background colour shows
+	Text   Style // Label text, drawn over the fill
+}
+
+// text returns the style of the label text, on the node's fill
+func (ns NodeStyle) text() Style {
+	return ns.Text.over(ns.Fill)
+}
+
+// Theme sets the style of each part of a diagram, so that flowcharts,
+// sequence diagrams and bar charts rendered with the same theme share a look.
+// The zero Theme draws everything in the terminal's default style, as Render
+// does.
+//
+// Colours and attributes written into labels, with escape codes or
+// StyledText, are drawn over the theme's text styles.
+type Theme struct {
+	Name       string
+	Node       NodeStyle               // Flowchart nodes, and sequence actor boxes
+	Shapes     map[NodeShape]NodeStyle // Flowchart nodes of these shapes, instead of Node
+	Edge       Style                   // Flowchart edges and sequence message arrows
+	EdgeLabel  Style                   // Edge labels and message text
+	Frame      Style                   // Subgraph frames
+	Title      Style                   // Subgraph and chart titles
+	Actor      Style                   // Sequence actor names
+	Lifeline   Style                   // Sequence lifelines
+	Activation Style                   // Sequence activation bars
+	Note       NodeStyle               // Sequence notes
+	Text       Style                   // Bar labels and values
+	Axis       Style                   // Bar chart axis
+	Palette    []string                // Bar colours by position, for bars without a Color
+}
+
+// NodeStyle returns the style of flowchart nodes of the given shape
+func (t *Theme) NodeStyle(shape NodeShape) NodeStyle {
+	if ns, ok := t.Shapes[shape]; ok {
+		return ns
+	}
+	return t.Node
+}
+
+// barColor returns the colour of the i-th bar of a chart
+func (t *Theme) barColor(i int, bar Bar) string {
+	if bar.Color != "" || len(t.Palette) == 0 {
+		return bar.Color
+	}
+	return t.Palette[i%len(t.Palette)]
+}
+
+// fg returns a style with an SGR foreground colour, such as 31 for red
+func fg(code int) Style {
+	return Style{Fg: sgr(code)}
+}
+
+// bold returns a style in bold with an SGR foreground colour
+func bold(code int) Style {
+	return Style{Fg: sgr(code), Bold: true}
+}
+
+// DarkTheme returns a theme for terminals with a dark background
+func DarkTheme() *Theme {
+	return &Theme{
+		Name: "dark",
+		Node: NodeStyle{Border: fg(36), Text: fg(97)},
+		Shapes: map[NodeShape]NodeStyle{
+			ShapeDiamond:      {Border: fg(33), Text: fg(93)},
+			ShapeStadium:      {Border: fg(32), Text: fg(97)},
+			ShapeCircle:       {Border: fg(32), Text: fg(97)},
+			ShapeDoubleCircle: {Border: fg(31), Text: fg(97)},
+			ShapeCylinder:     {Border: fg(35), Text: fg(97)},
+		},
+		Edge:       fg(37),
+		EdgeLabel:  Style{Fg: sgr(37), Italic: true},
+		Frame:      fg(90),
+		Title:      bold(97),
+		Actor:      bold(96),
+		Lifeline:   fg(90),
+		Activation: fg(96),
+		Note:       NodeStyle{Border: fg(33), Text: fg(93)},
+		Text:       fg(37),
+		Axis:       fg(90),
+		Palette:    []string{sgr(96), sgr(92), sgr(93), sgr(95), sgr(94), sgr(91)},
+	}
+}
+
+// LightTheme returns a theme for terminals with a light background
+func LightTheme() *Theme {
+	return &Theme{
+		Name: "light",
+		Node: NodeStyle{Border: fg(34), Text: fg(30)},
+		Shapes: map[NodeShape]NodeStyle{
+			ShapeDiamond:      {Border: fg(35), Text: fg(30)},
+			ShapeStadium:      {Border: fg(32), Text: fg(30)},
+			ShapeCircle:       {Border: fg(32), Text: fg(30)},
+			ShapeDoubleCircle: {Border: fg(31), Text: fg(30)},
+			ShapeCylinder:     {Border: fg(36), Text: fg(30)},
+		},
+		Edge:       fg(90),
+		EdgeLabel:  Style{Fg: sgr(30), Italic: true},
+		Frame:      fg(90),
+		Title:      bold(30),
+		Actor:      bold(34),
+		Lifeline:   fg(90),
+		Activation: fg(34),
+		Note:       NodeStyle{Border: fg(33), Text: fg(30)},
+		Text:       fg(30),
+		Axis:       fg(90),
+		Palette:    []string{sgr(34), sgr(32), sgr(35), sgr(31), sgr(36), sgr(33)},
+	}
+}
+
+// HighContrastTheme returns a theme of bold, bright colours on black, for
+// low-vision use and washed-out displays
+func HighContrastTheme() *Theme {
+	black := Style{Bg: sgr(40)}
+	return &Theme{
+		Name: "high-contrast",
+		Node: NodeStyle{Border: bold(97), Fill: black, Text: bold(97)},
+		Shapes: map[NodeShape]NodeStyle{
+			ShapeDiamond: {Border: bold(93), Fill: black, Text: bold(93)},
+		},
+		Edge:       bold(97),
+		EdgeLabel:  bold(93),
+		Frame:      fg(97),
+		Title:      Style{Fg: sgr(97), Bold: true, Underline: true},
+		Actor:      bold(93),
+		Lifeline:   fg(97),
+		Activation: bold(96),
+		Note:       NodeStyle{Border: bold(93), Fill: black, Text: bold(93)},
+		Text:       bold(97),
+		Axis:       fg(97),
+		Palette:    []string{sgr(93), sgr(96), sgr(95), sgr(92), sgr(91), sgr(97)},
+	}
+}
+
+// MonochromeTheme returns a theme without colours, which marks titles, names
+// and labels with bold, underline and italic only
+func MonochromeTheme() *Theme {
+	return &Theme{
+		Name: "monochrome",
+		Shapes: map[NodeShape]NodeStyle{
+			ShapeDiamond: {Text: Style{Bold: true}},
+		},
+		EdgeLabel: Style{Italic: true},
+		Title:     Style{Bold: true, Underline: true},
+		Actor:     Style{Bold: true},
+	}
+}
+
+// themes lists the built-in themes by name
+var themes = []func() *Theme{DarkTheme, LightTheme, HighContrastTheme, MonochromeTheme}
+
+// ThemeByName returns the built-in theme with the given name: "dark",
+// "light", "high-contrast" or "monochrome"
+func ThemeByName(name string) (*Theme, bool) {
+	for _, theme := range themes {
+		if t := theme(); strings.EqualFold(t.Name, name) {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// ThemeNames returns the names of the built-in themes
+func ThemeNames() []string {
+	names := make([]string, len(themes))
+	for i, theme := range themes {
+		names[i] = theme().Name
+	}
+	return names
+}
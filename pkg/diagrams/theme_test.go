@@ -0,0 +1,140 @@
+package diagrams
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestThemeByName(t *testing.T) {
+	for _, name := range ThemeNames() {
+		theme, ok := ThemeByName(name)
+		if !ok || theme.Name != name {
+			t.Errorf("ThemeByName(%q) = %v, %v", name, theme, ok)
+		}
+	}
+	if _, ok := ThemeByName("High-Contrast"); !ok {
+		t.Error("Expected theme names to be case-insensitive")
+	}
+	if _, ok := ThemeByName("neon"); ok {
+		t.Error("Expected no theme named neon")
+	}
+}
+
+func TestTheme_NodeStyle(t *testing.T) {
+	theme := DarkTheme()
+	if got := theme.NodeStyle(ShapeDiamond); got.Border != theme.Shapes[ShapeDiamond].Border {
+		t.Errorf("Expected the diamond style, got %+v", got)
+	}
+	if got := theme.NodeStyle(ShapeHexagon); got != theme.Node {
+		t.Errorf("Expected the default node style, got %+v", got)
+	}
+}
+
+func TestStyle_Over(t *testing.T) {
+	base := Style{Fg: sgr(37), Bg: sgr(40), Italic: true}
+	got := Style{Fg: sgr(31), Bold: true}.over(base)
+	want := Style{Fg: sgr(31), Bg: sgr(40), Bold: true, Italic: true}
+	if got != want {
+		t.Errorf("over() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDrawNode_Style(t *testing.T) {
+	ns := NodeStyle{Border: fg(36), Fill: Style{Bg: sgr(44)}, Text: fg(97)}
+	node := Node{Label: "a" + Styled("b", fg(31)).String()}
+	c := NewCanvas(7, 3)
+	drawNode(c, 0, 0, node, ns)
+
+	tests := []struct {
+		x, y int
+		want Style
+	}{
+		{0, 0, ns.Border},                       // Corner
+		{1, 1, ns.Fill},                         // Padding inside the box
+		{2, 1, Style{Fg: sgr(97), Bg: sgr(44)}}, // Label text on the fill
+		{3, 1, Style{Fg: sgr(31), Bg: sgr(44)}}, // Coloured label run keeps the fill
+	}
+	for _, tt := range tests {
+		if got := c.Cell(tt.x, tt.y).Style; got != tt.want {
+			t.Errorf("Cell(%d, %d) style = %+v, want %+v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestRenderWithOptions_Theme(t *testing.T) {
+	flow := NewFlowchart(TopToBottom).
+		AddNode("a", "Start", ShapeStadium).
+		AddNode("b", "Check", ShapeDiamond)
+	flow.AddEdge("a", "b", "go")
+	flow.AddSubgraph("s", "Group").Add("b")
+	seq := NewSequenceDiagram().AddActor("A", "Alice").AddActor("B", "Bob").
+		AddMessage("A", "B", "Hello", MessageSync)
+	chart := NewBarChart("Usage", Horizontal).AddBar("Go", 5)
+
+	for _, d := range []interface {
+		Render() string
+		RenderWithOptions(RenderOptions) string
+	}{flow, seq, chart} {
+		plain := d.Render()
+		if got := d.RenderWithOptions(RenderOptions{}); got != plain {
+			t.Errorf("Expected no theme to render as Render, got:\n%s", got)
+		}
+		for _, name := range ThemeNames() {
+			theme, _ := ThemeByName(name)
+			got := d.RenderWithOptions(RenderOptions{Theme: theme})
+			if stripANSI(got) != plain {
+				t.Errorf("Expected the %s theme to change only styles, got:\n%s", name, stripANSI(got))
+			}
+			if !strings.Contains(got, "\x1b[") {
+				t.Errorf("Expected the %s theme to style the output", name)
+			}
+		}
+	}
+}
+
+func TestRenderWithOptions_ThemeElements(t *testing.T) {
+	theme := DarkTheme()
+	opts := RenderOptions{Theme: theme}
+
+	flow := NewFlowchart(TopToBottom).AddNode("a", "A", ShapeBox).AddNode("b", "B", ShapeDiamond)
+	flow.AddEdge("a", "b", "")
+	output := flow.RenderWithOptions(opts)
+	for _, want := range []string{
+		theme.Node.Border.sequence() + "┌",
+		theme.Shapes[ShapeDiamond].Border.sequence() + "◆",
+		theme.Edge.sequence() + "↓",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected flowchart output to contain %q, got:\n%q", want, output)
+		}
+	}
+
+	seq := NewSequenceDiagram().AddActor("A", "Alice").AddActor("B", "Bob").
+		AddMessage("A", "B", "Hi", MessageSync)
+	output = seq.RenderWithOptions(opts)
+	for _, want := range []string{
+		theme.Actor.sequence() + "   Alice",
+		theme.Lifeline.sequence() + "│",
+		theme.EdgeLabel.sequence() + " Hi ",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected sequence output to contain %q, got:\n%q", want, output)
+		}
+	}
+}
+
+func TestBarChart_RenderWithOptions_Palette(t *testing.T) {
+	theme := &Theme{Palette: []string{sgr(31), sgr(32)}}
+	chart := NewBarChart("", Horizontal).SetWidth(4).
+		AddBar("a", 1).
+		AddBar("b", 1).
+		AddBarWithColor("c", 1, sgr(35)).
+		AddBar("d", 1)
+
+	lines := strings.Split(chart.RenderWithOptions(RenderOptions{Theme: theme}), "\n")
+	for i, colour := range []string{sgr(31), sgr(32), sgr(35), sgr(32)} {
+		if !strings.Contains(lines[i], colour+"████") {
+			t.Errorf("Expected bar %d in %q, got %q", i, colour, lines[i])
+		}
+	}
+}
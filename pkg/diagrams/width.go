@@ -0,0 +1,227 @@
+package diagrams
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Ellipsis marks text shortened by Truncate
+const Ellipsis = "…"
+
+// StringWidth returns the number of terminal columns s takes up.
+//
+// Text is measured by grapheme cluster: East Asian wide and fullwidth
+// characters and emoji take two columns, combining marks, variation
+// selectors and zero-width joiners take none, and an emoji ZWJ sequence or
+// flag counts as the single two-column glyph a terminal draws for it. ANSI
+// escape sequences, such as colours and hyperlinks, take no columns.
+func StringWidth(s string) int {
+	width := 0
+	for len(s) > 0 {
+		if s[0] == '\x1b' {
+			s = s[escapeLen(s):]
+			continue
+		}
+		g := nextGrapheme(s)
+		width += graphemeWidth(g)
+		s = s[len(g):]
+	}
+	return width
+}
+
+// Truncate shortens s to at most width columns, cutting between grapheme
+// clusters and ending with an ellipsis when anything was removed. Escape
+// sequences are kept, including those in the part removed, so that styles
+// still end where they did.
+func Truncate(s string, width int) string {
+	if StringWidth(s) <= width {
+		return s
+	}
+	if width <= 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	used := 0
+	for len(s) > 0 {
+		if s[0] == '\x1b' {
+			n := escapeLen(s)
+			b.WriteString(s[:n])
+			s = s[n:]
+			continue
+		}
+		g := nextGrapheme(s)
+		w := graphemeWidth(g)
+		if used+w > width-1 {
+			break
+		}
+		b.WriteString(g)
+		used += w
+		s = s[len(g):]
+	}
+	b.WriteString(Ellipsis)
+
+	for len(s) > 0 {
+		if s[0] == '\x1b' {
+			n := escapeLen(s)
+			b.WriteString(s[:n])
+			s = s[n:]
+		} else {
+			s = s[len(nextGrapheme(s)):]
+		}
+	}
+	return b.String()
+}
+
+// graphemes splits s into grapheme clusters
+func graphemes(s string) []string {
+	var clusters []string
+	for len(s) > 0 {
+		g := nextGrapheme(s)
+		clusters = append(clusters, g)
+		s = s[len(g):]
+	}
+	return clusters
+}
+
+// nextGrapheme returns the grapheme cluster at the start of s: a base
+// character with the marks, modifiers and joined characters that follow it.
+// It covers the cases of Unicode's segmentation rules (UAX #29) that matter
+// for display width: CR LF, combining and spacing marks, variation selectors,
+// emoji modifiers, ZWJ sequences and regional indicator pairs.
+func nextGrapheme(s string) string {
+	first, n := utf8.DecodeRuneInString(s)
+	if first == '\r' && strings.HasPrefix(s[n:], "\n") {
+		return s[:n+1]
+	}
+	if unicode.IsControl(first) {
+		return s[:n]
+	}
+
+	if isRegionalIndicator(first) {
+		if r, size := utf8.DecodeRuneInString(s[n:]); isRegionalIndicator(r) {
+			n += size
+		}
+	}
+
+	for n < len(s) {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		switch {
+		case r == zeroWidthJoiner:
+			n += size
+			// The joiner glues the next character into the cluster
+			if n < len(s) {
+				_, next := utf8.DecodeRuneInString(s[n:])
+				n += next
+			}
+		case isExtend(r):
+			n += size
+		default:
+			return s[:n]
+		}
+	}
+	return s[:n]
+}
+
+const (
+	zeroWidthJoiner     = '\u200d'
+	textPresentation    = '\ufe0e' // Variation selector 15
+	emojiPresentation   = '\ufe0f' // Variation selector 16
+	regionalIndicatorLo = 0x1f1e6
+	regionalIndicatorHi = 0x1f1ff
+)
+
+func isRegionalIndicator(r rune) bool {
+	return r >= regionalIndicatorLo && r <= regionalIndicatorHi
+}
+
+// isExtend reports whether r attaches to the character before it
+func isExtend(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) ||
+		(r >= 0x1f3fb && r <= 0x1f3ff) || // Emoji skin tone modifiers
+		(r >= 0xe0020 && r <= 0xe007f) // Tag characters, used in subdivision flags
+}
+
+// graphemeWidth returns the columns taken by a grapheme cluster, which is
+// the width of its base character unless a variation selector asks for
+// emoji (two columns) or text (one column) presentation
+func graphemeWidth(g string) int {
+	base, _ := utf8.DecodeRuneInString(g)
+	width := runeWidth(base)
+	switch {
+	case width == 0:
+		return 0
+	case isRegionalIndicator(base):
+		return 2
+	case strings.ContainsRune(g, emojiPresentation):
+		return 2
+	case strings.ContainsRune(g, textPresentation):
+		return 1
+	}
+	return width
+}
+
+// runeWidth returns the columns taken by a single character: 0 for control,
+// format and combining characters, 2 for wide characters and 1 otherwise.
+// Characters of ambiguous East Asian width count as narrow.
+func runeWidth(r rune) int {
+	switch {
+	case r == 0,
+		unicode.In(r, unicode.Cc, unicode.Cf, unicode.Mn, unicode.Me),
+		r >= 0x1160 && r <= 0x11ff: // Hangul vowels and final consonants that combine into a syllable
+		return 0
+	case isWide(r):
+		return 2
+	}
+	return 1
+}
+
+// isWide reports whether r is East Asian Wide or Fullwidth, which includes
+// emoji drawn in emoji presentation by default
+func isWide(r rune) bool {
+	if r < wideRanges[0][0] {
+		return false
+	}
+	lo, hi := 0, len(wideRanges)-1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		switch {
+		case r < wideRanges[mid][0]:
+			hi = mid - 1
+		case r > wideRanges[mid][1]:
+			lo = mid + 1
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// wideRanges lists the East Asian Wide (W) and Fullwidth (F) code points of
+// Unicode 15, in order
+var wideRanges = [][2]rune{
+	{0x1100, 0x115f}, {0x231a, 0x231b}, {0x2329, 0x232a}, {0x23e9, 0x23ec},
+	{0x23f0, 0x23f0}, {0x23f3, 0x23f3}, {0x25fd, 0x25fe}, {0x2614, 0x2615},
+	{0x2648, 0x2653}, {0x267f, 0x267f}, {0x2693, 0x2693}, {0x26a1, 0x26a1},
+	{0x26aa, 0x26ab}, {0x26bd, 0x26be}, {0x26c4, 0x26c5}, {0x26ce, 0x26ce},
+	{0x26d4, 0x26d4}, {0x26ea, 0x26ea}, {0x26f2, 0x26f3}, {0x26f5, 0x26f5},
+	{0x26fa, 0x26fa}, {0x26fd, 0x26fd}, {0x2705, 0x2705}, {0x270a, 0x270b},
+	{0x2728, 0x2728}, {0x274c, 0x274c}, {0x274e, 0x274e}, {0x2753, 0x2755},
+	{0x2757, 0x2757}, {0x2795, 0x2797}, {0x27b0, 0x27b0}, {0x27bf, 0x27bf},
+	{0x2b1b, 0x2b1c}, {0x2b50, 0x2b50}, {0x2b55, 0x2b55}, {0x2e80, 0x303e},
+	{0x3041, 0x33ff}, {0x3400, 0x4dbf}, {0x4e00, 0x9fff}, {0xa000, 0xa4cf},
+	{0xa960, 0xa97f}, {0xac00, 0xd7a3}, {0xf900, 0xfaff}, {0xfe10, 0xfe19},
+	{0xfe30, 0xfe6f}, {0xff00, 0xff60}, {0xffe0, 0xffe6}, {0x16fe0, 0x16fe4},
+	{0x17000, 0x18cff}, {0x1aff0, 0x1b2ff}, {0x1f004, 0x1f004}, {0x1f0cf, 0x1f0cf},
+	{0x1f18e, 0x1f18e}, {0x1f191, 0x1f19a}, {0x1f200, 0x1f202}, {0x1f210, 0x1f23b},
+	{0x1f240, 0x1f248}, {0x1f250, 0x1f251}, {0x1f260, 0x1f265}, {0x1f300, 0x1f320},
+	{0x1f32d, 0x1f335}, {0x1f337, 0x1f37c}, {0x1f37e, 0x1f393}, {0x1f3a0, 0x1f3ca},
+	{0x1f3cf, 0x1f3d3}, {0x1f3e0, 0x1f3f0}, {0x1f3f4, 0x1f3f4}, {0x1f3f8, 0x1f43e},
+	{0x1f440, 0x1f440}, {0x1f442, 0x1f4fc}, {0x1f4ff, 0x1f53d}, {0x1f54b, 0x1f54e},
+	{0x1f550, 0x1f567}, {0x1f57a, 0x1f57a}, {0x1f595, 0x1f596}, {0x1f5a4, 0x1f5a4},
+	{0x1f5fb, 0x1f64f}, {0x1f680, 0x1f6c5}, {0x1f6cc, 0x1f6cc}, {0x1f6d0, 0x1f6d2},
+	{0x1f6d5, 0x1f6d7}, {0x1f6dc, 0x1f6df}, {0x1f6eb, 0x1f6ec}, {0x1f6f4, 0x1f6fc},
+	{0x1f7e0, 0x1f7eb}, {0x1f7f0, 0x1f7f0}, {0x1f90c, 0x1f93a}, {0x1f93c, 0x1f945},
+	{0x1f947, 0x1f9ff}, {0x1fa70, 0x1faff}, {0x20000, 0x2fffd}, {0x30000, 0x3fffd},
+}
@@ -0,0 +1,87 @@
+package diagrams
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStringWidth(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		width int
+	}{
+		{"ascii", "Hello", 5},
+		{"precomposed accent", "café", 4},
+		{"combining accent", "cafe\u0301", 4},
+		{"cjk", "日本語", 6},
+		{"fullwidth", "ＡＢ", 4},
+		{"hangul", "한국", 4},
+		{"emoji", "🚀", 2},
+		{"emoji with skin tone", "👍🏽", 2},
+		{"zwj sequence", "👩\u200d💻", 2},
+		{"flag", "🇯🇵", 2},
+		{"emoji presentation", "❤\ufe0f", 2},
+		{"text presentation", "⌚\ufe0e", 1},
+		{"control", "a\tb", 2},
+		{"box drawing", "┌─┐", 3},
+		{"empty", "", 0},
+	}
+
+	for _, tt := range tests {
+		if got := StringWidth(tt.input); got != tt.width {
+			t.Errorf("%s: StringWidth(%q) = %d, expected %d", tt.name, tt.input, got, tt.width)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		input    string
+		width    int
+		expected string
+	}{
+		{"Hello", 10, "Hello"},
+		{"Hello", 5, "Hello"},
+		{"Hello World", 8, "Hello W…"},
+		{"日本語テキスト", 7, "日本語…"},
+		{"日本語", 4, "日…"},
+		{"cafe\u0301 noir", 5, "cafe\u0301…"},
+		{"👩\u200d💻 coder", 3, "👩\u200d💻…"},
+		{"Hello", 1, "…"},
+		{"Hello", 0, ""},
+	}
+
+	for _, tt := range tests {
+		got := Truncate(tt.input, tt.width)
+		if got != tt.expected {
+			t.Errorf("Truncate(%q, %d) = %q, expected %q", tt.input, tt.width, got, tt.expected)
+		}
+		if StringWidth(got) > tt.width {
+			t.Errorf("Truncate(%q, %d) is %d columns wide", tt.input, tt.width, StringWidth(got))
+		}
+	}
+}
+
+func TestFlowchart_RenderWideLabels(t *testing.T) {
+	flow := NewFlowchart(TopToBottom)
+	flow.AddNode("a", "開始", ShapeBox).
+		AddNode("b", "Café ☕", ShapeRounded).
+		AddNode("c", "🚀 Launch", ShapeBox).
+		AddEdge("a", "b", "はい").
+		AddEdge("b", "c", "")
+
+	// Every border lines up with the label between it, so each node's rows
+	// are the same width on screen
+	lines := strings.Split(flow.Render(), "\n")
+	for i := 0; i+2 < len(lines); i++ {
+		top := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(top, "┌") && !strings.HasPrefix(top, "╭") {
+			continue
+		}
+		middle, bottom := strings.TrimSpace(lines[i+1]), strings.TrimSpace(lines[i+2])
+		if StringWidth(top) != StringWidth(middle) || StringWidth(middle) != StringWidth(bottom) {
+			t.Errorf("Expected aligned borders, got:\n%s\n%s\n%s", top, middle, bottom)
+		}
+	}
+}